@@ -0,0 +1,148 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	"github.com/pivotal-golang/lager"
+)
+
+type handler struct {
+	logger   lager.Logger
+	proxy    *proxy.Proxy
+	registry *proxy.SessionRegistry
+	username string
+	password string
+}
+
+// New returns the admin API of a proxy. Every request must present the
+// credentials with basic authentication.
+//
+//	GET    /sessions[?user=&app_guid=]  lists live sessions
+//	DELETE /sessions?user=&app_guid=    terminates the matching sessions
+//	DELETE /sessions/:id                terminates a single session
+//	GET    /maintenance                 shows the maintenance status
+//	PUT    /maintenance                 refuses new logins
+//	DELETE /maintenance                 accepts logins again
+func New(logger lager.Logger, sshProxy *proxy.Proxy, username, password string) http.Handler {
+	h := &handler{
+		logger:   logger.Session("admin"),
+		proxy:    sshProxy,
+		registry: sshProxy.Sessions(),
+		username: username,
+		password: password,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", h.sessions)
+	mux.HandleFunc("/sessions/", h.session)
+	mux.HandleFunc("/maintenance", h.maintenance)
+
+	return h.authenticate(mux)
+}
+
+func (h *handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(h.username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(h.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ssh-proxy"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *handler) sessions(w http.ResponseWriter, r *http.Request) {
+	user := r.URL.Query().Get("user")
+	appGuid := r.URL.Query().Get("app_guid")
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, h.registry.List(user, appGuid))
+
+	case "DELETE":
+		if user == "" && appGuid == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"user or app_guid is required"})
+			return
+		}
+
+		terminated := h.registry.TerminateMatching(user, appGuid)
+		h.logger.Info("terminated-sessions", lager.Data{"user": user, "app-guid": appGuid, "count": terminated})
+
+		writeJSON(w, http.StatusOK, terminateResponse{terminated})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *handler) session(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/sessions/")
+
+	if r.Method != "DELETE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.registry.Terminate(id) {
+		writeJSON(w, http.StatusNotFound, errorResponse{"session not found"})
+		return
+	}
+
+	h.logger.Info("terminated-session", lager.Data{"id": id})
+	writeJSON(w, http.StatusOK, terminateResponse{1})
+}
+
+func (h *handler) maintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, h.proxy.Maintenance())
+
+	case "PUT":
+		var request maintenanceRequest
+		if r.ContentLength != 0 {
+			err := json.NewDecoder(r.Body).Decode(&request)
+			if err != nil || request.DrainSeconds < 0 {
+				writeJSON(w, http.StatusBadRequest, errorResponse{"invalid maintenance request"})
+				return
+			}
+		}
+
+		h.proxy.EnterMaintenance(request.Message, time.Duration(request.DrainSeconds)*time.Second)
+		writeJSON(w, http.StatusOK, h.proxy.Maintenance())
+
+	case "DELETE":
+		h.proxy.ExitMaintenance()
+		writeJSON(w, http.StatusOK, h.proxy.Maintenance())
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type maintenanceRequest struct {
+	Message      string `json:"message"`
+	DrainSeconds int    `json:"drain_seconds"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type terminateResponse struct {
+	Terminated int `json:"terminated"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
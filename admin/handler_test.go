@@ -0,0 +1,180 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/admin"
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+	"golang.org/x/crypto/ssh"
+)
+
+var _ = Describe("Admin API", func() {
+	var (
+		sshProxy   *proxy.Proxy
+		registry   *proxy.SessionRegistry
+		terminated []string
+		handler    http.Handler
+		recorder   *httptest.ResponseRecorder
+	)
+
+	register := func(id, user, appGuid string) {
+		registry.Register(proxy.SessionInfo{ID: id, User: user, AppGuid: appGuid}, func() {
+			terminated = append(terminated, id)
+		})
+	}
+
+	requestWithBody := func(method, path string, body io.Reader) {
+		req, err := http.NewRequest(method, path, body)
+		Expect(err).NotTo(HaveOccurred())
+		req.SetBasicAuth("admin", "secret")
+
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+	}
+
+	request := func(method, path string, authenticated bool) {
+		req, err := http.NewRequest(method, path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		if authenticated {
+			req.SetBasicAuth("admin", "secret")
+		}
+
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+	}
+
+	BeforeEach(func() {
+		sshProxy = proxy.New(lagertest.NewTestLogger("test"), &ssh.ServerConfig{}, nil, nil, nil, proxy.Timeouts{}, proxy.DialConfig{})
+		registry = sshProxy.Sessions()
+		terminated = []string{}
+		handler = admin.New(lagertest.NewTestLogger("test"), sshProxy, "admin", "secret")
+
+		register("session-1", "cf:app-1/0", "app-1")
+		register("session-2", "cf:app-2/0", "app-2")
+		register("session-3", "cf:app-2/1", "app-2")
+	})
+
+	Context("when the request is not authenticated", func() {
+		It("responds with 401", func() {
+			request("GET", "/sessions", false)
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("when the credentials are wrong", func() {
+		It("responds with 401", func() {
+			req, err := http.NewRequest("GET", "/sessions", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.SetBasicAuth("admin", "wrong")
+
+			recorder = httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Describe("GET /sessions", func() {
+		It("lists the live sessions", func() {
+			request("GET", "/sessions", true)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			var sessions []proxy.SessionInfo
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &sessions)).To(Succeed())
+			Expect(sessions).To(HaveLen(3))
+		})
+
+		It("filters by app guid", func() {
+			request("GET", "/sessions?app_guid=app-2", true)
+
+			var sessions []proxy.SessionInfo
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &sessions)).To(Succeed())
+			Expect(sessions).To(HaveLen(2))
+			for _, session := range sessions {
+				Expect(session.AppGuid).To(Equal("app-2"))
+			}
+		})
+	})
+
+	Describe("DELETE /sessions/:id", func() {
+		It("terminates the session", func() {
+			request("DELETE", "/sessions/session-2", true)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(terminated).To(Equal([]string{"session-2"}))
+		})
+
+		It("responds with 404 for unknown sessions", func() {
+			request("DELETE", "/sessions/unknown", true)
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			Expect(terminated).To(BeEmpty())
+		})
+	})
+
+	Describe("DELETE /sessions", func() {
+		It("terminates the sessions of a user", func() {
+			request("DELETE", "/sessions?user=cf:app-1/0", true)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"terminated": 1}`))
+			Expect(terminated).To(Equal([]string{"session-1"}))
+		})
+
+		It("terminates the sessions of an app", func() {
+			request("DELETE", "/sessions?app_guid=app-2", true)
+			Expect(recorder.Body.String()).To(MatchJSON(`{"terminated": 2}`))
+			Expect(terminated).To(ConsistOf("session-2", "session-3"))
+		})
+
+		It("refuses to terminate every session", func() {
+			request("DELETE", "/sessions", true)
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+			Expect(terminated).To(BeEmpty())
+		})
+	})
+
+	Describe("/maintenance", func() {
+		It("reports that the proxy is not in maintenance", func() {
+			request("GET", "/maintenance", true)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"enabled": false}`))
+		})
+
+		It("enters maintenance with the message", func() {
+			requestWithBody("PUT", "/maintenance", strings.NewReader(`{"message": "back at noon", "drain_seconds": 60}`))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			status := sshProxy.Maintenance()
+			Expect(status.Enabled).To(BeTrue())
+			Expect(status.Message).To(Equal("back at noon"))
+			Expect(status.DrainDeadline).NotTo(BeNil())
+			Expect(*status.DrainDeadline).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+		})
+
+		It("uses the default message when none is given", func() {
+			requestWithBody("PUT", "/maintenance", nil)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(sshProxy.Maintenance().Message).To(Equal(proxy.DefaultMaintenanceMessage))
+			Expect(sshProxy.Maintenance().DrainDeadline).To(BeNil())
+		})
+
+		It("rejects invalid requests", func() {
+			requestWithBody("PUT", "/maintenance", strings.NewReader(`{"drain_seconds": -1}`))
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+			Expect(sshProxy.Maintenance().Enabled).To(BeFalse())
+		})
+
+		It("leaves maintenance", func() {
+			sshProxy.EnterMaintenance("down", 0)
+
+			request("DELETE", "/maintenance", true)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(sshProxy.Maintenance().Enabled).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,237 @@
+package algorithms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	msgKexInit = 20
+
+	// maxCapture bounds the bytes kept per direction while waiting for the
+	// version line and the first key exchange packet.
+	maxCapture = 64 * 1024
+)
+
+var (
+	KexInitIncompleteErr = errors.New("key exchange init not observed")
+	KexInitMalformedErr  = errors.New("malformed key exchange init")
+	NoCommonAlgorithmErr = errors.New("no common algorithm")
+)
+
+// Negotiated holds the algorithms chosen by the initial key exchange.
+type Negotiated struct {
+	KeyExchange    string
+	HostKey        string
+	CipherToServer string
+	CipherToClient string
+	MACToServer    string
+	MACToClient    string
+}
+
+func (n Negotiated) Data() lager.Data {
+	return lager.Data{
+		"kex":              n.KeyExchange,
+		"host-key":         n.HostKey,
+		"cipher-to-server": n.CipherToServer,
+		"cipher-to-client": n.CipherToClient,
+		"mac-to-server":    n.MACToServer,
+		"mac-to-client":    n.MACToClient,
+	}
+}
+
+// Conn wraps the server side of a connection and captures the version lines
+// and key exchange init messages that both sides send in the clear, so the
+// algorithms negotiated by the ssh library can be reported.
+type Conn struct {
+	net.Conn
+
+	mutex  sync.Mutex
+	client capture
+	server capture
+}
+
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{Conn: conn}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	c.mutex.Lock()
+	c.client.write(b[:n])
+	c.mutex.Unlock()
+
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	c.server.write(b)
+	c.mutex.Unlock()
+
+	return c.Conn.Write(b)
+}
+
+// Negotiated applies the negotiation rules of RFC 4253 section 7.1 to the
+// captured key exchange init messages.
+func (c *Conn) Negotiated() (Negotiated, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	client, err := c.client.kexInit()
+	if err != nil {
+		return Negotiated{}, err
+	}
+
+	server, err := c.server.kexInit()
+	if err != nil {
+		return Negotiated{}, err
+	}
+
+	negotiated := Negotiated{}
+	choices := []struct {
+		result *string
+		index  int
+	}{
+		{&negotiated.KeyExchange, 0},
+		{&negotiated.HostKey, 1},
+		{&negotiated.CipherToServer, 2},
+		{&negotiated.CipherToClient, 3},
+		{&negotiated.MACToServer, 4},
+		{&negotiated.MACToClient, 5},
+	}
+
+	for _, choice := range choices {
+		algorithm, err := firstCommon(client[choice.index], server[choice.index])
+		if err != nil {
+			return Negotiated{}, err
+		}
+		*choice.result = algorithm
+	}
+
+	// AEAD ciphers provide their own integrity.
+	if strings.HasSuffix(negotiated.CipherToServer, "-gcm@openssh.com") {
+		negotiated.MACToServer = ""
+	}
+	if strings.HasSuffix(negotiated.CipherToClient, "-gcm@openssh.com") {
+		negotiated.MACToClient = ""
+	}
+
+	return negotiated, nil
+}
+
+type capture struct {
+	data []byte
+	done bool
+}
+
+func (c *capture) write(b []byte) {
+	if c.done {
+		return
+	}
+
+	c.data = append(c.data, b...)
+	if len(c.data) >= maxCapture {
+		c.done = true
+		return
+	}
+
+	if _, err := c.kexInit(); err == nil {
+		c.done = true
+	}
+}
+
+// kexInit returns the first six name-lists of the first packet following the
+// version line.
+func (c *capture) kexInit() ([][]string, error) {
+	data := c.data
+
+	for {
+		eol := bytes.IndexByte(data, '\n')
+		if eol < 0 {
+			return nil, KexInitIncompleteErr
+		}
+
+		line := data[:eol]
+		data = data[eol+1:]
+
+		if bytes.HasPrefix(line, []byte("SSH-")) {
+			break
+		}
+	}
+
+	if len(data) < 5 {
+		return nil, KexInitIncompleteErr
+	}
+
+	packetLength := int(binary.BigEndian.Uint32(data))
+	paddingLength := int(data[4])
+	if len(data) < 4+packetLength {
+		return nil, KexInitIncompleteErr
+	}
+
+	if packetLength < paddingLength+1 {
+		return nil, KexInitMalformedErr
+	}
+
+	payload := data[5 : 4+packetLength-paddingLength]
+	if len(payload) < 17 || payload[0] != msgKexInit {
+		return nil, KexInitMalformedErr
+	}
+
+	payload = payload[17:]
+
+	lists := [][]string{}
+	for i := 0; i < 6; i++ {
+		if len(payload) < 4 {
+			return nil, KexInitMalformedErr
+		}
+
+		length := int(binary.BigEndian.Uint32(payload))
+		if len(payload) < 4+length {
+			return nil, KexInitMalformedErr
+		}
+
+		lists = append(lists, strings.Split(string(payload[4:4+length]), ","))
+		payload = payload[4+length:]
+	}
+
+	return lists, nil
+}
+
+func firstCommon(client, server []string) (string, error) {
+	for _, c := range client {
+		for _, s := range server {
+			if c == s {
+				return c, nil
+			}
+		}
+	}
+	return "", NoCommonAlgorithmErr
+}
+
+// LogNegotiated logs the client version and the negotiated algorithms of an
+// established connection.
+func LogNegotiated(logger lager.Logger, conn *Conn, metadata ssh.ConnMetadata) {
+	data := lager.Data{"client-version": string(metadata.ClientVersion())}
+
+	negotiated, err := conn.Negotiated()
+	if err != nil {
+		logger.Error("negotiated-algorithms-unknown", err, data)
+		return
+	}
+
+	for key, value := range negotiated.Data() {
+		data[key] = value
+	}
+
+	logger.Info("negotiated-algorithms", data)
+}
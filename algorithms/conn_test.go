@@ -0,0 +1,142 @@
+package algorithms_test
+
+import (
+	"net"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/algorithms"
+	"github.com/cloudfoundry-incubator/diego-ssh/keys"
+	"github.com/cloudfoundry-incubator/diego-ssh/test_helpers"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/pivotal-golang/lager/lagertest"
+	"golang.org/x/crypto/ssh"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conn", func() {
+	var (
+		serverConfig *ssh.ServerConfig
+		clientConfig *ssh.ClientConfig
+
+		conn       *algorithms.Conn
+		serverConn *ssh.ServerConn
+		clientNet  net.Conn
+	)
+
+	BeforeEach(func() {
+		hostKey, err := keys.RSAKeyPairFactory.NewKeyPair(1024)
+		Expect(err).NotTo(HaveOccurred())
+
+		serverConfig = &ssh.ServerConfig{NoClientAuth: true}
+		serverConfig.AddHostKey(hostKey.PrivateKey())
+
+		clientConfig = &ssh.ClientConfig{
+			User:          "user",
+			ClientVersion: "SSH-2.0-TestClient_1.0",
+		}
+	})
+
+	JustBeforeEach(func() {
+		var serverNet net.Conn
+		clientNet, serverNet = test_helpers.Pipe()
+		conn = algorithms.NewConn(serverNet)
+
+		clientErr := make(chan error, 1)
+		go func() {
+			c, _, _, err := ssh.NewClientConn(clientNet, "0.0.0.0", clientConfig)
+			if err == nil {
+				go c.Wait()
+			}
+			clientErr <- err
+		}()
+
+		var err error
+		serverConn, _, _, err = ssh.NewServerConn(conn, serverConfig)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(clientErr).Should(Receive(BeNil()))
+	})
+
+	AfterEach(func() {
+		serverConn.Close()
+		clientNet.Close()
+	})
+
+	Context("when the client prefers particular algorithms", func() {
+		BeforeEach(func() {
+			clientConfig.KeyExchanges = []string{"ecdh-sha2-nistp384", "ecdh-sha2-nistp256"}
+			clientConfig.Ciphers = []string{"aes192-ctr", "aes128-ctr"}
+			clientConfig.MACs = []string{"hmac-sha1", "hmac-sha2-256"}
+		})
+
+		It("reports the algorithms the client prefers", func() {
+			negotiated, err := conn.Negotiated()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(negotiated.KeyExchange).To(Equal("ecdh-sha2-nistp384"))
+			Expect(negotiated.HostKey).NotTo(BeEmpty())
+			Expect(negotiated.CipherToServer).To(Equal("aes192-ctr"))
+			Expect(negotiated.CipherToClient).To(Equal("aes192-ctr"))
+			Expect(negotiated.MACToServer).To(Equal("hmac-sha1"))
+			Expect(negotiated.MACToClient).To(Equal("hmac-sha1"))
+		})
+	})
+
+	Context("when the server is restricted by a profile", func() {
+		BeforeEach(func() {
+			algorithms.Profile{
+				Ciphers: []string{"aes256-ctr"},
+				MACs:    []string{"hmac-sha2-256"},
+			}.Apply(&serverConfig.Config)
+		})
+
+		It("reports the algorithms allowed by the server", func() {
+			negotiated, err := conn.Negotiated()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(negotiated.CipherToServer).To(Equal("aes256-ctr"))
+			Expect(negotiated.MACToClient).To(Equal("hmac-sha2-256"))
+		})
+	})
+
+	Context("when an AEAD cipher is negotiated", func() {
+		BeforeEach(func() {
+			clientConfig.Ciphers = []string{"aes128-gcm@openssh.com"}
+		})
+
+		It("does not report a MAC", func() {
+			negotiated, err := conn.Negotiated()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(negotiated.CipherToServer).To(Equal("aes128-gcm@openssh.com"))
+			Expect(negotiated.MACToServer).To(BeEmpty())
+			Expect(negotiated.MACToClient).To(BeEmpty())
+		})
+	})
+
+	Describe("LogNegotiated", func() {
+		It("logs the client version and the algorithms", func() {
+			logger := lagertest.NewTestLogger("test")
+			algorithms.LogNegotiated(logger, conn, serverConn)
+
+			Expect(logger).To(gbytes.Say("negotiated-algorithms"))
+			Expect(logger).To(gbytes.Say("SSH-2.0-TestClient_1.0"))
+		})
+	})
+})
+
+var _ = Describe("Conn without a handshake", func() {
+	It("reports that the key exchange was not observed", func() {
+		clientNet, serverNet := test_helpers.Pipe()
+		defer clientNet.Close()
+
+		conn := algorithms.NewConn(serverNet)
+		defer conn.Close()
+
+		_, err := conn.Write([]byte("SSH-2.0-Server\r\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = conn.Negotiated()
+		Expect(err).To(Equal(algorithms.KexInitIncompleteErr))
+	})
+})
@@ -0,0 +1,117 @@
+package algorithms
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Profile restricts the algorithms offered during the key exchange. Empty
+// lists leave the library defaults in place.
+type Profile struct {
+	Ciphers      []string
+	KeyExchanges []string
+	MACs         []string
+}
+
+var Profiles = map[string]Profile{
+	"modern": {
+		Ciphers:      []string{"aes128-gcm@openssh.com", "aes256-ctr", "aes192-ctr", "aes128-ctr"},
+		KeyExchanges: []string{"curve25519-sha256@libssh.org", "ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521"},
+		MACs:         []string{"hmac-sha2-256"},
+	},
+	"fips": {
+		Ciphers:      []string{"aes128-gcm@openssh.com", "aes256-ctr", "aes192-ctr", "aes128-ctr"},
+		KeyExchanges: []string{"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521", "diffie-hellman-group14-sha256"},
+		MACs:         []string{"hmac-sha2-256", "hmac-sha1"},
+	},
+	"compatible": {
+		Ciphers:      []string{"aes128-gcm@openssh.com", "aes256-ctr", "aes192-ctr", "aes128-ctr", "arcfour256", "arcfour128"},
+		KeyExchanges: []string{"curve25519-sha256@libssh.org", "ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521", "diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1"},
+		MACs:         []string{"hmac-sha2-256", "hmac-sha1", "hmac-sha1-96"},
+	},
+}
+
+// NewProfile starts from the named profile, or the library defaults when the
+// name is empty, and replaces each list that is given as a comma separated
+// string.
+func NewProfile(name, ciphers, keyExchanges, macs string) (Profile, error) {
+	profile := Profile{}
+
+	if name != "" {
+		named, ok := Profiles[name]
+		if !ok {
+			return Profile{}, fmt.Errorf("unknown algorithm profile %q, expected one of %s", name, strings.Join(profileNames(), ", "))
+		}
+		profile = named
+	}
+
+	if ciphers != "" {
+		profile.Ciphers = splitList(ciphers)
+	}
+	if keyExchanges != "" {
+		profile.KeyExchanges = splitList(keyExchanges)
+	}
+	if macs != "" {
+		profile.MACs = splitList(macs)
+	}
+
+	if err := profile.Validate(); err != nil {
+		return Profile{}, err
+	}
+
+	return profile, nil
+}
+
+// Validate checks that the library supports every algorithm of the profile.
+func (p Profile) Validate() error {
+	if err := validate("cipher", p.Ciphers, SupportsCipher); err != nil {
+		return err
+	}
+	if err := validate("key exchange", p.KeyExchanges, SupportsKeyExchange); err != nil {
+		return err
+	}
+	return validate("MAC", p.MACs, SupportsMAC)
+}
+
+func (p Profile) Apply(config *ssh.Config) {
+	if len(p.Ciphers) > 0 {
+		config.Ciphers = p.Ciphers
+	}
+	if len(p.KeyExchanges) > 0 {
+		config.KeyExchanges = p.KeyExchanges
+	}
+	if len(p.MACs) > 0 {
+		config.MACs = p.MACs
+	}
+}
+
+func validate(kind string, algorithms []string, supported func(string) bool) error {
+	for _, algorithm := range algorithms {
+		if !supported(algorithm) {
+			return fmt.Errorf("unsupported %s algorithm %q", kind, algorithm)
+		}
+	}
+	return nil
+}
+
+func splitList(list string) []string {
+	values := []string{}
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func profileNames() []string {
+	names := []string{}
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
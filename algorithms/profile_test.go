@@ -0,0 +1,92 @@
+package algorithms_test
+
+import (
+	"github.com/cloudfoundry-incubator/diego-ssh/algorithms"
+	"golang.org/x/crypto/ssh"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Profile", func() {
+	Describe("NewProfile", func() {
+		It("returns the named profile", func() {
+			profile, err := algorithms.NewProfile("modern", "", "", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(profile).To(Equal(algorithms.Profiles["modern"]))
+		})
+
+		It("only uses supported algorithms in the named profiles", func() {
+			for _, profile := range algorithms.Profiles {
+				Expect(profile.Validate()).To(Succeed())
+			}
+		})
+
+		It("only uses SHA-2 key exchanges in the fips profile", func() {
+			Expect(algorithms.Profiles["fips"].KeyExchanges).NotTo(ContainElement(ContainSubstring("sha1")))
+		})
+
+		It("replaces the lists that are given", func() {
+			profile, err := algorithms.NewProfile("fips", "aes256-ctr, aes128-ctr", "", "hmac-sha2-256")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(profile.Ciphers).To(Equal([]string{"aes256-ctr", "aes128-ctr"}))
+			Expect(profile.KeyExchanges).To(Equal(algorithms.Profiles["fips"].KeyExchanges))
+			Expect(profile.MACs).To(Equal([]string{"hmac-sha2-256"}))
+		})
+
+		It("leaves the library defaults in place without a profile", func() {
+			profile, err := algorithms.NewProfile("", "", "ecdh-sha2-nistp256", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(profile.Ciphers).To(BeEmpty())
+			Expect(profile.KeyExchanges).To(Equal([]string{"ecdh-sha2-nistp256"}))
+			Expect(profile.MACs).To(BeEmpty())
+		})
+
+		It("fails for an unknown profile", func() {
+			_, err := algorithms.NewProfile("legacy", "", "", "")
+			Expect(err).To(MatchError(ContainSubstring("compatible, fips, modern")))
+		})
+
+		It("accepts the insecure algorithms the library implements", func() {
+			profile, err := algorithms.NewProfile("", "arcfour256", "diffie-hellman-group1-sha1", "hmac-sha1-96")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(profile.Ciphers).To(Equal([]string{"arcfour256"}))
+		})
+
+		It("accepts the libssh name of curve25519", func() {
+			_, err := algorithms.NewProfile("", "", "curve25519-sha256@libssh.org", "")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("fails for unsupported algorithms", func() {
+			_, err := algorithms.NewProfile("", "blowfish-cbc", "", "")
+			Expect(err).To(MatchError(`unsupported cipher algorithm "blowfish-cbc"`))
+
+			_, err = algorithms.NewProfile("", "", "sntrup761x25519-sha512@openssh.com", "")
+			Expect(err).To(MatchError(ContainSubstring("unsupported key exchange algorithm")))
+
+			_, err = algorithms.NewProfile("", "", "", "hmac-md5")
+			Expect(err).To(MatchError(ContainSubstring("unsupported MAC algorithm")))
+		})
+	})
+
+	Describe("Apply", func() {
+		It("sets the lists of the config", func() {
+			config := &ssh.Config{}
+			algorithms.Profiles["modern"].Apply(config)
+
+			Expect(config.Ciphers).To(Equal(algorithms.Profiles["modern"].Ciphers))
+			Expect(config.KeyExchanges).To(Equal(algorithms.Profiles["modern"].KeyExchanges))
+			Expect(config.MACs).To(Equal(algorithms.Profiles["modern"].MACs))
+		})
+
+		It("keeps the existing lists for empty ones", func() {
+			config := &ssh.Config{Ciphers: []string{"aes128-ctr"}}
+			algorithms.Profile{MACs: []string{"hmac-sha1"}}.Apply(config)
+
+			Expect(config.Ciphers).To(Equal([]string{"aes128-ctr"}))
+			Expect(config.KeyExchanges).To(BeNil())
+			Expect(config.MACs).To(Equal([]string{"hmac-sha1"}))
+		})
+	})
+})
@@ -0,0 +1,36 @@
+package algorithms
+
+import "golang.org/x/crypto/ssh"
+
+// The library implements the algorithms it lists as supported and, when they
+// are configured explicitly, the algorithms it lists as insecure.
+
+// keyExchangeAliases are other names the library accepts for the key
+// exchanges it lists.
+var keyExchangeAliases = map[string]string{
+	"curve25519-sha256@libssh.org": ssh.KeyExchangeCurve25519,
+}
+
+func SupportsCipher(cipher string) bool {
+	return implemented(cipher, ssh.SupportedAlgorithms().Ciphers, ssh.InsecureAlgorithms().Ciphers)
+}
+
+func SupportsKeyExchange(keyExchange string) bool {
+	if name, ok := keyExchangeAliases[keyExchange]; ok {
+		keyExchange = name
+	}
+	return implemented(keyExchange, ssh.SupportedAlgorithms().KeyExchanges, ssh.InsecureAlgorithms().KeyExchanges)
+}
+
+func SupportsMAC(mac string) bool {
+	return implemented(mac, ssh.SupportedAlgorithms().MACs, ssh.InsecureAlgorithms().MACs)
+}
+
+func implemented(algorithm string, supported, insecure []string) bool {
+	for _, name := range append(supported, insecure...) {
+		if name == algorithm {
+			return true
+		}
+	}
+	return false
+}
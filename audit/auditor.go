@@ -0,0 +1,334 @@
+package audit
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/identity"
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+type PasswordCallback func(metadata ssh.ConnMetadata, password []byte) (*ssh.Permissions, error)
+type PublicKeyCallback func(metadata ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error)
+
+// Auditor emits audit events to a sink. A nil auditor emits nothing.
+type Auditor struct {
+	logger lager.Logger
+	sink   Sink
+}
+
+func NewAuditor(logger lager.Logger, sink Sink) *Auditor {
+	return &Auditor{
+		logger: logger.Session("auditor"),
+		sink:   sink,
+	}
+}
+
+func (a *Auditor) Emit(event Event) {
+	if a == nil {
+		return
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	err := a.sink.Send(event)
+	if err != nil {
+		a.logger.Error("send-failed", err, lager.Data{"type": event.Type})
+	}
+}
+
+// PasswordCallback wraps a password callback to emit the outcome of each
+// authentication attempt.
+func (a *Auditor) PasswordCallback(callback PasswordCallback) PasswordCallback {
+	if a == nil {
+		return callback
+	}
+
+	return func(metadata ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		permissions, err := callback(metadata, password)
+		a.emitAuth(metadata, permissions, err)
+		return permissions, err
+	}
+}
+
+// PublicKeyCallback wraps a public key callback to emit the outcome of each
+// authentication attempt.
+func (a *Auditor) PublicKeyCallback(callback PublicKeyCallback) PublicKeyCallback {
+	if a == nil {
+		return callback
+	}
+
+	return func(metadata ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		permissions, err := callback(metadata, key)
+		a.emitAuth(metadata, permissions, err)
+		return permissions, err
+	}
+}
+
+func (a *Auditor) emitAuth(metadata ssh.ConnMetadata, permissions *ssh.Permissions, err error) {
+	event := connectionEvent(metadata)
+	if err != nil {
+		event.Type = AuthFailed
+		event.Error = err.Error()
+	} else {
+		event.Type = AuthSucceeded
+		if userIdentity, _ := identity.FromPermissions(permissions); userIdentity != nil {
+			event.UserID = userIdentity.UserID
+			event.UserName = userIdentity.UserName
+			event.Realm = userIdentity.Realm
+		}
+	}
+	a.Emit(event)
+}
+
+func (a *Auditor) Session(metadata ssh.ConnMetadata) *Session {
+	if a == nil {
+		return nil
+	}
+
+	return &Session{
+		auditor: a,
+		base:    connectionEvent(metadata),
+	}
+}
+
+func connectionEvent(metadata ssh.ConnMetadata) Event {
+	event := Event{
+		SessionID: hex.EncodeToString(metadata.SessionID()),
+		User:      metadata.User(),
+	}
+
+	if metadata.RemoteAddr() != nil {
+		event.RemoteAddr = metadata.RemoteAddr().String()
+	}
+
+	return event
+}
+
+// Session emits the events of a single proxied connection. A nil session
+// emits nothing.
+type Session struct {
+	auditor *Auditor
+	base    Event
+	started time.Time
+
+	bytesIn  int64
+	bytesOut int64
+}
+
+func (s *Session) SetTarget(address string, logGuid string, index int) {
+	if s == nil {
+		return
+	}
+
+	s.base.TargetAddress = address
+	s.base.LogGuid = logGuid
+	s.base.Index = &index
+}
+
+// SetTargets records the instances of a login that reaches several of them.
+func (s *Session) SetTargets(address string, logGuid string, indexes []int) {
+	if s == nil {
+		return
+	}
+
+	s.base.TargetAddress = address
+	s.base.LogGuid = logGuid
+	s.base.Index = nil
+	s.base.Indexes = indexes
+}
+
+// SetIdentity records the end user the session was authenticated as. The
+// user of the connection is the principal of the target, not the end user.
+func (s *Session) SetIdentity(userID string, userName string, realm string) {
+	if s == nil {
+		return
+	}
+
+	s.base.UserID = userID
+	s.base.UserName = userName
+	s.base.Realm = realm
+}
+
+// SetOrigin records the connection a chain of proxies started from.
+func (s *Session) SetOrigin(remoteAddr string, sessionID string) {
+	if s == nil {
+		return
+	}
+
+	s.base.OriginAddr = remoteAddr
+	s.base.OriginSessionID = sessionID
+}
+
+func (s *Session) Emit(event Event) {
+	if s == nil {
+		return
+	}
+
+	event.SessionID = s.base.SessionID
+	event.User = s.base.User
+	event.RemoteAddr = s.base.RemoteAddr
+	event.UserID = s.base.UserID
+	event.UserName = s.base.UserName
+	event.Realm = s.base.Realm
+	event.OriginAddr = s.base.OriginAddr
+	event.OriginSessionID = s.base.OriginSessionID
+	event.TargetAddress = s.base.TargetAddress
+	event.LogGuid = s.base.LogGuid
+	event.Index = s.base.Index
+	event.Indexes = s.base.Indexes
+
+	s.auditor.Emit(event)
+}
+
+func (s *Session) Started() {
+	if s == nil {
+		return
+	}
+
+	s.started = time.Now()
+	s.Emit(Event{Type: SessionStarted})
+}
+
+func (s *Session) Failed(err error) {
+	if s == nil {
+		return
+	}
+
+	s.Emit(Event{Type: SessionFailed, Error: err.Error()})
+}
+
+func (s *Session) Ended() {
+	if s == nil {
+		return
+	}
+
+	s.Emit(Event{
+		Type:     SessionEnded,
+		BytesIn:  atomic.LoadInt64(&s.bytesIn),
+		BytesOut: atomic.LoadInt64(&s.bytesOut),
+		Duration: time.Since(s.started).Seconds(),
+	})
+}
+
+func (s *Session) Channel(channelType string, extraData []byte) *Channel {
+	if s == nil {
+		return nil
+	}
+
+	channel := &Channel{session: s, channelType: channelType}
+
+	event := Event{Type: ChannelOpened, ChannelType: channelType}
+	if channelType == "direct-tcpip" || channelType == "forwarded-tcpip" {
+		var forward struct {
+			Host           string
+			Port           uint32
+			OriginatorHost string
+			OriginatorPort uint32
+		}
+		if ssh.Unmarshal(extraData, &forward) == nil {
+			event.Type = PortForward
+			event.ForwardAddress = fmt.Sprintf("%s:%d", forward.Host, forward.Port)
+		}
+	}
+	s.Emit(event)
+
+	return channel
+}
+
+// Channel counts the bytes transferred through a proxied channel and emits
+// the commands requested on it. A nil channel emits nothing.
+type Channel struct {
+	session     *Session
+	channelType string
+
+	bytesIn  int64
+	bytesOut int64
+}
+
+// Requests observes the requests sent to the channel before passing them on.
+func (c *Channel) Requests(requests <-chan *ssh.Request) <-chan *ssh.Request {
+	if c == nil {
+		return requests
+	}
+
+	observed := make(chan *ssh.Request)
+
+	go func() {
+		defer close(observed)
+		for req := range requests {
+			c.observe(req)
+			observed <- req
+		}
+	}()
+
+	return observed
+}
+
+func (c *Channel) observe(req *ssh.Request) {
+	switch req.Type {
+	case "exec":
+		var exec struct {
+			Command string
+		}
+		if ssh.Unmarshal(req.Payload, &exec) == nil {
+			c.session.Emit(Event{Type: Exec, ChannelType: c.channelType, Command: exec.Command})
+		}
+	case "subsystem":
+		var subsystem struct {
+			Name string
+		}
+		if ssh.Unmarshal(req.Payload, &subsystem) == nil {
+			c.session.Emit(Event{Type: Subsystem, ChannelType: c.channelType, Subsystem: subsystem.Name})
+		}
+	case "shell":
+		c.session.Emit(Event{Type: Shell, ChannelType: c.channelType})
+	}
+}
+
+// Input counts bytes sent by the client.
+func (c *Channel) Input() io.Writer {
+	if c == nil {
+		return ioutil.Discard
+	}
+	return &counter{channel: &c.bytesIn, session: &c.session.bytesIn}
+}
+
+// Output counts bytes sent by the target.
+func (c *Channel) Output() io.Writer {
+	if c == nil {
+		return ioutil.Discard
+	}
+	return &counter{channel: &c.bytesOut, session: &c.session.bytesOut}
+}
+
+func (c *Channel) Close() {
+	if c == nil {
+		return
+	}
+
+	c.session.Emit(Event{
+		Type:        ChannelClosed,
+		ChannelType: c.channelType,
+		BytesIn:     atomic.LoadInt64(&c.bytesIn),
+		BytesOut:    atomic.LoadInt64(&c.bytesOut),
+	})
+}
+
+type counter struct {
+	channel *int64
+	session *int64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.channel, int64(len(p)))
+	atomic.AddInt64(c.session, int64(len(p)))
+	return len(p), nil
+}
@@ -0,0 +1,256 @@
+package audit_test
+
+import (
+	"errors"
+	"net"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/audit"
+	"github.com/cloudfoundry-incubator/diego-ssh/audit/fake_audit"
+	"github.com/cloudfoundry-incubator/diego-ssh/test_helpers/fake_ssh"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/pivotal-golang/lager/lagertest"
+	"golang.org/x/crypto/ssh"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Auditor", func() {
+	var (
+		logger   *lagertest.TestLogger
+		sink     *fake_audit.FakeSink
+		auditor  *audit.Auditor
+		metadata *fake_ssh.FakeConnMetadata
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		sink = &fake_audit.FakeSink{}
+		auditor = audit.NewAuditor(logger, sink)
+
+		metadata = &fake_ssh.FakeConnMetadata{}
+		metadata.UserReturns("cf:app-guid/0")
+		metadata.SessionIDReturns([]byte{0xde, 0xad, 0xbe, 0xef})
+		metadata.RemoteAddrReturns(&net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5678})
+	})
+
+	Describe("Emit", func() {
+		It("timestamps the event and sends it to the sink", func() {
+			auditor.Emit(audit.Event{Type: audit.SessionStarted})
+
+			Expect(sink.SendCallCount()).To(Equal(1))
+			event := sink.SendArgsForCall(0)
+			Expect(event.Type).To(Equal(audit.SessionStarted))
+			Expect(event.Timestamp).NotTo(BeZero())
+		})
+
+		It("logs sink failures", func() {
+			sink.SendReturns(errors.New("boom"))
+			auditor.Emit(audit.Event{Type: audit.SessionStarted})
+
+			Expect(logger).To(gbytes.Say("auditor.send-failed.*boom"))
+		})
+	})
+
+	Describe("PasswordCallback", func() {
+		var (
+			permissions *ssh.Permissions
+			callbackErr error
+			callback    audit.PasswordCallback
+		)
+
+		BeforeEach(func() {
+			permissions = &ssh.Permissions{}
+			callbackErr = nil
+		})
+
+		JustBeforeEach(func() {
+			callback = auditor.PasswordCallback(func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+				return permissions, callbackErr
+			})
+			callback(metadata, []byte("secret"))
+		})
+
+		It("emits successful authentications", func() {
+			Expect(sink.SendCallCount()).To(Equal(1))
+			event := sink.SendArgsForCall(0)
+			Expect(event.Type).To(Equal(audit.AuthSucceeded))
+			Expect(event.User).To(Equal("cf:app-guid/0"))
+			Expect(event.SessionID).To(Equal("deadbeef"))
+			Expect(event.RemoteAddr).To(Equal("1.2.3.4:5678"))
+			Expect(event.UserID).To(BeEmpty())
+		})
+
+		Context("when the authenticator identified the user", func() {
+			BeforeEach(func() {
+				permissions.CriticalOptions = map[string]string{
+					"user-identity": `{"user_id":"user-guid","user_name":"user@example.com","realm":"cf"}`,
+				}
+			})
+
+			It("emits the user with the success", func() {
+				event := sink.SendArgsForCall(0)
+				Expect(event.Type).To(Equal(audit.AuthSucceeded))
+				Expect(event.UserID).To(Equal("user-guid"))
+				Expect(event.UserName).To(Equal("user@example.com"))
+				Expect(event.Realm).To(Equal("cf"))
+			})
+		})
+
+		Context("when authentication fails", func() {
+			BeforeEach(func() {
+				callbackErr = errors.New("Invalid credentials")
+			})
+
+			It("emits the failure", func() {
+				event := sink.SendArgsForCall(0)
+				Expect(event.Type).To(Equal(audit.AuthFailed))
+				Expect(event.Error).To(Equal("Invalid credentials"))
+			})
+		})
+	})
+
+	Describe("PublicKeyCallback", func() {
+		It("emits the outcome of the authentication", func() {
+			callback := auditor.PublicKeyCallback(func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+				return nil, errors.New("untrusted key")
+			})
+			callback(metadata, nil)
+
+			Expect(sink.SendCallCount()).To(Equal(1))
+			event := sink.SendArgsForCall(0)
+			Expect(event.Type).To(Equal(audit.AuthFailed))
+			Expect(event.User).To(Equal("cf:app-guid/0"))
+			Expect(event.Error).To(Equal("untrusted key"))
+		})
+	})
+
+	Describe("Session", func() {
+		var session *audit.Session
+
+		BeforeEach(func() {
+			session = auditor.Session(metadata)
+			session.SetTarget("10.0.0.1:61001", "log-guid", 2)
+		})
+
+		lastEvent := func() audit.Event {
+			return sink.SendArgsForCall(sink.SendCallCount() - 1)
+		}
+
+		It("decorates events with the connection and target", func() {
+			session.Started()
+
+			event := lastEvent()
+			Expect(event.Type).To(Equal(audit.SessionStarted))
+			Expect(event.User).To(Equal("cf:app-guid/0"))
+			Expect(event.SessionID).To(Equal("deadbeef"))
+			Expect(event.TargetAddress).To(Equal("10.0.0.1:61001"))
+			Expect(event.LogGuid).To(Equal("log-guid"))
+			Expect(*event.Index).To(Equal(2))
+		})
+
+		It("decorates events with every instance of a fan-out login", func() {
+			session.SetTargets("10.0.0.1:61001,10.0.0.2:61001", "log-guid", []int{0, 2})
+			session.Started()
+
+			event := lastEvent()
+			Expect(event.TargetAddress).To(Equal("10.0.0.1:61001,10.0.0.2:61001"))
+			Expect(event.Index).To(BeNil())
+			Expect(event.Indexes).To(Equal([]int{0, 2}))
+		})
+
+		It("decorates events with the authenticated user", func() {
+			session.SetIdentity("user-guid", "user@example.com", "cf")
+			session.Started()
+
+			event := lastEvent()
+			Expect(event.User).To(Equal("cf:app-guid/0"))
+			Expect(event.UserID).To(Equal("user-guid"))
+			Expect(event.UserName).To(Equal("user@example.com"))
+			Expect(event.Realm).To(Equal("cf"))
+		})
+
+		It("decorates events with the origin of forwarded sessions", func() {
+			session.SetOrigin("5.6.7.8:1234", "cafe")
+			session.Started()
+
+			event := lastEvent()
+			Expect(event.RemoteAddr).To(Equal("1.2.3.4:5678"))
+			Expect(event.OriginAddr).To(Equal("5.6.7.8:1234"))
+			Expect(event.OriginSessionID).To(Equal("cafe"))
+		})
+
+		It("emits exec, shell, and subsystem requests on channels", func() {
+			channel := session.Channel("session", nil)
+			Expect(lastEvent().Type).To(Equal(audit.ChannelOpened))
+
+			requests := make(chan *ssh.Request, 3)
+			requests <- &ssh.Request{Type: "exec", Payload: ssh.Marshal(struct{ Command string }{"ls -la"})}
+			requests <- &ssh.Request{Type: "subsystem", Payload: ssh.Marshal(struct{ Name string }{"sftp"})}
+			requests <- &ssh.Request{Type: "shell"}
+			close(requests)
+
+			observed := channel.Requests(requests)
+			Eventually(observed).Should(Receive())
+			Eventually(observed).Should(Receive())
+			Eventually(observed).Should(Receive())
+			Eventually(observed).Should(BeClosed())
+
+			Expect(sink.SendArgsForCall(1).Type).To(Equal(audit.Exec))
+			Expect(sink.SendArgsForCall(1).Command).To(Equal("ls -la"))
+			Expect(sink.SendArgsForCall(2).Type).To(Equal(audit.Subsystem))
+			Expect(sink.SendArgsForCall(2).Subsystem).To(Equal("sftp"))
+			Expect(sink.SendArgsForCall(3).Type).To(Equal(audit.Shell))
+		})
+
+		It("emits port forward targets", func() {
+			session.Channel("direct-tcpip", ssh.Marshal(struct {
+				Host           string
+				Port           uint32
+				OriginatorHost string
+				OriginatorPort uint32
+			}{"127.0.0.1", 8080, "127.0.0.1", 50000}))
+
+			event := lastEvent()
+			Expect(event.Type).To(Equal(audit.PortForward))
+			Expect(event.ForwardAddress).To(Equal("127.0.0.1:8080"))
+		})
+
+		It("counts the bytes transferred on channels and the session", func() {
+			session.Started()
+
+			channel := session.Channel("session", nil)
+			channel.Input().Write([]byte("input"))
+			channel.Output().Write([]byte("output"))
+			channel.Close()
+
+			event := lastEvent()
+			Expect(event.Type).To(Equal(audit.ChannelClosed))
+			Expect(event.BytesIn).To(BeEquivalentTo(5))
+			Expect(event.BytesOut).To(BeEquivalentTo(6))
+
+			session.Channel("session", nil).Output().Write([]byte("more"))
+			session.Ended()
+
+			event = lastEvent()
+			Expect(event.Type).To(Equal(audit.SessionEnded))
+			Expect(event.BytesIn).To(BeEquivalentTo(5))
+			Expect(event.BytesOut).To(BeEquivalentTo(10))
+			Expect(event.Duration).To(BeNumerically(">", 0))
+		})
+	})
+
+	Context("when the auditor is nil", func() {
+		It("does nothing", func() {
+			var nilAuditor *audit.Auditor
+			nilAuditor.Emit(audit.Event{})
+
+			session := nilAuditor.Session(metadata)
+			session.Started()
+			channel := session.Channel("session", nil)
+			channel.Input().Write([]byte("data"))
+			channel.Close()
+			session.Ended()
+		})
+	})
+})
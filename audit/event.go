@@ -0,0 +1,51 @@
+package audit
+
+import "time"
+
+const (
+	AuthSucceeded = "auth-succeeded"
+	AuthFailed    = "auth-failed"
+
+	SessionStarted = "session-started"
+	SessionFailed  = "session-failed"
+	SessionEnded   = "session-ended"
+
+	ChannelOpened = "channel-opened"
+	ChannelClosed = "channel-closed"
+	PortForward   = "port-forward"
+	Exec          = "exec"
+	Shell         = "shell"
+	Subsystem     = "subsystem"
+)
+
+type Event struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"`
+
+	SessionID  string `json:"session_id,omitempty"`
+	User       string `json:"user,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+
+	UserID   string `json:"user_id,omitempty"`
+	UserName string `json:"user_name,omitempty"`
+	Realm    string `json:"realm,omitempty"`
+
+	OriginAddr      string `json:"origin_addr,omitempty"`
+	OriginSessionID string `json:"origin_session_id,omitempty"`
+
+	TargetAddress string `json:"target_address,omitempty"`
+	LogGuid       string `json:"log_guid,omitempty"`
+	Index         *int   `json:"index,omitempty"`
+	Indexes       []int  `json:"indexes,omitempty"`
+
+	ChannelType    string `json:"channel_type,omitempty"`
+	Command        string `json:"command,omitempty"`
+	Subsystem      string `json:"subsystem,omitempty"`
+	ForwardAddress string `json:"forward_address,omitempty"`
+
+	BytesIn  int64   `json:"bytes_in,omitempty"`
+	BytesOut int64   `json:"bytes_out,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,54 @@
+// This file was generated by counterfeiter
+package fake_audit
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/audit"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+type FakeEventEmitter struct {
+	EmitStub        func(event events.Event) error
+	emitMutex       sync.RWMutex
+	emitArgsForCall []struct {
+		event events.Event
+	}
+	emitReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeEventEmitter) Emit(event events.Event) error {
+	fake.emitMutex.Lock()
+	fake.emitArgsForCall = append(fake.emitArgsForCall, struct {
+		event events.Event
+	}{event})
+	fake.emitMutex.Unlock()
+	if fake.EmitStub != nil {
+		return fake.EmitStub(event)
+	} else {
+		return fake.emitReturns.result1
+	}
+}
+
+func (fake *FakeEventEmitter) EmitCallCount() int {
+	fake.emitMutex.RLock()
+	defer fake.emitMutex.RUnlock()
+	return len(fake.emitArgsForCall)
+}
+
+func (fake *FakeEventEmitter) EmitArgsForCall(i int) events.Event {
+	fake.emitMutex.RLock()
+	defer fake.emitMutex.RUnlock()
+	return fake.emitArgsForCall[i].event
+}
+
+func (fake *FakeEventEmitter) EmitReturns(result1 error) {
+	fake.EmitStub = nil
+	fake.emitReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ audit.EventEmitter = new(FakeEventEmitter)
@@ -0,0 +1,53 @@
+// This file was generated by counterfeiter
+package fake_audit
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/audit"
+)
+
+type FakeSink struct {
+	SendStub        func(event audit.Event) error
+	sendMutex       sync.RWMutex
+	sendArgsForCall []struct {
+		event audit.Event
+	}
+	sendReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeSink) Send(event audit.Event) error {
+	fake.sendMutex.Lock()
+	fake.sendArgsForCall = append(fake.sendArgsForCall, struct {
+		event audit.Event
+	}{event})
+	fake.sendMutex.Unlock()
+	if fake.SendStub != nil {
+		return fake.SendStub(event)
+	} else {
+		return fake.sendReturns.result1
+	}
+}
+
+func (fake *FakeSink) SendCallCount() int {
+	fake.sendMutex.RLock()
+	defer fake.sendMutex.RUnlock()
+	return len(fake.sendArgsForCall)
+}
+
+func (fake *FakeSink) SendArgsForCall(i int) audit.Event {
+	fake.sendMutex.RLock()
+	defer fake.sendMutex.RUnlock()
+	return fake.sendArgsForCall[i].event
+}
+
+func (fake *FakeSink) SendReturns(result1 error) {
+	fake.SendStub = nil
+	fake.sendReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ audit.Sink = new(FakeSink)
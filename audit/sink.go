@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+)
+
+const DropsondeSource = "ssh-proxy-audit"
+
+//go:generate counterfeiter -o fake_audit/fake_sink.go . Sink
+type Sink interface {
+	Send(event Event) error
+}
+
+// WriterSink writes each event as a line of JSON.
+type WriterSink struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func NewWriterSink(writer io.Writer) *WriterSink {
+	return &WriterSink{writer: writer}
+}
+
+func NewFileSink(path string) (*WriterSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWriterSink(file), nil
+}
+
+func (s *WriterSink) Send(event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err = s.writer.Write(append(payload, '\n'))
+	return err
+}
+
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SyslogSink{writer: writer}, nil
+}
+
+func (s *SyslogSink) Send(event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return s.writer.Info(string(payload))
+}
+
+//go:generate counterfeiter -o fake_audit/fake_event_emitter.go . EventEmitter
+type EventEmitter interface {
+	Emit(event events.Event) error
+}
+
+// DropsondeSink emits events to the firehose as Error envelopes of the
+// emitter's origin. They are not associated with an app, so they never reach
+// the log streams that space developers can read.
+type DropsondeSink struct {
+	emitter EventEmitter
+}
+
+func NewDropsondeSink(emitter EventEmitter) *DropsondeSink {
+	return &DropsondeSink{emitter: emitter}
+}
+
+func (s *DropsondeSink) Send(event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return s.emitter.Emit(&events.Error{
+		Source:  proto.String(DropsondeSource),
+		Code:    proto.Int32(0),
+		Message: proto.String(string(payload)),
+	})
+}
+
+type multiSink []Sink
+
+func NewMultiSink(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+func (m multiSink) Send(event Event) error {
+	var firstErr error
+	for _, sink := range m {
+		err := sink.Send(event)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,106 @@
+package audit_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/audit"
+	"github.com/cloudfoundry-incubator/diego-ssh/audit/fake_audit"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/onsi/gomega/gbytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sinks", func() {
+	Describe("WriterSink", func() {
+		It("writes events as JSON lines", func() {
+			buffer := gbytes.NewBuffer()
+			sink := audit.NewWriterSink(buffer)
+
+			Expect(sink.Send(audit.Event{Type: audit.Exec, Command: "ls"})).To(Succeed())
+			Expect(sink.Send(audit.Event{Type: audit.Shell})).To(Succeed())
+
+			lines := strings.Split(strings.TrimSpace(string(buffer.Contents())), "\n")
+			Expect(lines).To(HaveLen(2))
+
+			var event map[string]interface{}
+			Expect(json.Unmarshal([]byte(lines[0]), &event)).To(Succeed())
+			Expect(event).To(HaveKeyWithValue("type", "exec"))
+			Expect(event).To(HaveKeyWithValue("command", "ls"))
+		})
+	})
+
+	Describe("FileSink", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "audit")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("appends events to the file", func() {
+			path := filepath.Join(dir, "audit.log")
+			Expect(ioutil.WriteFile(path, []byte("existing\n"), 0600)).To(Succeed())
+
+			sink, err := audit.NewFileSink(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sink.Send(audit.Event{Type: audit.Shell})).To(Succeed())
+
+			contents, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(HavePrefix("existing\n{"))
+			Expect(string(contents)).To(ContainSubstring(`"type":"shell"`))
+		})
+	})
+
+	Describe("DropsondeSink", func() {
+		var emitter *fake_audit.FakeEventEmitter
+
+		BeforeEach(func() {
+			emitter = &fake_audit.FakeEventEmitter{}
+		})
+
+		It("emits events as system errors that belong to no app", func() {
+			index := 3
+			sink := audit.NewDropsondeSink(emitter)
+			Expect(sink.Send(audit.Event{Type: audit.Shell, LogGuid: "log-guid", Index: &index})).To(Succeed())
+
+			Expect(emitter.EmitCallCount()).To(Equal(1))
+			errorEvent, ok := emitter.EmitArgsForCall(0).(*events.Error)
+			Expect(ok).To(BeTrue())
+			Expect(errorEvent.GetSource()).To(Equal(audit.DropsondeSource))
+			Expect(errorEvent.GetMessage()).To(ContainSubstring(`"type":"shell"`))
+			Expect(errorEvent.GetMessage()).To(ContainSubstring(`"log_guid":"log-guid"`))
+		})
+
+		It("returns the error of the emitter", func() {
+			emitter.EmitReturns(errors.New("boom"))
+			sink := audit.NewDropsondeSink(emitter)
+			Expect(sink.Send(audit.Event{Type: audit.AuthFailed})).To(MatchError("boom"))
+		})
+	})
+
+	Describe("MultiSink", func() {
+		It("sends events to every sink and returns the first error", func() {
+			first := &fake_audit.FakeSink{}
+			first.SendReturns(errors.New("boom"))
+			second := &fake_audit.FakeSink{}
+
+			err := audit.NewMultiSink(first, second).Send(audit.Event{Type: audit.Shell})
+			Expect(err).To(MatchError("boom"))
+			Expect(first.SendCallCount()).To(Equal(1))
+			Expect(second.SendCallCount()).To(Equal(1))
+		})
+	})
+})
@@ -1,13 +1,20 @@
 package authenticators
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 
-	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/cloudfoundry-incubator/diego-ssh/cache"
+	"github.com/cloudfoundry-incubator/diego-ssh/identity"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
+	"github.com/cloudfoundry-incubator/diego-ssh/upstream"
 	"github.com/pivotal-golang/lager"
 	"golang.org/x/crypto/ssh"
 )
@@ -15,26 +22,38 @@ import (
 const CF_REALM = "cf"
 
 type CFAuthenticator struct {
-	logger         lager.Logger
-	ccClient       *http.Client
-	ccURL          string
-	receptorClient receptor.Client
+	logger           lager.Logger
+	ccClient         *http.Client
+	ccURL            string
+	targetResolver   TargetResolver
+	accessCache      *cache.Cache
+	sessionLogSpaces map[string]bool
 }
 
 var CFPrincipalRegex *regexp.Regexp = regexp.MustCompile(`(.*)/(\d+)`)
+var CFFanOutPrincipalRegex *regexp.Regexp = regexp.MustCompile(`^(.*)/\*$`)
 var CFRealmRegex *regexp.Regexp = regexp.MustCompile(CF_REALM + `:(.*)`)
 
 func NewCFAuthenticator(
 	logger lager.Logger,
 	ccClient *http.Client,
 	ccURL string,
-	receptorClient receptor.Client,
+	targetResolver TargetResolver,
+	accessCache *cache.Cache,
+	sessionLogSpaces []string,
 ) *CFAuthenticator {
+	spaces := map[string]bool{}
+	for _, spaceGuid := range sessionLogSpaces {
+		spaces[spaceGuid] = true
+	}
+
 	return &CFAuthenticator{
-		logger:         logger,
-		ccClient:       ccClient,
-		ccURL:          ccURL,
-		receptorClient: receptorClient,
+		logger:           logger,
+		ccClient:         ccClient,
+		ccURL:            ccURL,
+		targetResolver:   targetResolver,
+		accessCache:      accessCache,
+		sessionLogSpaces: spaces,
 	}
 }
 
@@ -46,6 +65,20 @@ type AppSSHResponse struct {
 	ProcessGuid string `json:"process_guid"`
 }
 
+type AppResponse struct {
+	Entity AppEntity `json:"entity"`
+}
+
+type AppEntity struct {
+	SpaceGuid string `json:"space_guid"`
+}
+
+// appAccess is what the proxy learns about an app from CC for one token.
+type appAccess struct {
+	processGuid string
+	spaceGuid   string
+}
+
 func (cfa *CFAuthenticator) Authenticate(metadata ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
 	logger := cfa.logger.Session("authenticate")
 	if !CFRealmRegex.Match([]byte(metadata.User())) {
@@ -53,54 +86,171 @@ func (cfa *CFAuthenticator) Authenticate(metadata ssh.ConnMetadata, password []b
 	}
 
 	principal := CFRealmRegex.FindStringSubmatch(metadata.User())[1]
-	if !CFPrincipalRegex.Match([]byte(principal)) {
-		return nil, InvalidCredentialsErr
+
+	var appGuid string
+	var index int
+	fanOut := CFFanOutPrincipalRegex.MatchString(principal)
+
+	if fanOut {
+		appGuid = CFFanOutPrincipalRegex.FindStringSubmatch(principal)[1]
+	} else {
+		if !CFPrincipalRegex.Match([]byte(principal)) {
+			return nil, InvalidCredentialsErr
+		}
+
+		guidAndIndex := CFPrincipalRegex.FindStringSubmatch(principal)
+
+		var err error
+		index, err = strconv.Atoi(guidAndIndex[2])
+		if err != nil {
+			logger.Error("atoi-failed", err)
+			return nil, InvalidCredentialsErr
+		}
+
+		appGuid = guidAndIndex[1]
+	}
+
+	accessKey := fmt.Sprintf("%s:%x", appGuid, sha256.Sum256(password))
+
+	span := tracing.SpanFromMetadata(metadata)
+
+	value, err := cfa.accessCache.Fetch(accessKey, func() (interface{}, error) {
+		ccSpan := span.Child("cc-ssh-access")
+		access, err := cfa.fetchAppAccess(logger, appGuid, password)
+		ccSpan.Finish(err)
+		return access, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	app := value.(*appAccess)
+
+	sessionLogs := app.spaceGuid != "" && cfa.sessionLogSpaces[app.spaceGuid]
+
+	claims := parseTokenClaims(password)
+	userPrincipal := tokenPrincipal(claims, password)
+	userIdentity := identity.Identity{UserID: claims.UserId, UserName: claims.UserName, Realm: CF_REALM}
+
+	var permissions *ssh.Permissions
+	if fanOut {
+		permissions, err = sshPermissionsFromProcessInstances(span, app.processGuid, cfa.targetResolver, metadata.RemoteAddr(), userPrincipal, userIdentity, sessionLogs)
+	} else {
+		permissions, err = sshPermissionsFromProcess(span, app.processGuid, index, cfa.targetResolver, metadata.RemoteAddr(), userPrincipal, userIdentity, sessionLogs)
+	}
+	if err != nil {
+		logger.Error("building-ssh-permissions-failed", err)
+	}
+
+	return permissions, err
+}
+
+type tokenClaims struct {
+	UserId   string `json:"user_id"`
+	UserName string `json:"user_name"`
+}
+
+// parseTokenClaims reads the user claims of a bearer token. The token is not
+// verified here; the Cloud Controller does that.
+func parseTokenClaims(password []byte) tokenClaims {
+	var claims tokenClaims
+
+	parts := strings.Split(bearerToken(password), ".")
+	if len(parts) != 3 {
+		return claims
+	}
+
+	payload := parts[1]
+	if m := len(payload) % 4; m != 0 {
+		payload += strings.Repeat("=", 4-m)
+	}
+
+	decoded, err := base64.URLEncoding.DecodeString(payload)
+	if err != nil {
+		return claims
+	}
+
+	if json.Unmarshal(decoded, &claims) != nil {
+		return tokenClaims{}
+	}
+
+	return claims
+}
+
+// tokenPrincipal identifies the user of the token for session limits. Tokens
+// without a readable user id are identified by their hash so that the
+// per-user limit still applies to them.
+func tokenPrincipal(claims tokenClaims, password []byte) string {
+	if claims.UserId == "" {
+		return fmt.Sprintf("%s:token-%x", CF_REALM, sha256.Sum256([]byte(bearerToken(password))))
 	}
+	return CF_REALM + ":" + claims.UserId
+}
 
-	guidAndIndex := CFPrincipalRegex.FindStringSubmatch(principal)
+func bearerToken(password []byte) string {
+	token := string(password)
+	if strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		token = token[len("bearer "):]
+	}
+	return token
+}
 
-	index, err := strconv.Atoi(guidAndIndex[2])
+// fetchAppAccess asks CC whether the token may ssh to the app. The space of
+// the app is only looked up when some space has opted in to session logs;
+// sessions of an app whose space cannot be read are not logged.
+func (cfa *CFAuthenticator) fetchAppAccess(logger lager.Logger, appGuid string, password []byte) (*appAccess, error) {
+	var sshAccess AppSSHResponse
+	err := cfa.fetchFromCC(logger, fmt.Sprintf("/internal/apps/%s/ssh_access", appGuid), password, &sshAccess)
 	if err != nil {
-		logger.Error("atoi-failed", err)
-		return nil, InvalidCredentialsErr
+		return nil, err
 	}
 
-	appGuid := guidAndIndex[1]
-	path := fmt.Sprintf("%s/internal/apps/%s/ssh_access", cfa.ccURL, appGuid)
+	access := &appAccess{processGuid: sshAccess.ProcessGuid}
+
+	if len(cfa.sessionLogSpaces) > 0 {
+		var app AppResponse
+		err := cfa.fetchFromCC(logger, fmt.Sprintf("/v2/apps/%s", appGuid), password, &app)
+		if err != nil {
+			logger.Error("fetching-space-failed", err)
+		} else {
+			access.spaceGuid = app.Entity.SpaceGuid
+		}
+	}
 
-	req, err := http.NewRequest("GET", path, nil)
+	return access, nil
+}
+
+func (cfa *CFAuthenticator) fetchFromCC(logger lager.Logger, path string, password []byte, result interface{}) error {
+	req, err := http.NewRequest("GET", cfa.ccURL+path, nil)
 	if err != nil {
 		logger.Error("creating-request-failed", InvalidRequestErr)
-		return nil, InvalidRequestErr
+		return InvalidRequestErr
 	}
 	req.Header.Add("Authorization", string(password))
 
 	resp, err := cfa.ccClient.Do(req)
 	if err != nil {
-		logger.Error("fetching-app-failed", err)
-		return nil, err
+		if urlErr, ok := err.(*url.Error); ok && urlErr.Err == upstream.UnavailableErr {
+			err = upstream.UnavailableErr
+		}
+		logger.Error("fetching-app-failed", err, lager.Data{"path": path})
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		logger.Error("fetching-app-failed", FetchAppFailedErr, lager.Data{
-			"StatusCode":   resp.Status,
-			"ResponseBody": resp.Body,
+			"path":       path,
+			"StatusCode": resp.Status,
 		})
-		return nil, FetchAppFailedErr
+		return FetchAppFailedErr
 	}
 
-	var app AppSSHResponse
-	err = json.NewDecoder(resp.Body).Decode(&app)
+	err = json.NewDecoder(resp.Body).Decode(result)
 	if err != nil {
 		logger.Error("invalid-cc-response", err)
-		return nil, InvalidCCResponse
+		return InvalidCCResponse
 	}
 
-	permissions, err := sshPermissionsFromProcess(app.ProcessGuid, index, cfa.receptorClient, metadata.RemoteAddr())
-	if err != nil {
-		logger.Error("building-ssh-permissions-failed", err)
-	}
-
-	return permissions, err
+	return nil
 }
@@ -1,17 +1,24 @@
 package authenticators_test
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"math"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
+	"github.com/cloudfoundry-incubator/diego-ssh/cache"
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
 	"github.com/cloudfoundry-incubator/diego-ssh/routes"
 	"github.com/cloudfoundry-incubator/diego-ssh/test_helpers/fake_ssh"
+	"github.com/cloudfoundry-incubator/diego-ssh/upstream"
 	"github.com/cloudfoundry-incubator/receptor"
 	"github.com/cloudfoundry-incubator/receptor/fake_receptor"
 	. "github.com/onsi/ginkgo"
@@ -28,6 +35,8 @@ var _ = Describe("CFAuthenticator", func() {
 		ccClient        *http.Client
 		ccClientTimeout time.Duration
 		receptorClient  *fake_receptor.FakeClient
+		accessCache     *cache.Cache
+		logSpaces       []string
 
 		permissions *ssh.Permissions
 		err         error
@@ -44,6 +53,8 @@ var _ = Describe("CFAuthenticator", func() {
 		ccClientTimeout = time.Second
 		ccClient = &http.Client{Timeout: ccClientTimeout}
 		receptorClient = new(fake_receptor.FakeClient)
+		accessCache = nil
+		logSpaces = nil
 
 		metadata = &fake_ssh.FakeConnMetadata{}
 
@@ -119,7 +130,7 @@ var _ = Describe("CFAuthenticator", func() {
 		})
 
 		JustBeforeEach(func() {
-			authenticator = authenticators.NewCFAuthenticator(logger, ccClient, ccURL, receptorClient)
+			authenticator = authenticators.NewCFAuthenticator(logger, ccClient, ccURL, authenticators.NewReceptorTargetResolver(receptorClient, nil, nil), accessCache, logSpaces)
 			permissions, err = authenticator.Authenticate(metadata, password)
 		})
 
@@ -224,6 +235,260 @@ var _ = Describe("CFAuthenticator", func() {
 				Expect(permissions.CriticalOptions["log-message"]).To(MatchJSON(expectedConfig))
 			})
 
+			It("saves the session target in the critical options of the permissions", func() {
+				var limits proxy.SessionLimits
+				Expect(json.Unmarshal([]byte(permissions.CriticalOptions["session-limits"]), &limits)).To(Succeed())
+				Expect(limits.Target).To(Equal("app-guid-app-version/1"))
+			})
+
+			It("identifies the user by the hash of a token it cannot read", func() {
+				var limits proxy.SessionLimits
+				Expect(json.Unmarshal([]byte(permissions.CriticalOptions["session-limits"]), &limits)).To(Succeed())
+				Expect(limits.Principal).To(Equal(fmt.Sprintf("cf:token-%x", sha256.Sum256([]byte("token")))))
+			})
+
+			It("saves the realm of the user in the critical options of the permissions", func() {
+				Expect(permissions.CriticalOptions["user-identity"]).To(MatchJSON(`{"realm": "cf"}`))
+			})
+
+			Context("and the principal selects every instance", func() {
+				BeforeEach(func() {
+					metadata.UserReturns("cf:app-guid/*")
+
+					running := actualLRPResponse
+					crashed := actualLRPResponse
+					crashed.Index = 1
+					crashed.State = receptor.ActualLRPStateCrashed
+					other := actualLRPResponse
+					other.Index = 2
+					other.Address = "5.6.7.8"
+
+					running.State = receptor.ActualLRPStateRunning
+					other.State = receptor.ActualLRPStateRunning
+
+					receptorClient.ActualLRPsByProcessGuidReturns([]receptor.ActualLRPResponse{other, crashed, running}, nil)
+				})
+
+				It("looks up every instance of the app", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(receptorClient.ActualLRPsByProcessGuidCallCount()).To(Equal(1))
+					Expect(receptorClient.ActualLRPsByProcessGuidArgsForCall(0)).To(Equal("app-guid-app-version"))
+					Expect(receptorClient.ActualLRPByProcessGuidAndIndexCallCount()).To(Equal(0))
+				})
+
+				It("saves the running instances in the critical options of the permissions", func() {
+					Expect(permissions.CriticalOptions).NotTo(HaveKey("proxy-target-config"))
+					Expect(permissions.CriticalOptions["proxy-fan-out-targets"]).To(MatchJSON(`[
+						{
+							"index": 0,
+							"target": {
+								"address": "1.2.3.4:3333",
+								"host_fingerprint": "host-fingerprint",
+								"private_key": "pem-encoded-key",
+								"user": "user",
+								"password": "password"
+							}
+						},
+						{
+							"index": 2,
+							"target": {
+								"address": "5.6.7.8:3333",
+								"host_fingerprint": "host-fingerprint",
+								"private_key": "pem-encoded-key",
+								"user": "user",
+								"password": "password"
+							}
+						}
+					]`))
+				})
+
+				It("limits sessions against each of the instances", func() {
+					var limits proxy.SessionLimits
+					Expect(json.Unmarshal([]byte(permissions.CriticalOptions["session-limits"]), &limits)).To(Succeed())
+					Expect(limits.Targets).To(Equal([]string{"app-guid-app-version/0", "app-guid-app-version/2"}))
+				})
+
+				It("saves the realm of the user in the critical options of the permissions", func() {
+					Expect(permissions.CriticalOptions["user-identity"]).To(MatchJSON(`{"realm": "cf"}`))
+				})
+
+				Context("when no instance is running", func() {
+					BeforeEach(func() {
+						receptorClient.ActualLRPsByProcessGuidReturns([]receptor.ActualLRPResponse{}, nil)
+					})
+
+					It("fails to authenticate", func() {
+						Expect(err).To(Equal(authenticators.TargetNotFoundErr))
+					})
+				})
+			})
+
+			Context("and the bearer token identifies the user", func() {
+				BeforeEach(func() {
+					claims := base64.URLEncoding.EncodeToString([]byte(`{"user_id":"user-guid","user_name":"user@example.com","scope":["cloud_controller.read"]}`))
+					password = []byte("bearer header." + strings.TrimRight(claims, "=") + ".signature")
+
+					fakeCC.SetHandler(0, ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/internal/apps/app-guid/ssh_access"),
+						ghttp.RespondWithJSONEncodedPtr(&responseCode, expectedResponse),
+					))
+				})
+
+				It("uses the user as the principal of the session", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(permissions.CriticalOptions["session-limits"]).To(MatchJSON(`{
+						"principal": "cf:user-guid",
+						"target": "app-guid-app-version/1"
+					}`))
+				})
+
+				It("saves the user in the critical options of the permissions", func() {
+					Expect(permissions.CriticalOptions["user-identity"]).To(MatchJSON(`{
+						"user_id": "user-guid",
+						"user_name": "user@example.com",
+						"realm": "cf"
+					}`))
+				})
+			})
+
+			Context("and the space of the app has opted in to session logs", func() {
+				BeforeEach(func() {
+					logSpaces = []string{"other-space-guid", "space-guid"}
+
+					fakeCC.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", "/v2/apps/app-guid"),
+							ghttp.VerifyHeader(http.Header{"Authorization": []string{"bearer token"}}),
+							ghttp.RespondWith(http.StatusOK, `{"metadata":{"guid":"app-guid"},"entity":{"space_guid":"space-guid"}}`),
+						),
+					)
+				})
+
+				It("fetches the space of the app from CC using the bearer token", func() {
+					Expect(fakeCC.ReceivedRequests()).To(HaveLen(2))
+				})
+
+				It("enables session logs in the log message", func() {
+					expectedConfig := `{
+								"guid": "log-guid",
+								"message": "Successful remote access by 1.1.1.1",
+								"index": 1,
+								"session_logs": true
+							}`
+
+					Expect(permissions.CriticalOptions["log-message"]).To(MatchJSON(expectedConfig))
+				})
+			})
+
+			Context("and the space of the app has not opted in to session logs", func() {
+				BeforeEach(func() {
+					logSpaces = []string{"other-space-guid"}
+
+					fakeCC.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", "/v2/apps/app-guid"),
+							ghttp.RespondWith(http.StatusOK, `{"entity":{"space_guid":"space-guid"}}`),
+						),
+					)
+				})
+
+				It("does not enable session logs", func() {
+					Expect(permissions.CriticalOptions["log-message"]).NotTo(ContainSubstring("session_logs"))
+				})
+			})
+
+			Context("and the space of the app cannot be fetched", func() {
+				BeforeEach(func() {
+					logSpaces = []string{"space-guid"}
+
+					fakeCC.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", "/v2/apps/app-guid"),
+							ghttp.RespondWith(http.StatusForbidden, ""),
+						),
+					)
+				})
+
+				It("authenticates without session logs", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(permissions.CriticalOptions["log-message"]).NotTo(ContainSubstring("session_logs"))
+				})
+			})
+
+			Context("and no space has opted in to session logs", func() {
+				It("does not fetch the space of the app", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+				})
+			})
+
+			Context("and an access cache is provided", func() {
+				BeforeEach(func() {
+					accessCache = cache.New("CCAccess", 10, time.Minute)
+				})
+
+				It("reuses the authorization for the same token and app", func() {
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err := authenticator.Authenticate(metadata, password)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+					Expect(receptorClient.ActualLRPByProcessGuidAndIndexCallCount()).To(Equal(2))
+				})
+
+				Context("and the space of the app has opted in to session logs", func() {
+					BeforeEach(func() {
+						logSpaces = []string{"space-guid"}
+
+						fakeCC.AppendHandlers(
+							ghttp.CombineHandlers(
+								ghttp.VerifyRequest("GET", "/v2/apps/app-guid"),
+								ghttp.RespondWith(http.StatusOK, `{"entity":{"space_guid":"space-guid"}}`),
+							),
+						)
+					})
+
+					It("reuses the space of the app", func() {
+						Expect(err).NotTo(HaveOccurred())
+
+						permissions, err := authenticator.Authenticate(metadata, password)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(permissions.CriticalOptions["log-message"]).To(ContainSubstring(`"session_logs":true`))
+
+						Expect(fakeCC.ReceivedRequests()).To(HaveLen(2))
+					})
+				})
+
+				It("asks CC again when a different token is presented", func() {
+					Expect(err).NotTo(HaveOccurred())
+
+					fakeCC.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", "/internal/apps/app-guid/ssh_access"),
+							ghttp.VerifyHeader(http.Header{"Authorization": []string{"another token"}}),
+							ghttp.RespondWithJSONEncodedPtr(&responseCode, expectedResponse),
+						),
+					)
+
+					_, err := authenticator.Authenticate(metadata, []byte("another token"))
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(fakeCC.ReceivedRequests()).To(HaveLen(2))
+				})
+
+				Context("when CC rejects the token", func() {
+					BeforeEach(func() {
+						responseCode = http.StatusUnauthorized
+					})
+
+					It("does not cache the failure", func() {
+						Expect(err).To(Equal(authenticators.FetchAppFailedErr))
+						Expect(accessCache.Len()).To(Equal(0))
+					})
+				})
+			})
+
 			Context("and fetching the ssh_access from cc returns a non-200 status code", func() {
 				BeforeEach(func() {
 					responseCode = http.StatusInternalServerError
@@ -236,6 +501,27 @@ var _ = Describe("CFAuthenticator", func() {
 				})
 			})
 
+			Context("and the cloud controller is guarded by an upstream", func() {
+				BeforeEach(func() {
+					cc := upstream.New(logger, "CloudController", upstream.Config{
+						FailureThreshold: 1,
+						ResetTimeout:     time.Minute,
+					})
+					ccClient.Transport = upstream.NewRoundTripper(cc, nil)
+
+					responseCode = http.StatusInternalServerError
+					expectedResponse = &authenticators.AppSSHResponse{}
+				})
+
+				It("fails fast with an unavailable error once the circuit is open", func() {
+					Expect(err).To(Equal(authenticators.FetchAppFailedErr))
+
+					_, err = authenticator.Authenticate(metadata, password)
+					Expect(err).To(Equal(upstream.UnavailableErr))
+					Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+				})
+			})
+
 			Context("and the response cannot be parsed", func() {
 				BeforeEach(func() {
 					fakeCC.SetHandler(0, ghttp.CombineHandlers(
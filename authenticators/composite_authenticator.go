@@ -3,9 +3,13 @@ package authenticators
 import (
 	"strings"
 
+	"github.com/cloudfoundry-incubator/diego-ssh/stats"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
 	"golang.org/x/crypto/ssh"
 )
 
+var authAttempts = stats.NewCounter("ssh_proxy_auth_attempts_total", "AuthAttempts", "Authentication attempts by realm and result", "realm", "result")
+
 type CompositeAuthenticator struct {
 	authenticatorMap map[string]PasswordAuthenticator
 }
@@ -18,8 +22,20 @@ func (a *CompositeAuthenticator) Authenticate(metadata ssh.ConnMetadata, passwor
 	if parts := strings.SplitN(metadata.User(), ":", 2); len(parts) == 2 {
 		authenticator := a.authenticatorMap[parts[0]]
 		if authenticator != nil {
-			return authenticator.Authenticate(metadata, password)
+			span := tracing.SpanFromMetadata(metadata).Child("authenticate")
+			span.SetAttribute("realm", parts[0])
+
+			permissions, err := authenticator.Authenticate(tracing.WithSpan(metadata, span), password)
+			span.Finish(err)
+			if err != nil {
+				authAttempts.Inc(parts[0], "failure")
+			} else {
+				authAttempts.Inc(parts[0], "success")
+			}
+			return permissions, err
 		}
 	}
+
+	authAttempts.Inc("unknown", "failure")
 	return nil, InvalidCredentialsErr
 }
@@ -6,6 +6,9 @@ import (
 	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
 	"github.com/cloudfoundry-incubator/diego-ssh/authenticators/fake_authenticators"
 	"github.com/cloudfoundry-incubator/diego-ssh/test_helpers/fake_ssh"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing/fake_tracing"
+	"github.com/pivotal-golang/lager/lagertest"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"golang.org/x/crypto/ssh"
@@ -78,6 +81,27 @@ var _ = Describe("CompositeAuthenticator", func() {
 						Expect(m).To(Equal(metadata))
 						Expect(p).To(Equal(password))
 					})
+
+					Context("when the metadata carries a trace span", func() {
+						var exporter *fake_tracing.FakeExporter
+
+						It("traces the authentication as a child span", func() {
+							exporter = &fake_tracing.FakeExporter{}
+							tracer := tracing.NewTracer(lagertest.NewTestLogger("test"), "ssh-proxy", exporter)
+							login := tracer.StartTrace("ssh-login")
+
+							_, err := authenticator.Authenticate(tracing.WithSpan(metadata, login), password)
+							Expect(err).NotTo(HaveOccurred())
+
+							m, _ := authenticatorOne.AuthenticateArgsForCall(0)
+							Expect(tracing.SpanFromMetadata(m).Name).To(Equal("authenticate"))
+
+							Expect(exporter.ExportCallCount()).To(Equal(1))
+							span := exporter.ExportArgsForCall(0)
+							Expect(span.ParentID).To(Equal(login.SpanID))
+							Expect(span.Attributes).To(HaveKeyWithValue("realm", "one"))
+						})
+					})
 				})
 
 				Context("and the authenticator fails to authenticate", func() {
@@ -2,15 +2,11 @@ package authenticators
 
 import (
 	"bytes"
-	"encoding/json"
-	"fmt"
-	"net"
 	"regexp"
 	"strconv"
 
-	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
-	"github.com/cloudfoundry-incubator/diego-ssh/routes"
-	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/cloudfoundry-incubator/diego-ssh/identity"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
 	"github.com/pivotal-golang/lager"
 	"golang.org/x/crypto/ssh"
 )
@@ -20,20 +16,21 @@ const DIEGO_REALM = "diego"
 type DiegoProxyAuthenticator struct {
 	logger         lager.Logger
 	receptorCreds  []byte
-	receptorClient receptor.Client
+	targetResolver TargetResolver
 }
 
 var DiegoUserRegex *regexp.Regexp = regexp.MustCompile(DIEGO_REALM + `:(.*)/(\d+)`)
+var DiegoFanOutUserRegex *regexp.Regexp = regexp.MustCompile(`^` + DIEGO_REALM + `:(.*)/\*$`)
 
 func NewDiegoProxyAuthenticator(
 	logger lager.Logger,
-	receptorClient receptor.Client,
+	targetResolver TargetResolver,
 	receptorCreds []byte,
 ) *DiegoProxyAuthenticator {
 	return &DiegoProxyAuthenticator{
 		logger:         logger,
 		receptorCreds:  receptorCreds,
-		receptorClient: receptorClient,
+		targetResolver: targetResolver,
 	}
 }
 
@@ -46,7 +43,9 @@ func (dpa *DiegoProxyAuthenticator) Authenticate(metadata ssh.ConnMetadata, pass
 	logger.Info("authentication-starting")
 	defer logger.Info("authentication-finished")
 
-	if !DiegoUserRegex.MatchString(metadata.User()) {
+	fanOut := DiegoFanOutUserRegex.MatchString(metadata.User())
+
+	if !fanOut && !DiegoUserRegex.MatchString(metadata.User()) {
 		logger.Error("regex-match-fail", InvalidDomainErr)
 		return nil, InvalidDomainErr
 	}
@@ -56,6 +55,18 @@ func (dpa *DiegoProxyAuthenticator) Authenticate(metadata ssh.ConnMetadata, pass
 		return nil, InvalidCredentialsErr
 	}
 
+	userIdentity := identity.Identity{Realm: DIEGO_REALM}
+
+	if fanOut {
+		processGuid := DiegoFanOutUserRegex.FindStringSubmatch(metadata.User())[1]
+
+		permissions, err := sshPermissionsFromProcessInstances(tracing.SpanFromMetadata(metadata), processGuid, dpa.targetResolver, metadata.RemoteAddr(), DIEGO_REALM, userIdentity, false)
+		if err != nil {
+			logger.Error("building-ssh-permissions-failed", err)
+		}
+		return permissions, err
+	}
+
 	guidAndIndex := DiegoUserRegex.FindStringSubmatch(metadata.User())
 
 	processGuid := guidAndIndex[1]
@@ -65,102 +76,9 @@ func (dpa *DiegoProxyAuthenticator) Authenticate(metadata ssh.ConnMetadata, pass
 		return nil, err
 	}
 
-	permissions, err := sshPermissionsFromProcess(processGuid, index, dpa.receptorClient, metadata.RemoteAddr())
+	permissions, err := sshPermissionsFromProcess(tracing.SpanFromMetadata(metadata), processGuid, index, dpa.targetResolver, metadata.RemoteAddr(), DIEGO_REALM, userIdentity, false)
 	if err != nil {
 		logger.Error("building-ssh-permissions-failed", err)
 	}
 	return permissions, err
 }
-
-func sshPermissionsFromProcess(
-	processGuid string,
-	index int,
-	receptorClient receptor.Client,
-	remoteAddr net.Addr,
-) (*ssh.Permissions, error) {
-	actual, err := receptorClient.ActualLRPByProcessGuidAndIndex(processGuid, index)
-	if err != nil {
-		return nil, err
-	}
-
-	desired, err := receptorClient.GetDesiredLRP(processGuid)
-	if err != nil {
-		return nil, err
-	}
-
-	sshRoute, err := getRoutingInfo(&desired)
-	if err != nil {
-		return nil, err
-	}
-
-	logMessage := fmt.Sprintf("Successful remote access by %s", remoteAddr.String())
-
-	return createPermissions(sshRoute, &actual, desired.LogGuid, logMessage, index)
-}
-
-func createPermissions(
-	sshRoute *routes.SSHRoute,
-	actual *receptor.ActualLRPResponse,
-	logGuid string,
-	logMessage string,
-	index int,
-) (*ssh.Permissions, error) {
-	var targetConfig *proxy.TargetConfig
-
-	for _, mapping := range actual.Ports {
-		if mapping.ContainerPort == sshRoute.ContainerPort {
-			targetConfig = &proxy.TargetConfig{
-				Address:         fmt.Sprintf("%s:%d", actual.Address, mapping.HostPort),
-				HostFingerprint: sshRoute.HostFingerprint,
-				User:            sshRoute.User,
-				Password:        sshRoute.Password,
-				PrivateKey:      sshRoute.PrivateKey,
-			}
-			break
-		}
-	}
-
-	if targetConfig == nil {
-		return &ssh.Permissions{}, nil
-	}
-
-	targetConfigJson, err := json.Marshal(targetConfig)
-	if err != nil {
-		return nil, err
-	}
-
-	logMessageJson, err := json.Marshal(proxy.LogMessage{
-		Guid:    logGuid,
-		Message: logMessage,
-		Index:   index,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return &ssh.Permissions{
-		CriticalOptions: map[string]string{
-			"proxy-target-config": string(targetConfigJson),
-			"log-message":         string(logMessageJson),
-		},
-	}, nil
-}
-
-func getRoutingInfo(desired *receptor.DesiredLRPResponse) (*routes.SSHRoute, error) {
-	if desired.Routes == nil {
-		return nil, RouteNotFoundErr
-	}
-
-	rawMessage := desired.Routes[routes.DIEGO_SSH]
-	if rawMessage == nil {
-		return nil, RouteNotFoundErr
-	}
-
-	var sshRoute routes.SSHRoute
-	err := json.Unmarshal(*rawMessage, &sshRoute)
-	if err != nil {
-		return nil, err
-	}
-
-	return &sshRoute, nil
-}
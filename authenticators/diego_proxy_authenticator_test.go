@@ -68,7 +68,7 @@ var _ = Describe("DiegoProxyAuthenticator", func() {
 
 		logger = lagertest.NewTestLogger("test")
 		receptorCreds = []byte("receptor-user:receptor-password")
-		authenticator = authenticators.NewDiegoProxyAuthenticator(logger, receptorClient, receptorCreds)
+		authenticator = authenticators.NewDiegoProxyAuthenticator(logger, authenticators.NewReceptorTargetResolver(receptorClient, nil, nil), receptorCreds)
 
 		metadata = &fake_ssh.FakeConnMetadata{}
 	})
@@ -172,6 +172,17 @@ var _ = Describe("DiegoProxyAuthenticator", func() {
 				Expect(permissions.CriticalOptions["log-message"]).To(MatchJSON(expectedConfig))
 			})
 
+			It("saves the session principal and target in the critical options of the permissions", func() {
+				Expect(permissions.CriticalOptions["session-limits"]).To(MatchJSON(`{
+					"principal": "diego",
+					"target": "some-guid/0"
+				}`))
+			})
+
+			It("saves the realm of the user in the critical options of the permissions", func() {
+				Expect(permissions.CriticalOptions["user-identity"]).To(MatchJSON(`{"realm": "diego"}`))
+			})
+
 			Context("when getting the desired LRP information fails", func() {
 				BeforeEach(func() {
 					receptorClient.GetDesiredLRPReturns(receptor.DesiredLRPResponse{}, &receptor.Error{})
@@ -204,6 +215,43 @@ var _ = Describe("DiegoProxyAuthenticator", func() {
 			})
 		})
 
+		Context("when the user selects every instance", func() {
+			BeforeEach(func() {
+				metadata.UserReturns("diego:some-guid/*")
+				password = []byte("receptor-user:receptor-password")
+
+				running := actualLrpResponse
+				running.State = receptor.ActualLRPStateRunning
+				receptorClient.ActualLRPsByProcessGuidReturns([]receptor.ActualLRPResponse{running}, nil)
+			})
+
+			It("saves the running instances in the critical options of the permissions", func() {
+				Expect(authErr).NotTo(HaveOccurred())
+				Expect(receptorClient.ActualLRPsByProcessGuidArgsForCall(0)).To(Equal("some-guid"))
+				Expect(permissions.CriticalOptions["proxy-fan-out-targets"]).To(MatchJSON(`[{
+					"index": 0,
+					"target": {
+						"address": "1.2.3.4:3333",
+						"host_fingerprint": "host-fingerprint",
+						"private_key": "pem-encoded-key",
+						"user": "user",
+						"password": "password"
+					}
+				}]`))
+			})
+
+			Context("when the password doesn't match the receptor credentials", func() {
+				BeforeEach(func() {
+					password = []byte("cf-user:cf-password")
+				})
+
+				It("fails the authentication", func() {
+					Expect(authErr).To(MatchError("Invalid credentials"))
+					Expect(receptorClient.ActualLRPsByProcessGuidCallCount()).To(Equal(0))
+				})
+			})
+		})
+
 		Context("when the ssh route is misconfigured", func() {
 			BeforeEach(func() {
 				metadata.UserReturns("diego:some-guid/0")
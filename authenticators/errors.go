@@ -10,3 +10,4 @@ var InvalidCCResponse = errors.New("CloudController Response Invalid")
 var InvalidDomainErr error = errors.New("Invalid authentication domain")
 var InvalidCredentialsErr error = errors.New("Invalid credentials")
 var RouteNotFoundErr error = errors.New("SSH routing info not found")
+var TargetNotFoundErr error = errors.New("Target not found")
@@ -0,0 +1,99 @@
+// This file was generated by counterfeiter
+package fake_authenticators
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
+)
+
+type FakeTargetResolver struct {
+	ResolveStub        func(processGuid string, index int) (*authenticators.Target, error)
+	resolveMutex       sync.RWMutex
+	resolveArgsForCall []struct {
+		processGuid string
+		index       int
+	}
+	resolveReturns struct {
+		result1 *authenticators.Target
+		result2 error
+	}
+	ResolveAllStub        func(processGuid string) ([]*authenticators.Target, error)
+	resolveAllMutex       sync.RWMutex
+	resolveAllArgsForCall []struct {
+		processGuid string
+	}
+	resolveAllReturns struct {
+		result1 []*authenticators.Target
+		result2 error
+	}
+}
+
+func (fake *FakeTargetResolver) Resolve(processGuid string, index int) (*authenticators.Target, error) {
+	fake.resolveMutex.Lock()
+	fake.resolveArgsForCall = append(fake.resolveArgsForCall, struct {
+		processGuid string
+		index       int
+	}{processGuid, index})
+	fake.resolveMutex.Unlock()
+	if fake.ResolveStub != nil {
+		return fake.ResolveStub(processGuid, index)
+	} else {
+		return fake.resolveReturns.result1, fake.resolveReturns.result2
+	}
+}
+
+func (fake *FakeTargetResolver) ResolveCallCount() int {
+	fake.resolveMutex.RLock()
+	defer fake.resolveMutex.RUnlock()
+	return len(fake.resolveArgsForCall)
+}
+
+func (fake *FakeTargetResolver) ResolveArgsForCall(i int) (string, int) {
+	fake.resolveMutex.RLock()
+	defer fake.resolveMutex.RUnlock()
+	return fake.resolveArgsForCall[i].processGuid, fake.resolveArgsForCall[i].index
+}
+
+func (fake *FakeTargetResolver) ResolveReturns(result1 *authenticators.Target, result2 error) {
+	fake.ResolveStub = nil
+	fake.resolveReturns = struct {
+		result1 *authenticators.Target
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeTargetResolver) ResolveAll(processGuid string) ([]*authenticators.Target, error) {
+	fake.resolveAllMutex.Lock()
+	fake.resolveAllArgsForCall = append(fake.resolveAllArgsForCall, struct {
+		processGuid string
+	}{processGuid})
+	fake.resolveAllMutex.Unlock()
+	if fake.ResolveAllStub != nil {
+		return fake.ResolveAllStub(processGuid)
+	} else {
+		return fake.resolveAllReturns.result1, fake.resolveAllReturns.result2
+	}
+}
+
+func (fake *FakeTargetResolver) ResolveAllCallCount() int {
+	fake.resolveAllMutex.RLock()
+	defer fake.resolveAllMutex.RUnlock()
+	return len(fake.resolveAllArgsForCall)
+}
+
+func (fake *FakeTargetResolver) ResolveAllArgsForCall(i int) string {
+	fake.resolveAllMutex.RLock()
+	defer fake.resolveAllMutex.RUnlock()
+	return fake.resolveAllArgsForCall[i].processGuid
+}
+
+func (fake *FakeTargetResolver) ResolveAllReturns(result1 []*authenticators.Target, result2 error) {
+	fake.ResolveAllStub = nil
+	fake.resolveAllReturns = struct {
+		result1 []*authenticators.Target
+		result2 error
+	}{result1, result2}
+}
+
+var _ authenticators.TargetResolver = new(FakeTargetResolver)
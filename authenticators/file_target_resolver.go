@@ -0,0 +1,83 @@
+package authenticators
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+)
+
+type InventoryEntry struct {
+	ProcessGuid  string             `json:"process_guid"`
+	Index        int                `json:"index"`
+	LogGuid      string             `json:"log_guid,omitempty"`
+	TargetConfig proxy.TargetConfig `json:"target"`
+}
+
+type FileTargetResolver struct {
+	targets   map[string]*Target
+	processes map[string][]*Target
+}
+
+func NewFileTargetResolver(inventoryPath string) (*FileTargetResolver, error) {
+	file, err := os.Open(inventoryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []InventoryEntry
+	err = json.NewDecoder(file).Decode(&entries)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := map[string]*Target{}
+	processes := map[string][]*Target{}
+	for i := range entries {
+		entry := entries[i]
+		target := &Target{
+			TargetConfig: &entry.TargetConfig,
+			LogGuid:      entry.LogGuid,
+			Index:        entry.Index,
+		}
+		targets[inventoryKey(entry.ProcessGuid, entry.Index)] = target
+		processes[entry.ProcessGuid] = append(processes[entry.ProcessGuid], target)
+	}
+
+	for _, processTargets := range processes {
+		sort.Sort(byIndex(processTargets))
+	}
+
+	return &FileTargetResolver{targets: targets, processes: processes}, nil
+}
+
+func (r *FileTargetResolver) Resolve(processGuid string, index int) (*Target, error) {
+	target, ok := r.targets[inventoryKey(processGuid, index)]
+	if !ok {
+		return nil, TargetNotFoundErr
+	}
+
+	return target, nil
+}
+
+func (r *FileTargetResolver) ResolveAll(processGuid string) ([]*Target, error) {
+	targets, ok := r.processes[processGuid]
+	if !ok {
+		return nil, TargetNotFoundErr
+	}
+
+	return targets, nil
+}
+
+func inventoryKey(processGuid string, index int) string {
+	return fmt.Sprintf("%s/%d", processGuid, index)
+}
+
+type byIndex []*Target
+
+func (t byIndex) Len() int           { return len(t) }
+func (t byIndex) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t byIndex) Less(i, j int) bool { return t[i].Index < t[j].Index }
@@ -0,0 +1,118 @@
+package authenticators_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FileTargetResolver", func() {
+	var (
+		inventoryPath string
+		inventory     string
+
+		resolver    *authenticators.FileTargetResolver
+		resolverErr error
+	)
+
+	BeforeEach(func() {
+		inventory = `[
+			{
+				"process_guid": "some-guid",
+				"index": 1,
+				"log_guid": "log-guid",
+				"target": {
+					"address": "10.0.0.1:2222",
+					"host_fingerprint": "host-fingerprint",
+					"user": "vcap",
+					"password": "secret"
+				}
+			}
+		]`
+	})
+
+	JustBeforeEach(func() {
+		file, err := ioutil.TempFile("", "inventory")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.WriteString(inventory)
+		Expect(err).NotTo(HaveOccurred())
+		file.Close()
+		inventoryPath = file.Name()
+
+		resolver, resolverErr = authenticators.NewFileTargetResolver(inventoryPath)
+	})
+
+	AfterEach(func() {
+		os.Remove(inventoryPath)
+	})
+
+	It("resolves principals listed in the inventory", func() {
+		Expect(resolverErr).NotTo(HaveOccurred())
+
+		target, err := resolver.Resolve("some-guid", 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target.LogGuid).To(Equal("log-guid"))
+		Expect(target.TargetConfig).To(Equal(&proxy.TargetConfig{
+			Address:         "10.0.0.1:2222",
+			HostFingerprint: "host-fingerprint",
+			User:            "vcap",
+			Password:        "secret",
+		}))
+	})
+
+	It("fails to resolve principals missing from the inventory", func() {
+		Expect(resolverErr).NotTo(HaveOccurred())
+
+		_, err := resolver.Resolve("some-guid", 0)
+		Expect(err).To(Equal(authenticators.TargetNotFoundErr))
+	})
+
+	Context("when every instance of a process is resolved", func() {
+		BeforeEach(func() {
+			inventory = `[
+				{"process_guid": "some-guid", "index": 2, "target": {"address": "10.0.0.3:2222"}},
+				{"process_guid": "other-guid", "index": 0, "target": {"address": "10.0.0.9:2222"}},
+				{"process_guid": "some-guid", "index": 0, "target": {"address": "10.0.0.1:2222"}}
+			]`
+		})
+
+		It("returns the instances of the process ordered by index", func() {
+			Expect(resolverErr).NotTo(HaveOccurred())
+
+			targets, err := resolver.ResolveAll("some-guid")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targets).To(HaveLen(2))
+			Expect(targets[0].Index).To(Equal(0))
+			Expect(targets[0].TargetConfig.Address).To(Equal("10.0.0.1:2222"))
+			Expect(targets[1].Index).To(Equal(2))
+			Expect(targets[1].TargetConfig.Address).To(Equal("10.0.0.3:2222"))
+		})
+
+		It("fails to resolve processes missing from the inventory", func() {
+			_, err := resolver.ResolveAll("missing-guid")
+			Expect(err).To(Equal(authenticators.TargetNotFoundErr))
+		})
+	})
+
+	Context("when the inventory is malformed", func() {
+		BeforeEach(func() {
+			inventory = `{{`
+		})
+
+		It("returns an error", func() {
+			Expect(resolverErr).To(HaveOccurred())
+		})
+	})
+
+	Context("when the inventory does not exist", func() {
+		It("returns an error", func() {
+			_, err := authenticators.NewFileTargetResolver("/does/not/exist")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
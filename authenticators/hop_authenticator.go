@@ -0,0 +1,54 @@
+package authenticators
+
+import (
+	"bytes"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	"golang.org/x/crypto/ssh"
+)
+
+// HopAuthenticator accepts sessions forwarded by other proxies. A forwarding
+// proxy presents a certificate signed by one of the trusted hop keys; the
+// certificate carries the target that proxy has already resolved and
+// authorized.
+type HopAuthenticator struct {
+	trustedKeys [][]byte
+	checker     *ssh.CertChecker
+}
+
+func NewHopAuthenticator(trustedKeys []ssh.PublicKey) *HopAuthenticator {
+	authenticator := &HopAuthenticator{}
+	for _, key := range trustedKeys {
+		authenticator.trustedKeys = append(authenticator.trustedKeys, key.Marshal())
+	}
+
+	authenticator.checker = &ssh.CertChecker{
+		IsUserAuthority:          authenticator.trusted,
+		SupportedCriticalOptions: proxy.HopCriticalOptions,
+	}
+
+	return authenticator
+}
+
+func (a *HopAuthenticator) Authenticate(metadata ssh.ConnMetadata, publicKey ssh.PublicKey) (*ssh.Permissions, error) {
+	permissions, err := a.checker.Authenticate(metadata, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if permissions.CriticalOptions["proxy-target-config"] == "" || permissions.CriticalOptions["proxy-hop-origin"] == "" {
+		return nil, InvalidCredentialsErr
+	}
+
+	return permissions, nil
+}
+
+func (a *HopAuthenticator) trusted(key ssh.PublicKey) bool {
+	marshaled := key.Marshal()
+	for _, trusted := range a.trustedKeys {
+		if bytes.Equal(marshaled, trusted) {
+			return true
+		}
+	}
+	return false
+}
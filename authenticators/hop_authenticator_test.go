@@ -0,0 +1,124 @@
+package authenticators_test
+
+import (
+	"crypto/rand"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
+	"github.com/cloudfoundry-incubator/diego-ssh/keys"
+	"github.com/cloudfoundry-incubator/diego-ssh/test_helpers/fake_ssh"
+	"golang.org/x/crypto/ssh"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HopAuthenticator", func() {
+	var (
+		hopKey        ssh.Signer
+		authenticator *authenticators.HopAuthenticator
+
+		metadata *fake_ssh.FakeConnMetadata
+		cert     *ssh.Certificate
+		signer   ssh.Signer
+
+		permissions *ssh.Permissions
+		authnError  error
+	)
+
+	BeforeEach(func() {
+		keyPair, err := keys.RSAKeyPairFactory.NewKeyPair(1024)
+		Expect(err).NotTo(HaveOccurred())
+		hopKey = keyPair.PrivateKey()
+
+		authenticator = authenticators.NewHopAuthenticator([]ssh.PublicKey{hopKey.PublicKey()})
+
+		metadata = &fake_ssh.FakeConnMetadata{}
+		metadata.UserReturns("cf:some-guid/1")
+
+		now := time.Now()
+		cert = &ssh.Certificate{
+			Key:             hopKey.PublicKey(),
+			CertType:        ssh.UserCert,
+			ValidPrincipals: []string{"cf:some-guid/1"},
+			ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+			ValidBefore:     uint64(now.Add(time.Minute).Unix()),
+			Permissions: ssh.Permissions{
+				CriticalOptions: map[string]string{
+					"proxy-target-config": `{"address":"1.2.3.4:2222"}`,
+					"proxy-hop-origin":    `{"user":"cf:some-guid/1","remote_addr":"5.6.7.8:1234"}`,
+				},
+			},
+		}
+		signer = hopKey
+	})
+
+	JustBeforeEach(func() {
+		Expect(cert.SignCert(rand.Reader, signer)).To(Succeed())
+		permissions, authnError = authenticator.Authenticate(metadata, cert)
+	})
+
+	It("returns the permissions carried by the certificate", func() {
+		Expect(authnError).NotTo(HaveOccurred())
+		Expect(permissions.CriticalOptions).To(Equal(cert.Permissions.CriticalOptions))
+	})
+
+	Context("when the certificate is not signed by a trusted key", func() {
+		BeforeEach(func() {
+			keyPair, err := keys.RSAKeyPairFactory.NewKeyPair(1024)
+			Expect(err).NotTo(HaveOccurred())
+			signer = keyPair.PrivateKey()
+		})
+
+		It("fails to authenticate", func() {
+			Expect(authnError).To(HaveOccurred())
+		})
+	})
+
+	Context("when the certificate is for another user", func() {
+		BeforeEach(func() {
+			cert.ValidPrincipals = []string{"cf:other-guid/0"}
+		})
+
+		It("fails to authenticate", func() {
+			Expect(authnError).To(HaveOccurred())
+		})
+	})
+
+	Context("when the certificate has expired", func() {
+		BeforeEach(func() {
+			cert.ValidBefore = uint64(time.Now().Add(-time.Second).Unix())
+		})
+
+		It("fails to authenticate", func() {
+			Expect(authnError).To(HaveOccurred())
+		})
+	})
+
+	Context("when the certificate does not carry a target", func() {
+		BeforeEach(func() {
+			delete(cert.Permissions.CriticalOptions, "proxy-target-config")
+		})
+
+		It("fails to authenticate", func() {
+			Expect(authnError).To(Equal(authenticators.InvalidCredentialsErr))
+		})
+	})
+
+	Context("when the certificate carries an unsupported option", func() {
+		BeforeEach(func() {
+			cert.Permissions.CriticalOptions["force-command"] = "/bin/true"
+		})
+
+		It("fails to authenticate", func() {
+			Expect(authnError).To(HaveOccurred())
+		})
+	})
+
+	Context("when a plain public key is presented", func() {
+		It("fails to authenticate", func() {
+			_, err := authenticator.Authenticate(metadata, hopKey.PublicKey())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
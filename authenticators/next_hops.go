@@ -0,0 +1,59 @@
+package authenticators
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+)
+
+// NextHopRoute sends the targets on the cells whose id matches CellPattern
+// through NextHop. Patterns use path.Match syntax, so the cells of an
+// isolation segment can share one route when their ids share a prefix.
+type NextHopRoute struct {
+	CellPattern string        `json:"cell_pattern"`
+	NextHop     proxy.NextHop `json:"next_hop"`
+}
+
+// NextHops are tried in order; the first route that matches the cell of a
+// target is used.
+type NextHops []NextHopRoute
+
+func LoadNextHops(nextHopsPath string) (NextHops, error) {
+	file, err := os.Open(nextHopsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var nextHops NextHops
+	err = json.NewDecoder(file).Decode(&nextHops)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, route := range nextHops {
+		_, err := path.Match(route.CellPattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid cell pattern %q: %s", route.CellPattern, err)
+		}
+
+		if route.NextHop.Address == "" {
+			return nil, fmt.Errorf("next hop for %q has no address", route.CellPattern)
+		}
+	}
+
+	return nextHops, nil
+}
+
+func (n NextHops) forCell(cellID string) *proxy.NextHop {
+	for _, route := range n {
+		if matched, _ := path.Match(route.CellPattern, cellID); matched {
+			nextHop := route.NextHop
+			return &nextHop
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package authenticators_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LoadNextHops", func() {
+	var (
+		nextHopsPath string
+		contents     string
+
+		nextHops authenticators.NextHops
+		loadErr  error
+	)
+
+	BeforeEach(func() {
+		contents = `[
+			{
+				"cell_pattern": "segment-a-*",
+				"next_hop": {
+					"address": "ssh-proxy.segment-a.internal:2222",
+					"host_fingerprint": "host-fingerprint",
+					"private_key": "hop-key"
+				}
+			}
+		]`
+	})
+
+	JustBeforeEach(func() {
+		file, err := ioutil.TempFile("", "next-hops")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.WriteString(contents)
+		Expect(err).NotTo(HaveOccurred())
+		file.Close()
+		nextHopsPath = file.Name()
+
+		nextHops, loadErr = authenticators.LoadNextHops(nextHopsPath)
+	})
+
+	AfterEach(func() {
+		os.Remove(nextHopsPath)
+	})
+
+	It("loads the routes in order", func() {
+		Expect(loadErr).NotTo(HaveOccurred())
+		Expect(nextHops).To(Equal(authenticators.NextHops{
+			{
+				CellPattern: "segment-a-*",
+				NextHop: proxy.NextHop{
+					Address:         "ssh-proxy.segment-a.internal:2222",
+					HostFingerprint: "host-fingerprint",
+					PrivateKey:      "hop-key",
+				},
+			},
+		}))
+	})
+
+	Context("when a cell pattern is malformed", func() {
+		BeforeEach(func() {
+			contents = `[{"cell_pattern": "segment-[", "next_hop": {"address": "proxy:2222"}}]`
+		})
+
+		It("returns an error", func() {
+			Expect(loadErr).To(MatchError(ContainSubstring("invalid cell pattern")))
+		})
+	})
+
+	Context("when a next hop has no address", func() {
+		BeforeEach(func() {
+			contents = `[{"cell_pattern": "*", "next_hop": {}}]`
+		})
+
+		It("returns an error", func() {
+			Expect(loadErr).To(MatchError(ContainSubstring("has no address")))
+		})
+	})
+
+	Context("when the file cannot be read", func() {
+		JustBeforeEach(func() {
+			nextHops, loadErr = authenticators.LoadNextHops("/does/not/exist.json")
+		})
+
+		It("returns an error", func() {
+			Expect(loadErr).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,48 @@
+package authenticators
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	"golang.org/x/crypto/ssh"
+)
+
+type PolicyAuthenticator struct {
+	authenticator PasswordAuthenticator
+	policy        *proxy.Policy
+}
+
+func NewPolicyAuthenticator(authenticator PasswordAuthenticator, policy *proxy.Policy) *PolicyAuthenticator {
+	return &PolicyAuthenticator{
+		authenticator: authenticator,
+		policy:        policy,
+	}
+}
+
+func (a *PolicyAuthenticator) Realm() string {
+	return a.authenticator.Realm()
+}
+
+func (a *PolicyAuthenticator) Authenticate(metadata ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	permissions, err := a.authenticator.Authenticate(metadata, password)
+	if err != nil {
+		return nil, err
+	}
+
+	targetPolicy, err := proxy.PolicyFromPermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	policyJson, err := json.Marshal(a.policy.Merge(targetPolicy))
+	if err != nil {
+		return nil, err
+	}
+
+	if permissions.CriticalOptions == nil {
+		permissions.CriticalOptions = map[string]string{}
+	}
+	permissions.CriticalOptions["proxy-policy"] = string(policyJson)
+
+	return permissions, nil
+}
@@ -0,0 +1,84 @@
+package authenticators_test
+
+import (
+	"errors"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
+	"github.com/cloudfoundry-incubator/diego-ssh/authenticators/fake_authenticators"
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	"github.com/cloudfoundry-incubator/diego-ssh/test_helpers/fake_ssh"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+)
+
+var _ = Describe("PolicyAuthenticator", func() {
+	var (
+		innerAuthenticator *fake_authenticators.FakePasswordAuthenticator
+		policy             *proxy.Policy
+		authenticator      *authenticators.PolicyAuthenticator
+		metadata           *fake_ssh.FakeConnMetadata
+	)
+
+	BeforeEach(func() {
+		innerAuthenticator = &fake_authenticators.FakePasswordAuthenticator{}
+		innerAuthenticator.RealmReturns("cf")
+		innerAuthenticator.AuthenticateReturns(&ssh.Permissions{
+			CriticalOptions: map[string]string{"proxy-target-config": "{}"},
+		}, nil)
+
+		policy = &proxy.Policy{DeniedChannelTypes: []string{"direct-tcpip"}}
+		metadata = &fake_ssh.FakeConnMetadata{}
+	})
+
+	JustBeforeEach(func() {
+		authenticator = authenticators.NewPolicyAuthenticator(innerAuthenticator, policy)
+	})
+
+	It("uses the realm of the wrapped authenticator", func() {
+		Expect(authenticator.Realm()).To(Equal("cf"))
+	})
+
+	It("adds the policy to the permissions", func() {
+		permissions, err := authenticator.Authenticate(metadata, []byte("password"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(permissions.CriticalOptions).To(HaveKeyWithValue("proxy-target-config", "{}"))
+
+		effective, err := proxy.PolicyFromPermissions(permissions)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(effective).To(Equal(policy))
+	})
+
+	Context("when the wrapped authenticator provides a policy", func() {
+		BeforeEach(func() {
+			innerAuthenticator.AuthenticateReturns(&ssh.Permissions{
+				CriticalOptions: map[string]string{
+					"proxy-policy": `{"allowed_request_types":["exec"]}`,
+				},
+			}, nil)
+		})
+
+		It("combines both policies", func() {
+			permissions, err := authenticator.Authenticate(metadata, []byte("password"))
+			Expect(err).NotTo(HaveOccurred())
+
+			effective, err := proxy.PolicyFromPermissions(permissions)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(effective.AllowsChannel("direct-tcpip", nil)).To(BeFalse())
+			Expect(effective.AllowsRequest("exec")).To(BeTrue())
+			Expect(effective.AllowsRequest("shell")).To(BeFalse())
+		})
+	})
+
+	Context("when the wrapped authenticator fails", func() {
+		BeforeEach(func() {
+			innerAuthenticator.AuthenticateReturns(nil, errors.New("boom"))
+		})
+
+		It("returns the error", func() {
+			_, err := authenticator.Authenticate(metadata, []byte("password"))
+			Expect(err).To(MatchError("boom"))
+		})
+	})
+})
@@ -0,0 +1,177 @@
+package authenticators
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/cache"
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	"github.com/cloudfoundry-incubator/diego-ssh/routes"
+	"github.com/cloudfoundry-incubator/receptor"
+)
+
+type ReceptorTargetResolver struct {
+	receptorClient receptor.Client
+	routingCache   *cache.Cache
+	nextHops       NextHops
+}
+
+type routingInfo struct {
+	sshRoute *routes.SSHRoute
+	logGuid  string
+	ports    []uint16
+}
+
+// NewReceptorTargetResolver resolves targets through the receptor. Routing
+// information from desired LRPs is kept in routingCache when it is not nil;
+// actual LRPs are always looked up. Targets on cells matched by nextHops are
+// reached through the next hop of the matching route.
+func NewReceptorTargetResolver(receptorClient receptor.Client, routingCache *cache.Cache, nextHops NextHops) *ReceptorTargetResolver {
+	return &ReceptorTargetResolver{
+		receptorClient: receptorClient,
+		routingCache:   routingCache,
+		nextHops:       nextHops,
+	}
+}
+
+func (r *ReceptorTargetResolver) Resolve(processGuid string, index int) (*Target, error) {
+	actual, err := r.receptorClient.ActualLRPByProcessGuidAndIndex(processGuid, index)
+	if err != nil {
+		return nil, err
+	}
+
+	routing, err := r.routing(processGuid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Target{
+		TargetConfig: r.targetConfig(routing, &actual),
+		LogGuid:      routing.logGuid,
+		Index:        actual.Index,
+	}, nil
+}
+
+// ResolveAll only returns running instances; instances that are starting or
+// crashed cannot accept connections.
+func (r *ReceptorTargetResolver) ResolveAll(processGuid string) ([]*Target, error) {
+	actuals, err := r.receptorClient.ActualLRPsByProcessGuid(processGuid)
+	if err != nil {
+		return nil, err
+	}
+
+	routing, err := r.routing(processGuid)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []*Target{}
+	for i := range actuals {
+		if actuals[i].State != receptor.ActualLRPStateRunning {
+			continue
+		}
+
+		targets = append(targets, &Target{
+			TargetConfig: r.targetConfig(routing, &actuals[i]),
+			LogGuid:      routing.logGuid,
+			Index:        actuals[i].Index,
+		})
+	}
+
+	sort.Sort(byIndex(targets))
+
+	return targets, nil
+}
+
+func (r *ReceptorTargetResolver) routing(processGuid string) (*routingInfo, error) {
+	value, err := r.routingCache.Fetch(processGuid, func() (interface{}, error) {
+		desired, err := r.receptorClient.GetDesiredLRP(processGuid)
+		if err != nil {
+			return nil, err
+		}
+
+		sshRoute, err := getRoutingInfo(&desired)
+		if err != nil {
+			return nil, err
+		}
+
+		return &routingInfo{sshRoute: sshRoute, logGuid: desired.LogGuid, ports: desired.Ports}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*routingInfo), nil
+}
+
+func (r *ReceptorTargetResolver) targetConfig(routing *routingInfo, actual *receptor.ActualLRPResponse) *proxy.TargetConfig {
+	config := targetConfig(routing, actual)
+	if config != nil {
+		config.NextHop = r.nextHops.forCell(actual.CellID)
+	}
+	return config
+}
+
+func targetConfig(routing *routingInfo, actual *receptor.ActualLRPResponse) *proxy.TargetConfig {
+	sshRoute := routing.sshRoute
+	if sshRoute.Direct {
+		return directTargetConfig(routing.ports, actual)
+	}
+
+	for _, mapping := range actual.Ports {
+		if mapping.ContainerPort == sshRoute.ContainerPort {
+			return &proxy.TargetConfig{
+				Address:         fmt.Sprintf("%s:%d", actual.Address, mapping.HostPort),
+				HostFingerprint: sshRoute.HostFingerprint,
+				User:            sshRoute.User,
+				Password:        sshRoute.Password,
+				PrivateKey:      sshRoute.PrivateKey,
+			}
+		}
+	}
+
+	return nil
+}
+
+// directTargetConfig exposes the host side mappings of the ports declared by
+// the desired LRP. Other mapped ports are not reachable.
+func directTargetConfig(declared []uint16, actual *receptor.ActualLRPResponse) *proxy.TargetConfig {
+	ports := []proxy.DirectPort{}
+	for _, mapping := range actual.Ports {
+		for _, port := range declared {
+			if mapping.ContainerPort == port {
+				ports = append(ports, proxy.DirectPort{
+					ContainerPort: port,
+					Address:       fmt.Sprintf("%s:%d", actual.Address, mapping.HostPort),
+				})
+				break
+			}
+		}
+	}
+
+	return &proxy.TargetConfig{
+		Address: actual.Address,
+		Direct:  true,
+		Ports:   ports,
+	}
+}
+
+func getRoutingInfo(desired *receptor.DesiredLRPResponse) (*routes.SSHRoute, error) {
+	if desired.Routes == nil {
+		return nil, RouteNotFoundErr
+	}
+
+	rawMessage := desired.Routes[routes.DIEGO_SSH]
+	if rawMessage == nil {
+		return nil, RouteNotFoundErr
+	}
+
+	var sshRoute routes.SSHRoute
+	err := json.Unmarshal(*rawMessage, &sshRoute)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sshRoute, nil
+}
@@ -0,0 +1,262 @@
+package authenticators_test
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
+	"github.com/cloudfoundry-incubator/diego-ssh/cache"
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	"github.com/cloudfoundry-incubator/diego-ssh/routes"
+	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/cloudfoundry-incubator/receptor/fake_receptor"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReceptorTargetResolver", func() {
+	var (
+		receptorClient     *fake_receptor.FakeClient
+		desiredLRPResponse receptor.DesiredLRPResponse
+		actualLRPResponse  receptor.ActualLRPResponse
+		routingCache       *cache.Cache
+		nextHops           authenticators.NextHops
+		resolver           *authenticators.ReceptorTargetResolver
+
+		target     *authenticators.Target
+		resolveErr error
+	)
+
+	BeforeEach(func() {
+		receptorClient = new(fake_receptor.FakeClient)
+
+		sshRoutePayload, err := json.Marshal(routes.SSHRoute{
+			ContainerPort:   1111,
+			PrivateKey:      "pem-encoded-key",
+			HostFingerprint: "host-fingerprint",
+			User:            "user",
+			Password:        "password",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		sshRouteMessage := json.RawMessage(sshRoutePayload)
+
+		desiredLRPResponse = receptor.DesiredLRPResponse{
+			ProcessGuid: "some-guid",
+			Instances:   2,
+			Routes: receptor.RoutingInfo{
+				routes.DIEGO_SSH: &sshRouteMessage,
+			},
+			LogGuid: "log-guid",
+		}
+
+		actualLRPResponse = receptor.ActualLRPResponse{
+			ProcessGuid: "some-guid",
+			Index:       1,
+			Address:     "1.2.3.4",
+			Ports: []receptor.PortMapping{
+				{ContainerPort: 8080, HostPort: 2222},
+				{ContainerPort: 1111, HostPort: 3333},
+			},
+		}
+
+		receptorClient.ActualLRPByProcessGuidAndIndexReturns(actualLRPResponse, nil)
+		receptorClient.GetDesiredLRPReturns(desiredLRPResponse, nil)
+
+		routingCache = nil
+		nextHops = nil
+	})
+
+	JustBeforeEach(func() {
+		resolver = authenticators.NewReceptorTargetResolver(receptorClient, routingCache, nextHops)
+		target, resolveErr = resolver.Resolve("some-guid", 1)
+	})
+
+	It("looks up the actual and desired LRP", func() {
+		Expect(receptorClient.ActualLRPByProcessGuidAndIndexCallCount()).To(Equal(1))
+		guid, index := receptorClient.ActualLRPByProcessGuidAndIndexArgsForCall(0)
+		Expect(guid).To(Equal("some-guid"))
+		Expect(index).To(Equal(1))
+
+		Expect(receptorClient.GetDesiredLRPCallCount()).To(Equal(1))
+		Expect(receptorClient.GetDesiredLRPArgsForCall(0)).To(Equal("some-guid"))
+	})
+
+	It("builds the target from the ssh route and the host side port mapping", func() {
+		Expect(resolveErr).NotTo(HaveOccurred())
+		Expect(target.LogGuid).To(Equal("log-guid"))
+		Expect(target.TargetConfig).To(Equal(&proxy.TargetConfig{
+			Address:         "1.2.3.4:3333",
+			HostFingerprint: "host-fingerprint",
+			User:            "user",
+			Password:        "password",
+			PrivateKey:      "pem-encoded-key",
+		}))
+	})
+
+	Context("when the ssh route is direct", func() {
+		BeforeEach(func() {
+			sshRoutePayload, err := json.Marshal(routes.SSHRoute{Direct: true})
+			Expect(err).NotTo(HaveOccurred())
+
+			sshRouteMessage := json.RawMessage(sshRoutePayload)
+			desiredLRPResponse.Routes[routes.DIEGO_SSH] = &sshRouteMessage
+			desiredLRPResponse.Ports = []uint16{8080, 9090}
+
+			receptorClient.GetDesiredLRPReturns(desiredLRPResponse, nil)
+		})
+
+		It("exposes the host side mappings of the declared ports", func() {
+			Expect(resolveErr).NotTo(HaveOccurred())
+			Expect(target.TargetConfig).To(Equal(&proxy.TargetConfig{
+				Address: "1.2.3.4",
+				Direct:  true,
+				Ports: []proxy.DirectPort{
+					{ContainerPort: 8080, Address: "1.2.3.4:2222"},
+				},
+			}))
+		})
+	})
+
+	Context("when next hops are configured", func() {
+		BeforeEach(func() {
+			nextHops = authenticators.NextHops{
+				{CellPattern: "segment-a-*", NextHop: proxy.NextHop{Address: "proxy-a:2222", PrivateKey: "hop-key-a"}},
+				{CellPattern: "*", NextHop: proxy.NextHop{Address: "proxy-default:2222", PrivateKey: "hop-key"}},
+			}
+		})
+
+		Context("and the cell matches a route", func() {
+			BeforeEach(func() {
+				actualLRPResponse.CellID = "segment-a-cell-1"
+				receptorClient.ActualLRPByProcessGuidAndIndexReturns(actualLRPResponse, nil)
+			})
+
+			It("reaches the target through the next hop of the first matching route", func() {
+				Expect(resolveErr).NotTo(HaveOccurred())
+				Expect(target.TargetConfig.Address).To(Equal("1.2.3.4:3333"))
+				Expect(target.TargetConfig.NextHop).To(Equal(&proxy.NextHop{Address: "proxy-a:2222", PrivateKey: "hop-key-a"}))
+			})
+		})
+
+		Context("and only a later route matches", func() {
+			BeforeEach(func() {
+				nextHops = authenticators.NextHops{
+					nextHops[0],
+					{CellPattern: "segment-b-*", NextHop: proxy.NextHop{Address: "proxy-b:2222"}},
+				}
+
+				actualLRPResponse.CellID = "segment-b-cell-0"
+				receptorClient.ActualLRPByProcessGuidAndIndexReturns(actualLRPResponse, nil)
+			})
+
+			It("uses that route", func() {
+				Expect(resolveErr).NotTo(HaveOccurred())
+				Expect(target.TargetConfig.NextHop).To(Equal(&proxy.NextHop{Address: "proxy-b:2222"}))
+			})
+		})
+
+		Context("and no route matches the cell", func() {
+			BeforeEach(func() {
+				nextHops = nextHops[:1]
+
+				actualLRPResponse.CellID = "cell-z1-0"
+				receptorClient.ActualLRPByProcessGuidAndIndexReturns(actualLRPResponse, nil)
+			})
+
+			It("dials the target directly", func() {
+				Expect(resolveErr).NotTo(HaveOccurred())
+				Expect(target.TargetConfig.NextHop).To(BeNil())
+			})
+		})
+	})
+
+	Context("when every instance is resolved", func() {
+		var targets []*authenticators.Target
+
+		BeforeEach(func() {
+			running := actualLRPResponse
+			running.State = receptor.ActualLRPStateRunning
+
+			starting := actualLRPResponse
+			starting.Index = 0
+			starting.State = receptor.ActualLRPStateClaimed
+
+			receptorClient.ActualLRPsByProcessGuidReturns([]receptor.ActualLRPResponse{running, starting}, nil)
+		})
+
+		JustBeforeEach(func() {
+			targets, resolveErr = resolver.ResolveAll("some-guid")
+		})
+
+		It("returns the running instances", func() {
+			Expect(resolveErr).NotTo(HaveOccurred())
+			Expect(receptorClient.ActualLRPsByProcessGuidArgsForCall(0)).To(Equal("some-guid"))
+
+			Expect(targets).To(HaveLen(1))
+			Expect(targets[0].Index).To(Equal(1))
+			Expect(targets[0].LogGuid).To(Equal("log-guid"))
+			Expect(targets[0].TargetConfig.Address).To(Equal("1.2.3.4:3333"))
+		})
+
+		Context("when the actual LRPs cannot be retrieved", func() {
+			BeforeEach(func() {
+				receptorClient.ActualLRPsByProcessGuidReturns(nil, errors.New("boom"))
+			})
+
+			It("returns the error", func() {
+				Expect(resolveErr).To(MatchError("boom"))
+			})
+		})
+	})
+
+	Context("when a routing cache is provided", func() {
+		BeforeEach(func() {
+			routingCache = cache.New("Routing", 10, time.Minute)
+		})
+
+		It("only looks up the desired LRP once", func() {
+			_, err := resolver.Resolve("some-guid", 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(receptorClient.GetDesiredLRPCallCount()).To(Equal(1))
+			Expect(receptorClient.ActualLRPByProcessGuidAndIndexCallCount()).To(Equal(2))
+		})
+	})
+
+	Context("when the ssh container port is not mapped", func() {
+		BeforeEach(func() {
+			actualLRPResponse.Ports = []receptor.PortMapping{{ContainerPort: 8080, HostPort: 2222}}
+			receptorClient.ActualLRPByProcessGuidAndIndexReturns(actualLRPResponse, nil)
+		})
+
+		It("returns a target without a target config", func() {
+			Expect(resolveErr).NotTo(HaveOccurred())
+			Expect(target.TargetConfig).To(BeNil())
+		})
+	})
+
+	Context("when the desired LRP has no ssh route", func() {
+		BeforeEach(func() {
+			desiredLRPResponse.Routes = receptor.RoutingInfo{}
+			receptorClient.GetDesiredLRPReturns(desiredLRPResponse, nil)
+		})
+
+		It("returns a RouteNotFoundErr", func() {
+			Expect(resolveErr).To(Equal(authenticators.RouteNotFoundErr))
+		})
+	})
+
+	Context("when the actual LRP cannot be retrieved", func() {
+		BeforeEach(func() {
+			receptorClient.ActualLRPByProcessGuidAndIndexReturns(receptor.ActualLRPResponse{}, &receptor.Error{})
+		})
+
+		It("returns the error", func() {
+			Expect(resolveErr).To(Equal(&receptor.Error{}))
+			Expect(receptorClient.GetDesiredLRPCallCount()).To(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,58 @@
+package authenticators
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	"golang.org/x/crypto/ssh"
+)
+
+type SessionLimitAuthenticator struct {
+	authenticator        PasswordAuthenticator
+	maxPrincipalSessions int
+	maxTargetSessions    int
+}
+
+func NewSessionLimitAuthenticator(
+	authenticator PasswordAuthenticator,
+	maxPrincipalSessions int,
+	maxTargetSessions int,
+) *SessionLimitAuthenticator {
+	return &SessionLimitAuthenticator{
+		authenticator:        authenticator,
+		maxPrincipalSessions: maxPrincipalSessions,
+		maxTargetSessions:    maxTargetSessions,
+	}
+}
+
+func (a *SessionLimitAuthenticator) Realm() string {
+	return a.authenticator.Realm()
+}
+
+func (a *SessionLimitAuthenticator) Authenticate(metadata ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	permissions, err := a.authenticator.Authenticate(metadata, password)
+	if err != nil {
+		return nil, err
+	}
+
+	limits, err := proxy.SessionLimitsFromPermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	if limits == nil {
+		return permissions, nil
+	}
+
+	limits.MaxPrincipalSessions = a.maxPrincipalSessions
+	limits.MaxTargetSessions = a.maxTargetSessions
+
+	limitsJson, err := json.Marshal(limits)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions.CriticalOptions["session-limits"] = string(limitsJson)
+
+	return permissions, nil
+}
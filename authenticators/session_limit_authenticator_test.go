@@ -0,0 +1,72 @@
+package authenticators_test
+
+import (
+	"errors"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
+	"github.com/cloudfoundry-incubator/diego-ssh/authenticators/fake_authenticators"
+	"github.com/cloudfoundry-incubator/diego-ssh/test_helpers/fake_ssh"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+)
+
+var _ = Describe("SessionLimitAuthenticator", func() {
+	var (
+		innerAuthenticator *fake_authenticators.FakePasswordAuthenticator
+		authenticator      *authenticators.SessionLimitAuthenticator
+		metadata           *fake_ssh.FakeConnMetadata
+	)
+
+	BeforeEach(func() {
+		innerAuthenticator = &fake_authenticators.FakePasswordAuthenticator{}
+		innerAuthenticator.RealmReturns("cf")
+		innerAuthenticator.AuthenticateReturns(&ssh.Permissions{
+			CriticalOptions: map[string]string{
+				"session-limits": `{"principal":"cf:user-guid","target":"process-guid/0"}`,
+			},
+		}, nil)
+
+		metadata = &fake_ssh.FakeConnMetadata{}
+		authenticator = authenticators.NewSessionLimitAuthenticator(innerAuthenticator, 5, 2)
+	})
+
+	It("uses the realm of the wrapped authenticator", func() {
+		Expect(authenticator.Realm()).To(Equal("cf"))
+	})
+
+	It("adds the limits to the session principal and target", func() {
+		permissions, err := authenticator.Authenticate(metadata, []byte("password"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(permissions.CriticalOptions["session-limits"]).To(MatchJSON(`{
+			"principal": "cf:user-guid",
+			"max_principal_sessions": 5,
+			"target": "process-guid/0",
+			"max_target_sessions": 2
+		}`))
+	})
+
+	Context("when the permissions do not identify the session", func() {
+		BeforeEach(func() {
+			innerAuthenticator.AuthenticateReturns(&ssh.Permissions{}, nil)
+		})
+
+		It("leaves the permissions alone", func() {
+			permissions, err := authenticator.Authenticate(metadata, []byte("password"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(permissions.CriticalOptions).To(BeEmpty())
+		})
+	})
+
+	Context("when the wrapped authenticator fails", func() {
+		BeforeEach(func() {
+			innerAuthenticator.AuthenticateReturns(nil, errors.New("boom"))
+		})
+
+		It("returns the error", func() {
+			_, err := authenticator.Authenticate(metadata, []byte("password"))
+			Expect(err).To(MatchError("boom"))
+		})
+	})
+})
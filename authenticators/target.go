@@ -0,0 +1,167 @@
+package authenticators
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/identity"
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
+	"golang.org/x/crypto/ssh"
+)
+
+// FAN_OUT_INDEX in place of an instance index selects every running
+// instance.
+const FAN_OUT_INDEX = "*"
+
+type Target struct {
+	TargetConfig *proxy.TargetConfig
+	LogGuid      string
+	Index        int
+}
+
+func sshPermissionsFromProcess(
+	span *tracing.Span,
+	processGuid string,
+	index int,
+	targetResolver TargetResolver,
+	remoteAddr net.Addr,
+	principal string,
+	userIdentity identity.Identity,
+	sessionLogs bool,
+) (*ssh.Permissions, error) {
+	resolve := span.Child("resolve-target")
+	target, err := targetResolver.Resolve(processGuid, index)
+	resolve.Finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	logMessage := fmt.Sprintf("Successful remote access by %s", remoteAddr.String())
+
+	limits := proxy.SessionLimits{
+		Principal: principal,
+		Target:    fmt.Sprintf("%s/%d", processGuid, index),
+	}
+
+	return createPermissions(target, logMessage, index, sessionLogs, limits, userIdentity)
+}
+
+// sshPermissionsFromProcessInstances builds the permissions of a fan-out
+// login that runs commands on every running instance of the process.
+func sshPermissionsFromProcessInstances(
+	span *tracing.Span,
+	processGuid string,
+	targetResolver TargetResolver,
+	remoteAddr net.Addr,
+	principal string,
+	userIdentity identity.Identity,
+	sessionLogs bool,
+) (*ssh.Permissions, error) {
+	resolve := span.Child("resolve-targets")
+	targets, err := targetResolver.ResolveAll(processGuid)
+	resolve.Finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	fanOutTargets := []proxy.FanOutTarget{}
+	limitTargets := []string{}
+	logGuid := ""
+	for _, target := range targets {
+		if target.TargetConfig == nil {
+			continue
+		}
+		fanOutTargets = append(fanOutTargets, proxy.FanOutTarget{Index: target.Index, TargetConfig: *target.TargetConfig})
+		limitTargets = append(limitTargets, fmt.Sprintf("%s/%d", processGuid, target.Index))
+		logGuid = target.LogGuid
+	}
+
+	if len(fanOutTargets) == 0 {
+		return nil, TargetNotFoundErr
+	}
+
+	fanOutJson, err := json.Marshal(fanOutTargets)
+	if err != nil {
+		return nil, err
+	}
+
+	logMessageJson, err := json.Marshal(proxy.LogMessage{
+		Guid:        logGuid,
+		Message:     fmt.Sprintf("Successful remote access by %s", remoteAddr.String()),
+		SessionLogs: sessionLogs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	limitsJson, err := json.Marshal(proxy.SessionLimits{
+		Principal: principal,
+		Targets:   limitTargets,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	identityJson, err := json.Marshal(userIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.Permissions{
+		CriticalOptions: map[string]string{
+			"proxy-fan-out-targets": string(fanOutJson),
+			"log-message":           string(logMessageJson),
+			"session-limits":        string(limitsJson),
+			"user-identity":         string(identityJson),
+		},
+	}, nil
+}
+
+func createPermissions(
+	target *Target,
+	logMessage string,
+	index int,
+	sessionLogs bool,
+	limits proxy.SessionLimits,
+	userIdentity identity.Identity,
+) (*ssh.Permissions, error) {
+	if target.TargetConfig == nil {
+		return &ssh.Permissions{}, nil
+	}
+
+	targetConfigJson, err := json.Marshal(target.TargetConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	logMessageJson, err := json.Marshal(proxy.LogMessage{
+		Guid:        target.LogGuid,
+		Message:     logMessage,
+		Index:       index,
+		SessionLogs: sessionLogs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	limitsJson, err := json.Marshal(limits)
+	if err != nil {
+		return nil, err
+	}
+
+	identityJson, err := json.Marshal(userIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.Permissions{
+		CriticalOptions: map[string]string{
+			"proxy-target-config": string(targetConfigJson),
+			"log-message":         string(logMessageJson),
+			"session-limits":      string(limitsJson),
+			"user-identity":       string(identityJson),
+		},
+	}, nil
+}
@@ -13,3 +13,12 @@ type PasswordAuthenticator interface {
 	Authenticate(metadata ssh.ConnMetadata, password []byte) (*ssh.Permissions, error)
 	Realm() string
 }
+
+//go:generate counterfeiter -o fake_authenticators/fake_target_resolver.go . TargetResolver
+type TargetResolver interface {
+	Resolve(processGuid string, index int) (*Target, error)
+
+	// ResolveAll returns the targets of every running instance ordered by
+	// index.
+	ResolveAll(processGuid string) ([]*Target, error)
+}
@@ -0,0 +1,78 @@
+package authenticators
+
+import (
+	"net"
+	"net/url"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/upstream"
+	"github.com/cloudfoundry-incubator/receptor"
+)
+
+type UpstreamTargetResolver struct {
+	targetResolver TargetResolver
+	upstream       *upstream.Upstream
+}
+
+func NewUpstreamTargetResolver(targetResolver TargetResolver, upstream *upstream.Upstream) *UpstreamTargetResolver {
+	return &UpstreamTargetResolver{
+		targetResolver: targetResolver,
+		upstream:       upstream,
+	}
+}
+
+func (r *UpstreamTargetResolver) Resolve(processGuid string, index int) (*Target, error) {
+	var target *Target
+
+	err := r.upstream.Do(func() error {
+		var err error
+		target, err = r.targetResolver.Resolve(processGuid, index)
+		if err != nil && !isTransient(err) {
+			return upstream.Permanent(err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return target, nil
+}
+
+func (r *UpstreamTargetResolver) ResolveAll(processGuid string) ([]*Target, error) {
+	var targets []*Target
+
+	err := r.upstream.Do(func() error {
+		var err error
+		targets, err = r.targetResolver.ResolveAll(processGuid)
+		if err != nil && !isTransient(err) {
+			return upstream.Permanent(err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return targets, nil
+}
+
+// transientReceptorErrors are the types of the errors the receptor reports
+// with 5xx responses. Responses that do not carry a receptor error, such as
+// those of the router in front of it, have no type.
+var transientReceptorErrors = map[string]bool{
+	"":             true,
+	"UnknownError": true,
+	"RouterError":  true,
+}
+
+func isTransient(err error) bool {
+	switch err := err.(type) {
+	case net.Error, *url.Error:
+		return true
+	case receptor.Error:
+		return transientReceptorErrors[err.Type]
+	case *receptor.Error:
+		return transientReceptorErrors[err.Type]
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package authenticators_test
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
+	"github.com/cloudfoundry-incubator/diego-ssh/authenticators/fake_authenticators"
+	"github.com/cloudfoundry-incubator/diego-ssh/upstream"
+	"github.com/cloudfoundry-incubator/receptor"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UpstreamTargetResolver", func() {
+	var (
+		fakeResolver *fake_authenticators.FakeTargetResolver
+		resolver     *authenticators.UpstreamTargetResolver
+
+		expectedTarget *authenticators.Target
+		target         *authenticators.Target
+		resolveErr     error
+	)
+
+	BeforeEach(func() {
+		fakeResolver = new(fake_authenticators.FakeTargetResolver)
+		expectedTarget = &authenticators.Target{LogGuid: "log-guid"}
+		fakeResolver.ResolveReturns(expectedTarget, nil)
+
+		receptorUpstream := upstream.New(lagertest.NewTestLogger("test"), "Receptor", upstream.Config{
+			Retries:          2,
+			RetryBackoff:     time.Millisecond,
+			FailureThreshold: 3,
+			ResetTimeout:     time.Minute,
+		})
+		resolver = authenticators.NewUpstreamTargetResolver(fakeResolver, receptorUpstream)
+	})
+
+	JustBeforeEach(func() {
+		target, resolveErr = resolver.Resolve("some-guid", 1)
+	})
+
+	It("resolves the target with the wrapped resolver", func() {
+		Expect(resolveErr).NotTo(HaveOccurred())
+		Expect(target).To(Equal(expectedTarget))
+
+		Expect(fakeResolver.ResolveCallCount()).To(Equal(1))
+		guid, index := fakeResolver.ResolveArgsForCall(0)
+		Expect(guid).To(Equal("some-guid"))
+		Expect(index).To(Equal(1))
+	})
+
+	It("resolves every instance with the wrapped resolver", func() {
+		fakeResolver.ResolveAllReturns([]*authenticators.Target{expectedTarget}, nil)
+
+		targets, err := resolver.ResolveAll("some-guid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targets).To(Equal([]*authenticators.Target{expectedTarget}))
+		Expect(fakeResolver.ResolveAllArgsForCall(0)).To(Equal("some-guid"))
+	})
+
+	Context("when the wrapped resolver returns an answer from the API", func() {
+		BeforeEach(func() {
+			fakeResolver.ResolveReturns(nil, authenticators.RouteNotFoundErr)
+		})
+
+		It("returns the error without retrying", func() {
+			Expect(resolveErr).To(Equal(authenticators.RouteNotFoundErr))
+			Expect(fakeResolver.ResolveCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("when the receptor reports that the LRP does not exist", func() {
+		var notFound error
+
+		BeforeEach(func() {
+			notFound = receptor.Error{Type: receptor.DesiredLRPNotFound, Message: "not found"}
+			fakeResolver.ResolveReturns(nil, notFound)
+		})
+
+		It("returns the error without retrying", func() {
+			Expect(resolveErr).To(Equal(notFound))
+			Expect(fakeResolver.ResolveCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("when the receptor fails with a server error", func() {
+		var serverErr error
+
+		BeforeEach(func() {
+			serverErr = receptor.Error{Type: "UnknownError", Message: "bbs unavailable"}
+			fakeResolver.ResolveReturns(nil, serverErr)
+		})
+
+		It("retries and returns the last error", func() {
+			Expect(resolveErr).To(Equal(serverErr))
+			Expect(fakeResolver.ResolveCallCount()).To(Equal(3))
+		})
+	})
+
+	Context("when the API cannot be reached", func() {
+		var urlErr error
+
+		BeforeEach(func() {
+			urlErr = &url.Error{Op: "Get", URL: "http://receptor", Err: errors.New("connection refused")}
+			fakeResolver.ResolveReturns(nil, urlErr)
+		})
+
+		It("retries and returns the last error", func() {
+			Expect(resolveErr).To(Equal(urlErr))
+			Expect(fakeResolver.ResolveCallCount()).To(Equal(3))
+		})
+
+		It("fails fast once the circuit is open", func() {
+			_, err := resolver.Resolve("some-guid", 1)
+			Expect(err).To(Equal(upstream.UnavailableErr))
+			Expect(fakeResolver.ResolveCallCount()).To(Equal(3))
+		})
+	})
+})
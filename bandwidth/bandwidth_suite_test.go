@@ -0,0 +1,13 @@
+package bandwidth_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestBandwidth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Bandwidth Suite")
+}
@@ -0,0 +1,69 @@
+package bandwidth
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket that limits the number of bytes per second. A nil
+// Bucket does not limit anything.
+type Bucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a bucket that allows rate bytes per second with bursts of
+// up to burst bytes.
+func NewBucket(rate, burst int64) *Bucket {
+	if burst < rate {
+		burst = rate
+	}
+
+	return &Bucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *Bucket) Burst() int {
+	if b == nil {
+		return 0
+	}
+	return int(b.burst)
+}
+
+// Reserve takes n tokens from the bucket and returns how long the caller has
+// to wait before they may be used.
+func (b *Bucket) Reserve(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *Bucket) Wait(n int) {
+	if wait := b.Reserve(n); wait > 0 {
+		time.Sleep(wait)
+	}
+}
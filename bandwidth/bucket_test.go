@@ -0,0 +1,66 @@
+package bandwidth_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/bandwidth"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bucket", func() {
+	It("allows a burst without waiting", func() {
+		bucket := bandwidth.NewBucket(1000, 4000)
+		Expect(bucket.Reserve(4000)).To(BeZero())
+	})
+
+	It("makes callers wait once the burst is used", func() {
+		bucket := bandwidth.NewBucket(1000, 1000)
+		Expect(bucket.Reserve(1000)).To(BeZero())
+
+		wait := bucket.Reserve(500)
+		Expect(wait).To(BeNumerically("~", 500*time.Millisecond, 50*time.Millisecond))
+	})
+
+	It("refills over time", func() {
+		bucket := bandwidth.NewBucket(10000, 10000)
+		Expect(bucket.Reserve(10000)).To(BeZero())
+
+		time.Sleep(100 * time.Millisecond)
+		Expect(bucket.Reserve(900)).To(BeZero())
+	})
+
+	Context("when the bucket is nil", func() {
+		It("never waits", func() {
+			var bucket *bandwidth.Bucket
+			Expect(bucket.Reserve(1 << 30)).To(BeZero())
+		})
+	})
+})
+
+var _ = Describe("Quota", func() {
+	It("allows transfers up to the limit", func() {
+		quota := bandwidth.NewQuota(10)
+
+		n, err := quota.Take(6)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(6))
+		Expect(quota.Exhausted()).To(BeFalse())
+
+		n, err = quota.Take(6)
+		Expect(err).To(Equal(bandwidth.QuotaExceededErr))
+		Expect(n).To(Equal(4))
+		Expect(quota.Exhausted()).To(BeTrue())
+	})
+
+	Context("when the quota is nil", func() {
+		It("is unlimited", func() {
+			var quota *bandwidth.Quota
+
+			n, err := quota.Take(1 << 30)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(Equal(1 << 30))
+			Expect(quota.Exhausted()).To(BeFalse())
+		})
+	})
+})
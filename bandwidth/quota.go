@@ -0,0 +1,51 @@
+package bandwidth
+
+import (
+	"errors"
+	"sync"
+)
+
+var QuotaExceededErr = errors.New("transfer quota exceeded")
+
+// Quota limits the total number of bytes transferred. A nil Quota is
+// unlimited.
+type Quota struct {
+	lock      sync.Mutex
+	remaining int64
+}
+
+func NewQuota(limit int64) *Quota {
+	return &Quota{remaining: limit}
+}
+
+// Take uses up to n bytes of the quota. It returns the number of bytes that
+// may be transferred and QuotaExceededErr when that is fewer than n.
+func (q *Quota) Take(n int) (int, error) {
+	if q == nil {
+		return n, nil
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if int64(n) <= q.remaining {
+		q.remaining -= int64(n)
+		return n, nil
+	}
+
+	allowed := int(q.remaining)
+	q.remaining = 0
+
+	return allowed, QuotaExceededErr
+}
+
+func (q *Quota) Exhausted() bool {
+	if q == nil {
+		return false
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.remaining <= 0
+}
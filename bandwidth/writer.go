@@ -0,0 +1,61 @@
+package bandwidth
+
+import "io"
+
+const maxChunkSize = 32 * 1024
+
+type writer struct {
+	writer  io.Writer
+	quota   *Quota
+	buckets []*Bucket
+}
+
+// NewWriter returns a writer that counts the data written against the quota
+// and waits for every bucket before passing it on.
+func NewWriter(w io.Writer, quota *Quota, buckets ...*Bucket) io.Writer {
+	return &writer{
+		writer:  w,
+		quota:   quota,
+		buckets: buckets,
+	}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		chunk := w.chunkSize(len(p))
+
+		allowed, quotaErr := w.quota.Take(chunk)
+		for _, bucket := range w.buckets {
+			bucket.Wait(allowed)
+		}
+
+		n, err := w.writer.Write(p[:allowed])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if quotaErr != nil {
+			return written, quotaErr
+		}
+
+		p = p[allowed:]
+	}
+
+	return written, nil
+}
+
+func (w *writer) chunkSize(size int) int {
+	if size > maxChunkSize {
+		size = maxChunkSize
+	}
+
+	for _, bucket := range w.buckets {
+		if burst := bucket.Burst(); burst > 0 && size > burst {
+			size = burst
+		}
+	}
+
+	return size
+}
@@ -0,0 +1,47 @@
+package bandwidth_test
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/bandwidth"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Writer", func() {
+	var buffer *bytes.Buffer
+
+	BeforeEach(func() {
+		buffer = &bytes.Buffer{}
+	})
+
+	It("passes the data on", func() {
+		writer := bandwidth.NewWriter(buffer, nil)
+
+		n, err := writer.Write([]byte("hello"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(5))
+		Expect(buffer.String()).To(Equal("hello"))
+	})
+
+	It("limits the rate of the data written", func() {
+		writer := bandwidth.NewWriter(buffer, nil, bandwidth.NewBucket(10000, 10000))
+
+		start := time.Now()
+		_, err := writer.Write(make([]byte, 13000))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(time.Since(start)).To(BeNumerically(">=", 250*time.Millisecond))
+		Expect(buffer.Len()).To(Equal(13000))
+	})
+
+	It("stops writing once the quota is exceeded", func() {
+		writer := bandwidth.NewWriter(buffer, bandwidth.NewQuota(3))
+
+		n, err := writer.Write([]byte("hello"))
+		Expect(err).To(Equal(bandwidth.QuotaExceededErr))
+		Expect(n).To(Equal(3))
+		Expect(buffer.String()).To(Equal("hel"))
+	})
+})
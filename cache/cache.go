@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+)
+
+type FetchFunc func() (interface{}, error)
+
+type Cache struct {
+	name       string
+	maxEntries int
+	ttl        time.Duration
+
+	mutex    sync.Mutex
+	lru      *list.List
+	entries  map[string]*list.Element
+	inflight map[string]*call
+
+	hits   uint64
+	misses uint64
+}
+
+type entry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+type call struct {
+	wg    sync.WaitGroup
+	value interface{}
+	err   error
+}
+
+// New returns a cache that holds at most maxEntries values for ttl. Hits and
+// misses are emitted as the <name>CacheHits and <name>CacheMisses counters.
+func New(name string, maxEntries int, ttl time.Duration) *Cache {
+	return &Cache{
+		name:       name,
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		lru:        list.New(),
+		entries:    map[string]*list.Element{},
+		inflight:   map[string]*call{},
+	}
+}
+
+// Fetch returns the cached value for key or calls fetch to produce it.
+// Concurrent fetches of the same key share a single call. Errors are not
+// cached. A nil cache calls fetch every time.
+func (c *Cache) Fetch(key string, fetch FetchFunc) (interface{}, error) {
+	if c == nil {
+		return fetch()
+	}
+
+	c.mutex.Lock()
+	if value, ok := c.get(key); ok {
+		c.hits++
+		c.mutex.Unlock()
+		c.emit(true)
+		return value, nil
+	}
+
+	c.misses++
+	if inflight, ok := c.inflight[key]; ok {
+		c.mutex.Unlock()
+		c.emit(false)
+		inflight.wg.Wait()
+		return inflight.value, inflight.err
+	}
+
+	inflight := &call{}
+	inflight.wg.Add(1)
+	c.inflight[key] = inflight
+	c.mutex.Unlock()
+	c.emit(false)
+
+	inflight.value, inflight.err = fetch()
+
+	c.mutex.Lock()
+	delete(c.inflight, key)
+	if inflight.err == nil {
+		c.add(key, inflight.value)
+	}
+	c.mutex.Unlock()
+
+	inflight.wg.Done()
+
+	return inflight.value, inflight.err
+}
+
+func (c *Cache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *Cache) Stats() (hits uint64, misses uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.hits, c.misses
+}
+
+func (c *Cache) get(key string) (interface{}, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := element.Value.(*entry)
+	if time.Now().After(e.expiresAt) {
+		c.remove(element)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	return e.value, true
+}
+
+func (c *Cache) add(key string, value interface{}) {
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	c.entries[key] = c.lru.PushFront(&entry{
+		key:       key,
+		value:     value,
+		expiresAt: time.Now().Add(c.ttl),
+	})
+
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*entry).key)
+}
+
+func (c *Cache) emit(hit bool) {
+	if hit {
+		metrics.IncrementCounter(c.name + "CacheHits")
+	} else {
+		metrics.IncrementCounter(c.name + "CacheMisses")
+	}
+}
@@ -0,0 +1,158 @@
+package cache_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/cache"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cache", func() {
+	var (
+		c          *cache.Cache
+		maxEntries int
+		ttl        time.Duration
+		fetchCount int32
+	)
+
+	fetcher := func(value string) cache.FetchFunc {
+		return func() (interface{}, error) {
+			atomic.AddInt32(&fetchCount, 1)
+			return value, nil
+		}
+	}
+
+	BeforeEach(func() {
+		maxEntries = 10
+		ttl = time.Minute
+		fetchCount = 0
+	})
+
+	JustBeforeEach(func() {
+		c = cache.New("Test", maxEntries, ttl)
+	})
+
+	It("fetches values that are not cached", func() {
+		value, err := c.Fetch("key", fetcher("value"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal("value"))
+		Expect(fetchCount).To(BeEquivalentTo(1))
+	})
+
+	It("serves cached values without fetching", func() {
+		c.Fetch("key", fetcher("value"))
+
+		value, err := c.Fetch("key", fetcher("other-value"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal("value"))
+		Expect(fetchCount).To(BeEquivalentTo(1))
+	})
+
+	It("tracks hits and misses", func() {
+		c.Fetch("key", fetcher("value"))
+		c.Fetch("key", fetcher("value"))
+		c.Fetch("key", fetcher("value"))
+		c.Fetch("other-key", fetcher("value"))
+
+		hits, misses := c.Stats()
+		Expect(hits).To(BeEquivalentTo(2))
+		Expect(misses).To(BeEquivalentTo(2))
+	})
+
+	It("does not cache errors", func() {
+		_, err := c.Fetch("key", func() (interface{}, error) {
+			return nil, errors.New("boom")
+		})
+		Expect(err).To(MatchError("boom"))
+
+		value, err := c.Fetch("key", fetcher("value"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal("value"))
+	})
+
+	Context("when an entry expires", func() {
+		BeforeEach(func() {
+			ttl = 20 * time.Millisecond
+		})
+
+		It("fetches the value again", func() {
+			c.Fetch("key", fetcher("value"))
+			time.Sleep(2 * ttl)
+
+			value, err := c.Fetch("key", fetcher("new-value"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal("new-value"))
+			Expect(fetchCount).To(BeEquivalentTo(2))
+		})
+	})
+
+	Context("when the cache is full", func() {
+		BeforeEach(func() {
+			maxEntries = 2
+		})
+
+		It("evicts the least recently used entry", func() {
+			c.Fetch("a", fetcher("a"))
+			c.Fetch("b", fetcher("b"))
+			c.Fetch("a", fetcher("a"))
+			c.Fetch("c", fetcher("c"))
+
+			Expect(c.Len()).To(Equal(2))
+
+			c.Fetch("a", fetcher("a"))
+			Expect(fetchCount).To(BeEquivalentTo(3))
+
+			c.Fetch("b", fetcher("b"))
+			Expect(fetchCount).To(BeEquivalentTo(4))
+		})
+	})
+
+	Context("when the same key is fetched concurrently", func() {
+		It("only calls fetch once", func() {
+			release := make(chan struct{})
+			blockingFetch := func() (interface{}, error) {
+				atomic.AddInt32(&fetchCount, 1)
+				<-release
+				return "value", nil
+			}
+
+			wg := &sync.WaitGroup{}
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					value, err := c.Fetch("key", blockingFetch)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(value).To(Equal("value"))
+				}()
+			}
+
+			Eventually(func() uint64 {
+				_, misses := c.Stats()
+				return misses
+			}).Should(BeEquivalentTo(5))
+			close(release)
+			wg.Wait()
+
+			Expect(fetchCount).To(BeEquivalentTo(1))
+		})
+	})
+
+	Context("when the cache is nil", func() {
+		It("always fetches", func() {
+			var nilCache *cache.Cache
+
+			nilCache.Fetch("key", fetcher("value"))
+			nilCache.Fetch("key", fetcher("value"))
+
+			Expect(fetchCount).To(BeEquivalentTo(2))
+		})
+	})
+})
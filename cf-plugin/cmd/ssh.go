@@ -127,6 +127,7 @@ func (c *secureShell) Connect(opts *options.SSHOptions) error {
 			ssh.Password(cred.Token),
 		},
 		HostKeyCallback: fingerprintCallback(opts, info.SSHEndpointFingerprint),
+		BannerCallback:  ssh.BannerDisplayStderr(),
 	}
 
 	secureClient, err := c.secureDialer.Dial("tcp", info.SSHEndpoint, clientConfig)
@@ -275,6 +275,7 @@ var _ = Describe("Diego SSH Plugin", func() {
 			Expect(config.Auth).NotTo(BeEmpty())
 			Expect(config.User).To(Equal("cf:app-guid/2"))
 			Expect(config.HostKeyCallback).NotTo(BeNil())
+			Expect(config.BannerCallback).NotTo(BeNil())
 		})
 
 		Context("when host key validation is enabled", func() {
@@ -0,0 +1,667 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/algorithms"
+	"github.com/cloudfoundry-incubator/diego-ssh/audit"
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	"github.com/pivotal-golang/lager"
+	"github.com/tedsuo/ifrit"
+	"golang.org/x/crypto/ssh"
+)
+
+var configFile = flag.String(
+	"config",
+	"",
+	"JSON file of flag values and per-realm policies; reloaded on SIGHUP",
+)
+
+var validate = flag.Bool(
+	"validate",
+	false,
+	"Validate the configuration and exit",
+)
+
+const configPoliciesKey = "policies"
+
+// config is a complete set of proxy settings. Every load builds a new config
+// so that one that fails to load or validate never replaces the one in use.
+type config struct {
+	address                  string
+	hostKey                  string
+	hostKeyFiles             string
+	diegoAPIURL              string
+	ccAPIURL                 string
+	targetInventory          string
+	trustedHopKeys           string
+	nextHops                 string
+	communicationTimeout     time.Duration
+	cacheTTL                 time.Duration
+	cacheMaxEntries          int
+	upstreamRetries          int
+	upstreamRetryBackoff     time.Duration
+	upstreamFailureThreshold int
+	upstreamResetTimeout     time.Duration
+	cryptoProfile            string
+	ciphers                  string
+	kexAlgorithms            string
+	macs                     string
+	targetDialTimeout        time.Duration
+	targetHandshakeTimeout   time.Duration
+	targetDialRetries        int
+	targetDialRetryBackoff   time.Duration
+	globalRateLimit          int64
+	idleTimeout              time.Duration
+	maxSessionDuration       time.Duration
+	disconnectWarning        time.Duration
+	recordingDir             string
+	auditLog                 string
+	auditSyslog              bool
+	auditDropsonde           bool
+	traceLog                 bool
+	traceFile                string
+	zipkinURL                string
+	sessionLogSpaces         string
+	maxUserSessions          int
+	maxInstanceSessions      int
+	policyFile               string
+	metricsAddress           string
+	adminAddress             string
+	adminCredentials         string
+	maintenanceMessage       string
+	maintenanceDrain         time.Duration
+	enableCFAuth             bool
+	enableDiegoAuth          bool
+
+	// policies are declared in the config file and take precedence over the
+	// policies loaded from the policy file.
+	policies map[string]*proxy.Policy
+}
+
+// newConfig registers the settings of a new config with their defaults on
+// the flag set.
+func newConfig(flags *flag.FlagSet) *config {
+	c := &config{policies: map[string]*proxy.Policy{}}
+
+	flags.StringVar(
+		&c.address,
+		"address",
+		":2222",
+		"listen address for ssh proxy",
+	)
+
+	flags.StringVar(
+		&c.hostKey,
+		"hostKey",
+		"",
+		"PEM encoded RSA host key",
+	)
+
+	flags.StringVar(
+		&c.hostKeyFiles,
+		"hostKeyFiles",
+		"",
+		"Comma separated files containing PEM encoded host keys; reloaded on SIGHUP",
+	)
+
+	flags.StringVar(
+		&c.diegoAPIURL,
+		"diegoAPIURL",
+		"",
+		"URL of diego API",
+	)
+
+	flags.StringVar(
+		&c.ccAPIURL,
+		"ccAPIURL",
+		"",
+		"URL of Cloud Controller API",
+	)
+
+	flags.StringVar(
+		&c.targetInventory,
+		"targetInventory",
+		"",
+		"Path to a JSON inventory of static targets used instead of the diego API",
+	)
+
+	flags.StringVar(
+		&c.trustedHopKeys,
+		"trustedHopKeys",
+		"",
+		"Path to an authorized_keys file of the hop keys of proxies allowed to forward sessions to this proxy",
+	)
+
+	flags.StringVar(
+		&c.nextHops,
+		"nextHops",
+		"",
+		"Path to a JSON list of cell patterns and the next hop proxies that reach the cells they match",
+	)
+
+	flags.DurationVar(
+		&c.communicationTimeout,
+		"communicationTimeout",
+		10*time.Second,
+		"Timeout applied to all HTTP requests.",
+	)
+
+	flags.DurationVar(
+		&c.cacheTTL,
+		"cacheTTL",
+		5*time.Second,
+		"How long Cloud Controller authorizations and diego routing information are cached (0 disables caching)",
+	)
+
+	flags.IntVar(
+		&c.cacheMaxEntries,
+		"cacheMaxEntries",
+		1024,
+		"Maximum number of entries held by each lookup cache",
+	)
+
+	flags.IntVar(
+		&c.upstreamRetries,
+		"upstreamRetries",
+		2,
+		"Number of times idempotent requests to the diego and Cloud Controller APIs are retried",
+	)
+
+	flags.DurationVar(
+		&c.upstreamRetryBackoff,
+		"upstreamRetryBackoff",
+		100*time.Millisecond,
+		"Initial backoff between retries, doubled after each attempt",
+	)
+
+	flags.IntVar(
+		&c.upstreamFailureThreshold,
+		"upstreamFailureThreshold",
+		5,
+		"Consecutive failures that open the circuit breaker of an upstream API",
+	)
+
+	flags.DurationVar(
+		&c.upstreamResetTimeout,
+		"upstreamResetTimeout",
+		10*time.Second,
+		"How long an open circuit breaker fails fast before probing the upstream API again",
+	)
+
+	flags.StringVar(
+		&c.cryptoProfile,
+		"cryptoProfile",
+		"",
+		"Named set of allowed ciphers, key exchanges and MACs: modern, fips or compatible (library defaults when empty)",
+	)
+
+	flags.StringVar(
+		&c.ciphers,
+		"ciphers",
+		"",
+		"Comma separated ciphers in order of preference; overrides the profile",
+	)
+
+	flags.StringVar(
+		&c.kexAlgorithms,
+		"kexAlgorithms",
+		"",
+		"Comma separated key exchange algorithms in order of preference; overrides the profile",
+	)
+
+	flags.StringVar(
+		&c.macs,
+		"macs",
+		"",
+		"Comma separated MAC algorithms in order of preference; overrides the profile",
+	)
+
+	flags.DurationVar(
+		&c.targetDialTimeout,
+		"targetDialTimeout",
+		5*time.Second,
+		"Timeout for establishing the TCP connection to a target",
+	)
+
+	flags.DurationVar(
+		&c.targetHandshakeTimeout,
+		"targetHandshakeTimeout",
+		10*time.Second,
+		"Timeout for the SSH handshake with a target",
+	)
+
+	flags.IntVar(
+		&c.targetDialRetries,
+		"targetDialRetries",
+		2,
+		"Number of times a target connection that timed out or was refused is retried",
+	)
+
+	flags.DurationVar(
+		&c.targetDialRetryBackoff,
+		"targetDialRetryBackoff",
+		250*time.Millisecond,
+		"Delay before the first target connection retry; doubled for each further retry",
+	)
+
+	flags.Int64Var(
+		&c.globalRateLimit,
+		"globalRateLimit",
+		0,
+		"Bytes per second of channel data allowed across all connections (0 is unlimited)",
+	)
+
+	flags.DurationVar(
+		&c.idleTimeout,
+		"idleTimeout",
+		0,
+		"Close sessions without channel data in either direction for this long (0 disables)",
+	)
+
+	flags.DurationVar(
+		&c.maxSessionDuration,
+		"maxSessionDuration",
+		0,
+		"Close sessions that have been open for this long (0 disables)",
+	)
+
+	flags.DurationVar(
+		&c.disconnectWarning,
+		"disconnectWarning",
+		time.Minute,
+		"How long before a timeout closes a session that pty sessions are warned",
+	)
+
+	flags.StringVar(
+		&c.recordingDir,
+		"recordingDir",
+		"",
+		"Directory in which interactive (pty) sessions are recorded in asciicast v2 format",
+	)
+
+	flags.StringVar(
+		&c.auditLog,
+		"auditLog",
+		"",
+		"Path of a file to which audit events are appended as JSON lines",
+	)
+
+	flags.BoolVar(
+		&c.auditSyslog,
+		"auditSyslog",
+		false,
+		"Send audit events to the local syslog daemon",
+	)
+
+	flags.BoolVar(
+		&c.auditDropsonde,
+		"auditDropsonde",
+		false,
+		"Send audit events to the firehose as events of the proxy",
+	)
+
+	flags.BoolVar(
+		&c.traceLog,
+		"traceLog",
+		false,
+		"Log the spans of each traced login",
+	)
+
+	flags.StringVar(
+		&c.traceFile,
+		"traceFile",
+		"",
+		"Path of a file to which the spans of each login are appended as JSON lines",
+	)
+
+	flags.StringVar(
+		&c.zipkinURL,
+		"zipkinURL",
+		"",
+		"URL of a Zipkin v2 span collector, e.g. http://zipkin:9411/api/v2/spans",
+	)
+
+	flags.StringVar(
+		&c.sessionLogSpaces,
+		"sessionLogSpaces",
+		"",
+		"Comma separated guids of spaces whose apps receive session end and command logs",
+	)
+
+	flags.IntVar(
+		&c.maxUserSessions,
+		"maxUserSessions",
+		0,
+		"Maximum concurrent sessions of one user (0 is unlimited)",
+	)
+
+	flags.IntVar(
+		&c.maxInstanceSessions,
+		"maxInstanceSessions",
+		0,
+		"Maximum concurrent sessions to one app instance (0 is unlimited)",
+	)
+
+	flags.StringVar(
+		&c.policyFile,
+		"policyFile",
+		"",
+		"Path to a JSON file of channel and request policies keyed by authentication realm",
+	)
+
+	flags.StringVar(
+		&c.metricsAddress,
+		"metricsAddress",
+		"",
+		"Listen address for Prometheus metrics (disabled when empty)",
+	)
+
+	flags.StringVar(
+		&c.adminAddress,
+		"adminAddress",
+		"",
+		"Listen address for the session admin API (disabled when empty)",
+	)
+
+	flags.StringVar(
+		&c.adminCredentials,
+		"adminCredentials",
+		"",
+		"Basic authentication credentials for the session admin API in the form user:password",
+	)
+
+	flags.StringVar(
+		&c.maintenanceMessage,
+		"maintenanceMessage",
+		proxy.DefaultMaintenanceMessage,
+		"Message shown to clients while the proxy is in maintenance",
+	)
+
+	flags.DurationVar(
+		&c.maintenanceDrain,
+		"maintenanceDrain",
+		0,
+		"How long sessions in progress may continue after entering maintenance on SIGUSR1 (0 lets them run to completion)",
+	)
+
+	flags.BoolVar(
+		&c.enableCFAuth,
+		"enableCFAuth",
+		false,
+		"Allow authentication with cf",
+	)
+
+	flags.BoolVar(
+		&c.enableDiegoAuth,
+		"enableDiegoAuth",
+		false,
+		"Allow authentication with diego",
+	)
+
+	return c
+}
+
+// commandLineSettings returns the flags given on the command line.
+func commandLineSettings() map[string]string {
+	settings := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		settings[f.Name] = f.Value.String()
+	})
+	return settings
+}
+
+// loadConfig builds a config from the config file, if any, and the settings
+// given on the command line, which take precedence. Settings missing from
+// both have their defaults so that removing a setting from the file takes
+// effect on reload.
+func loadConfig(path string, commandLine map[string]string) (*config, error) {
+	flags := flag.NewFlagSet("ssh-proxy", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	c := newConfig(flags)
+
+	if path != "" {
+		settings, policies, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, value := range settings {
+			if flags.Lookup(name) == nil {
+				return nil, fmt.Errorf("unknown setting: %s", name)
+			}
+
+			err := flags.Set(name, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %s", name, err)
+			}
+		}
+
+		c.policies = policies
+	}
+
+	for name, value := range commandLine {
+		// Flags such as -config and -logLevel are not settings.
+		if flags.Lookup(name) == nil {
+			continue
+		}
+
+		err := flags.Set(name, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %s", name, err)
+		}
+	}
+
+	return c, nil
+}
+
+// readConfigFile returns the settings of a JSON config file as flag values
+// and the policies it declares.
+func readConfigFile(path string) (map[string]string, map[string]*proxy.Policy, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
+
+	values := map[string]interface{}{}
+	err = decoder.Decode(&values)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	policies := map[string]*proxy.Policy{}
+	if rawPolicies, ok := values[configPoliciesKey]; ok {
+		encoded, err := json.Marshal(rawPolicies)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = json.Unmarshal(encoded, &policies)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid policies: %s", err)
+		}
+		delete(values, configPoliciesKey)
+	}
+
+	settings := map[string]string{}
+	for name, value := range values {
+		setting, err := settingValue(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid value for %s: %s", name, err)
+		}
+		settings[name] = setting
+	}
+
+	return settings, policies, nil
+}
+
+func settingValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case json.Number:
+		return v.String(), nil
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", errors.New("lists may only contain strings")
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", errors.New("unsupported value type")
+	}
+}
+
+// liveConfig holds the config in use. The reloader replaces it once a new
+// config has been validated.
+type liveConfig struct {
+	value atomic.Value
+}
+
+func newLiveConfig(c *config) *liveConfig {
+	live := &liveConfig{}
+	live.Store(c)
+	return live
+}
+
+func (l *liveConfig) Load() *config {
+	return l.value.Load().(*config)
+}
+
+func (l *liveConfig) Store(c *config) {
+	l.value.Store(c)
+}
+
+type proxySettings struct {
+	serverConfig *ssh.ServerConfig
+	hostKeys     []ssh.Signer
+	timeouts     proxy.Timeouts
+	dialConfig   proxy.DialConfig
+}
+
+// configureSettings validates the config by building everything new
+// connections use from it.
+func configureSettings(logger lager.Logger, cfg *config, deps *dependencies, auditor *audit.Auditor) (*proxySettings, error) {
+	algorithmProfile, err := algorithms.NewProfile(cfg.cryptoProfile, cfg.ciphers, cfg.kexAlgorithms, cfg.macs)
+	if err != nil {
+		logger.Error("invalid-algorithm-profile", err)
+		return nil, err
+	}
+
+	proxyConfig, err := configure(logger, cfg, deps, auditor)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithmProfile.Apply(&proxyConfig.Config)
+
+	if cfg.hostKey == "" && cfg.hostKeyFiles == "" {
+		err := errors.New("hostKey is required")
+		logger.Error("host-key-required", err)
+		return nil, err
+	}
+
+	hostKeys, err := loadHostKeys(logger, cfg)
+	if err != nil {
+		logger.Error("failed-to-parse-host-key", err)
+		return nil, err
+	}
+
+	return &proxySettings{
+		serverConfig: withHostKeys(proxyConfig, hostKeys),
+		hostKeys:     hostKeys,
+		timeouts: proxy.Timeouts{
+			IdleTimeout: cfg.idleTimeout,
+			MaxDuration: cfg.maxSessionDuration,
+			Warning:     cfg.disconnectWarning,
+		},
+		dialConfig: proxy.DialConfig{
+			DialTimeout:      cfg.targetDialTimeout,
+			HandshakeTimeout: cfg.targetHandshakeTimeout,
+			Retries:          cfg.targetDialRetries,
+			RetryBackoff:     cfg.targetDialRetryBackoff,
+			Algorithms:       algorithmProfile,
+		},
+	}, nil
+}
+
+func parseAdminCredentials(cfg *config) (string, string, error) {
+	if cfg.adminAddress == "" {
+		return "", "", nil
+	}
+
+	credentials := strings.SplitN(cfg.adminCredentials, ":", 2)
+	if len(credentials) != 2 || credentials[0] == "" || credentials[1] == "" {
+		return "", "", errors.New("adminCredentials must be of the form user:password")
+	}
+
+	return credentials[0], credentials[1], nil
+}
+
+// reloader rereads the config file and host keys on SIGHUP and applies the
+// result to new connections. Listener addresses, admin credentials, auditing,
+// recording, tracing, the global rate limit, and the HTTP timeout, caches and
+// circuit breakers of the upstream APIs are only read at startup. A
+// configuration that fails to load leaves the current settings in place.
+func reloader(
+	logger lager.Logger,
+	sshProxy *proxy.Proxy,
+	auditor *audit.Auditor,
+	deps *dependencies,
+	live *liveConfig,
+	commandLine map[string]string,
+) ifrit.Runner {
+	logger = logger.Session("reloader")
+
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
+		close(ready)
+
+		for {
+			select {
+			case <-hup:
+				cfg, err := loadConfig(*configFile, commandLine)
+				if err != nil {
+					logger.Error("reload-failed", err)
+					continue
+				}
+
+				settings, err := configureSettings(logger, cfg, deps, auditor)
+				if err != nil {
+					logger.Error("reload-failed", err)
+					continue
+				}
+
+				live.Store(cfg)
+				sshProxy.Reconfigure(settings.serverConfig, settings.hostKeys, settings.timeouts, settings.dialConfig)
+				logger.Info("reloaded", lager.Data{"host-keys": len(settings.hostKeys)})
+			case <-signals:
+				return nil
+			}
+		}
+	})
+}
@@ -3,92 +3,122 @@ package main
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"net/url"
 	"os"
-	"time"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/cloudfoundry-incubator/cf-debug-server"
 	"github.com/cloudfoundry-incubator/cf-lager"
 	"github.com/cloudfoundry-incubator/cf_http"
+	"github.com/cloudfoundry-incubator/diego-ssh/admin"
+	"github.com/cloudfoundry-incubator/diego-ssh/audit"
 	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
+	"github.com/cloudfoundry-incubator/diego-ssh/bandwidth"
+	"github.com/cloudfoundry-incubator/diego-ssh/cache"
+	"github.com/cloudfoundry-incubator/diego-ssh/helpers"
+	"github.com/cloudfoundry-incubator/diego-ssh/keys"
 	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	"github.com/cloudfoundry-incubator/diego-ssh/recording"
 	"github.com/cloudfoundry-incubator/diego-ssh/server"
+	"github.com/cloudfoundry-incubator/diego-ssh/stats"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
+	"github.com/cloudfoundry-incubator/diego-ssh/upstream"
 	"github.com/cloudfoundry-incubator/receptor"
 	"github.com/cloudfoundry/dropsonde"
 	"github.com/pivotal-golang/lager"
 	"github.com/tedsuo/ifrit"
 	"github.com/tedsuo/ifrit/grouper"
+	"github.com/tedsuo/ifrit/http_server"
 	"github.com/tedsuo/ifrit/sigmon"
 	"golang.org/x/crypto/ssh"
 )
 
-var address = flag.String(
-	"address",
-	":2222",
-	"listen address for ssh proxy",
-)
-
-var hostKey = flag.String(
-	"hostKey",
-	"",
-	"PEM encoded RSA host key",
-)
-
-var diegoAPIURL = flag.String(
-	"diegoAPIURL",
-	"",
-	"URL of diego API",
-)
-
-var ccAPIURL = flag.String(
-	"ccAPIURL",
-	"",
-	"URL of Cloud Controller API",
-)
-
-var communicationTimeout = flag.Duration(
-	"communicationTimeout",
-	10*time.Second,
-	"Timeout applied to all HTTP requests.",
-)
-
-var enableCFAuth = flag.Bool(
-	"enableCFAuth",
-	false,
-	"Allow authentication with cf",
-)
-
-var enableDiegoAuth = flag.Bool(
-	"enableDiegoAuth",
-	false,
-	"Allow authentication with diego",
-)
-
 const (
 	dropsondeDestination = "localhost:3457"
 	dropsondeOrigin      = "ssh-proxy"
 )
 
 func main() {
+	cfg := newConfig(flag.CommandLine)
 	cf_debug_server.AddFlags(flag.CommandLine)
 	cf_lager.AddFlags(flag.CommandLine)
 	flag.Parse()
 
 	logger, reconfigurableSink := cf_lager.New("ssh-proxy")
+	logger = helpers.NewRedactingLogger(logger)
+
+	commandLine := commandLineSettings()
+	if *configFile != "" {
+		var err error
+		cfg, err = loadConfig(*configFile, commandLine)
+		if err != nil {
+			logger.Fatal("failed-to-load-config-file", err)
+		}
+	}
+
+	deps := newDependencies(logger, cfg)
+
+	adminUser, adminPassword, err := parseAdminCredentials(cfg)
+	if err != nil {
+		logger.Fatal("admin-credentials-required", err)
+	}
+
+	if *validate {
+		_, err := configureSettings(logger, cfg, deps, nil)
+		if err != nil {
+			logger.Error("configure-failed", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("configuration is valid")
+		os.Exit(0)
+	}
 
 	initializeDropsonde(logger)
 
-	proxyConfig, err := configure(logger)
+	auditor := configureAuditor(logger, cfg)
+
+	settings, err := configureSettings(logger, cfg, deps, auditor)
 	if err != nil {
 		logger.Error("configure-failed", err)
 		os.Exit(1)
 	}
 
-	sshProxy := proxy.New(logger, proxyConfig)
-	server := server.NewServer(logger, *address, sshProxy)
+	live := newLiveConfig(cfg)
+
+	var recordingSink recording.Sink
+	if cfg.recordingDir != "" {
+		recordingSink = recording.NewFileSink(cfg.recordingDir)
+	}
+
+	interceptors := []proxy.Interceptor{}
+	if cfg.globalRateLimit > 0 {
+		bucket := bandwidth.NewBucket(cfg.globalRateLimit, cfg.globalRateLimit)
+		interceptors = append(interceptors, proxy.NewBandwidthInterceptor(nil, bucket))
+	}
+
+	tracer := configureTracer(logger, cfg)
+
+	sshProxy := proxy.New(logger, settings.serverConfig, recordingSink, auditor, tracer, settings.timeouts, settings.dialConfig, interceptors...)
+	sshProxy.SetServerConfig(settings.serverConfig, settings.hostKeys)
+	server := server.NewServer(logger, cfg.address, sshProxy)
 
 	members := grouper.Members{
 		{"ssh-proxy", server},
+		{"reloader", reloader(logger, sshProxy, auditor, deps, live, commandLine)},
+		{"maintenance-switch", maintenanceSwitch(logger, sshProxy, live)},
+	}
+
+	if cfg.metricsAddress != "" {
+		members = append(members, grouper.Member{"metrics-server", http_server.New(cfg.metricsAddress, stats.NewHandler(stats.Default, "ssh_proxy_"))})
+	}
+
+	if cfg.adminAddress != "" {
+		adminHandler := admin.New(logger, sshProxy, adminUser, adminPassword)
+		members = append(members, grouper.Member{"admin-server", http_server.New(cfg.adminAddress, adminHandler)})
 	}
 
 	if dbgAddr := cf_debug_server.DebugAddress(flag.CommandLine); dbgAddr != "" {
@@ -119,22 +149,109 @@ func initializeDropsonde(logger lager.Logger) {
 	}
 }
 
-func configure(logger lager.Logger) (*ssh.ServerConfig, error) {
-	cf_http.Initialize(*communicationTimeout)
+func configureAuditor(logger lager.Logger, cfg *config) *audit.Auditor {
+	sinks := []audit.Sink{}
+
+	if cfg.auditLog != "" {
+		fileSink, err := audit.NewFileSink(cfg.auditLog)
+		if err != nil {
+			logger.Fatal("failed-to-open-audit-log", err)
+		}
+		sinks = append(sinks, fileSink)
+	}
+
+	if cfg.auditSyslog {
+		syslogSink, err := audit.NewSyslogSink(dropsondeOrigin)
+		if err != nil {
+			logger.Fatal("failed-to-connect-to-syslog", err)
+		}
+		sinks = append(sinks, syslogSink)
+	}
+
+	if cfg.auditDropsonde {
+		sinks = append(sinks, audit.NewDropsondeSink(dropsonde.AutowiredEmitter()))
+	}
+
+	if len(sinks) == 0 {
+		return nil
+	}
+
+	return audit.NewAuditor(logger, audit.NewMultiSink(sinks...))
+}
+
+func configureTracer(logger lager.Logger, cfg *config) *tracing.Tracer {
+	exporters := []tracing.Exporter{}
 
-	if *diegoAPIURL == "" {
+	if cfg.traceLog {
+		exporters = append(exporters, tracing.NewLogExporter(logger))
+	}
+
+	if cfg.traceFile != "" {
+		fileExporter, err := tracing.NewFileExporter(cfg.traceFile)
+		if err != nil {
+			logger.Fatal("failed-to-open-trace-file", err)
+		}
+		exporters = append(exporters, fileExporter)
+	}
+
+	if cfg.zipkinURL != "" {
+		exporters = append(exporters, tracing.NewZipkinExporter(logger, cf_http.NewClient(), cfg.zipkinURL))
+	}
+
+	if len(exporters) == 0 {
+		return nil
+	}
+
+	return tracing.NewTracer(logger, dropsondeOrigin, exporters...)
+}
+
+// dependencies are built once at startup and shared by every config so that
+// reloading keeps the cached routes and the state of the circuit breakers.
+type dependencies struct {
+	routingCache     *cache.Cache
+	accessCache      *cache.Cache
+	receptorUpstream *upstream.Upstream
+	ccUpstream       *upstream.Upstream
+}
+
+func newDependencies(logger lager.Logger, cfg *config) *dependencies {
+	cf_http.Initialize(cfg.communicationTimeout)
+
+	deps := &dependencies{}
+	if cfg.cacheTTL > 0 {
+		deps.routingCache = cache.New("DiegoRouting", cfg.cacheMaxEntries, cfg.cacheTTL)
+		deps.accessCache = cache.New("CCAccess", cfg.cacheMaxEntries, cfg.cacheTTL)
+	}
+
+	upstreamConfig := upstream.Config{
+		Retries:          cfg.upstreamRetries,
+		RetryBackoff:     cfg.upstreamRetryBackoff,
+		FailureThreshold: cfg.upstreamFailureThreshold,
+		ResetTimeout:     cfg.upstreamResetTimeout,
+	}
+	deps.receptorUpstream = upstream.New(logger, "Receptor", upstreamConfig)
+	deps.ccUpstream = upstream.New(logger, "CloudController", upstreamConfig)
+
+	return deps
+}
+
+func configure(logger lager.Logger, cfg *config, deps *dependencies, auditor *audit.Auditor) (*ssh.ServerConfig, error) {
+	if cfg.diegoAPIURL == "" && cfg.targetInventory == "" {
 		err := errors.New("diegoAPIURL is required")
-		logger.Fatal("diego-api-url-required", err)
+		logger.Error("diego-api-url-required", err)
+		return nil, err
 	}
 
-	url, err := url.Parse(*diegoAPIURL)
+	url, err := url.Parse(cfg.diegoAPIURL)
 	if err != nil {
-		logger.Fatal("failed-to-parse-diego-api-url", err)
+		logger.Error("failed-to-parse-diego-api-url", err)
+		return nil, err
 	}
 
-	_, err = url.Parse(*ccAPIURL)
-	if *ccAPIURL != "" && err != nil {
-		logger.Fatal("failed-to-parse-cc-api-url", err)
+	_, err = url.Parse(cfg.ccAPIURL)
+	if cfg.ccAPIURL != "" && err != nil {
+		logger.Error("failed-to-parse-cc-api-url", err)
+		return nil, err
 	}
 
 	var diegoCreds string
@@ -142,43 +259,158 @@ func configure(logger lager.Logger) (*ssh.ServerConfig, error) {
 		diegoCreds = url.User.String()
 	}
 
-	receptorClient := receptor.NewClient(*diegoAPIURL)
+	var targetResolver authenticators.TargetResolver
+	if cfg.targetInventory != "" {
+		targetResolver, err = authenticators.NewFileTargetResolver(cfg.targetInventory)
+		if err != nil {
+			logger.Error("failed-to-load-target-inventory", err)
+			return nil, err
+		}
+	} else {
+		var nextHops authenticators.NextHops
+		if cfg.nextHops != "" {
+			nextHops, err = authenticators.LoadNextHops(cfg.nextHops)
+			if err != nil {
+				logger.Error("failed-to-load-next-hops", err)
+				return nil, err
+			}
+		}
+
+		receptorClient := receptor.NewClient(cfg.diegoAPIURL)
+		targetResolver = authenticators.NewUpstreamTargetResolver(
+			authenticators.NewReceptorTargetResolver(receptorClient, deps.routingCache, nextHops),
+			deps.receptorUpstream,
+		)
+	}
 
 	authenticatorMap := map[string]authenticators.PasswordAuthenticator{}
 
-	if *enableDiegoAuth {
-		diegoAuthenticator := authenticators.NewDiegoProxyAuthenticator(logger, receptorClient, []byte(diegoCreds))
+	if cfg.enableDiegoAuth {
+		diegoAuthenticator := authenticators.NewDiegoProxyAuthenticator(logger, targetResolver, []byte(diegoCreds))
 		authenticatorMap[diegoAuthenticator.Realm()] = diegoAuthenticator
 	}
 
-	if *ccAPIURL != "" && *enableCFAuth {
+	if cfg.ccAPIURL != "" && cfg.enableCFAuth {
 		ccClient := cf_http.NewClient()
-		cfAuthenticator := authenticators.NewCFAuthenticator(logger, ccClient, *ccAPIURL, receptorClient)
+		ccClient.Transport = upstream.NewRoundTripper(deps.ccUpstream, ccClient.Transport)
+		cfAuthenticator := authenticators.NewCFAuthenticator(logger, ccClient, cfg.ccAPIURL, targetResolver, deps.accessCache, splitList(cfg.sessionLogSpaces))
 		authenticatorMap[cfAuthenticator.Realm()] = cfAuthenticator
 	}
 
+	if cfg.maxUserSessions > 0 || cfg.maxInstanceSessions > 0 {
+		for realm, authenticator := range authenticatorMap {
+			authenticatorMap[realm] = authenticators.NewSessionLimitAuthenticator(authenticator, cfg.maxUserSessions, cfg.maxInstanceSessions)
+		}
+	}
+
+	policies := map[string]*proxy.Policy{}
+	if cfg.policyFile != "" {
+		policies, err = proxy.LoadPolicies(cfg.policyFile)
+		if err != nil {
+			logger.Error("failed-to-load-policies", err)
+			return nil, err
+		}
+	}
+
+	for realm, policy := range cfg.policies {
+		policies[realm] = policy
+	}
+
+	for realm, policy := range policies {
+		if authenticatorMap[realm] != nil {
+			authenticatorMap[realm] = authenticators.NewPolicyAuthenticator(authenticatorMap[realm], policy)
+		}
+	}
+
 	authenticator := authenticators.NewCompositeAuthenticator(authenticatorMap)
 
 	sshConfig := &ssh.ServerConfig{
-		PasswordCallback: authenticator.Authenticate,
+		PasswordCallback: auditor.PasswordCallback(authenticator.Authenticate),
 		AuthLogCallback: func(cmd ssh.ConnMetadata, method string, err error) {
 			logger.Error("authentication-failed", err, lager.Data{"user": cmd.User()})
 		},
 	}
 
-	if *hostKey == "" {
-		err := errors.New("hostKey is required")
-		logger.Fatal("host-key-required", err)
+	if cfg.trustedHopKeys != "" {
+		hopKeys, err := keys.LoadAuthorizedKeys(cfg.trustedHopKeys)
+		if err != nil {
+			logger.Error("failed-to-load-trusted-hop-keys", err)
+			return nil, err
+		}
+
+		hopAuthenticator := authenticators.NewHopAuthenticator(hopKeys)
+		sshConfig.PublicKeyCallback = auditor.PublicKeyCallback(hopAuthenticator.Authenticate)
+	}
+
+	return sshConfig, nil
+}
+
+func loadHostKeys(logger lager.Logger, cfg *config) ([]ssh.Signer, error) {
+	hostKeys := []ssh.Signer{}
+
+	if cfg.hostKey != "" {
+		key, err := parsePrivateKey(logger, cfg.hostKey)
+		if err != nil {
+			return nil, err
+		}
+		hostKeys = append(hostKeys, key)
 	}
 
-	key, err := parsePrivateKey(logger, *hostKey)
+	fileKeys, err := keys.LoadHostKeys(splitList(cfg.hostKeyFiles)...)
 	if err != nil {
-		logger.Fatal("failed-to-parse-host-key", err)
+		return nil, err
 	}
 
-	sshConfig.AddHostKey(key)
+	return append(hostKeys, fileKeys...), nil
+}
 
-	return sshConfig, err
+// withHostKeys returns a copy of the config that serves the host keys.
+// AddHostKey replaces keys of the same algorithm, so the first key of each
+// algorithm is the one used in handshakes; the others are only advertised.
+func withHostKeys(config *ssh.ServerConfig, hostKeys []ssh.Signer) *ssh.ServerConfig {
+	keyedConfig := *config
+	for i := len(hostKeys) - 1; i >= 0; i-- {
+		keyedConfig.AddHostKey(hostKeys[i])
+	}
+	return &keyedConfig
+}
+
+// maintenanceSwitch enters maintenance on SIGUSR1 and leaves it on SIGUSR2.
+func maintenanceSwitch(logger lager.Logger, sshProxy *proxy.Proxy, live *liveConfig) ifrit.Runner {
+	logger = logger.Session("maintenance-switch")
+
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		toggle := make(chan os.Signal, 1)
+		signal.Notify(toggle, syscall.SIGUSR1, syscall.SIGUSR2)
+		defer signal.Stop(toggle)
+
+		close(ready)
+
+		for {
+			select {
+			case sig := <-toggle:
+				if sig == syscall.SIGUSR1 {
+					cfg := live.Load()
+					sshProxy.EnterMaintenance(cfg.maintenanceMessage, cfg.maintenanceDrain)
+				} else {
+					sshProxy.ExitMaintenance()
+				}
+				logger.Info("toggled", lager.Data{"maintenance": sshProxy.Maintenance().Enabled})
+			case <-signals:
+				return nil
+			}
+		}
+	})
+}
+
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 func parsePrivateKey(logger lager.Logger, encodedKey string) (ssh.Signer, error) {
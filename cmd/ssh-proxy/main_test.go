@@ -4,13 +4,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
 	"github.com/cloudfoundry-incubator/diego-ssh/cmd/ssh-proxy/testrunner"
 	"github.com/cloudfoundry-incubator/diego-ssh/helpers"
+	"github.com/cloudfoundry-incubator/diego-ssh/keys"
 	"github.com/cloudfoundry-incubator/diego-ssh/routes"
 	"github.com/cloudfoundry-incubator/receptor"
 	"github.com/tedsuo/ifrit"
@@ -33,17 +39,21 @@ var _ = Describe("SSH proxy", func() {
 
 		address            string
 		hostKey            string
+		hostKeyFiles       string
 		hostKeyFingerprint string
 		diegoAPIURL        string
 		ccAPIURL           string
+		targetInventory    string
 		enableCFAuth       bool
 		enableDiegoAuth    bool
+		configFile         string
 	)
 
 	BeforeEach(func() {
 		fakeReceptor = ghttp.NewServer()
 
 		hostKey = hostKeyPem
+		hostKeyFiles = ""
 
 		privateKey, err := ssh.ParsePrivateKey([]byte(hostKey))
 		Expect(err).NotTo(HaveOccurred())
@@ -53,18 +63,23 @@ var _ = Describe("SSH proxy", func() {
 		diegoAPIURL = fakeReceptor.URL()
 
 		ccAPIURL = ""
+		targetInventory = ""
 		enableCFAuth = true
 		enableDiegoAuth = true
+		configFile = ""
 	})
 
 	JustBeforeEach(func() {
 		args := testrunner.Args{
 			Address:         address,
 			HostKey:         hostKey,
+			HostKeyFiles:    hostKeyFiles,
 			DiegoAPIURL:     diegoAPIURL,
 			CCAPIURL:        ccAPIURL,
+			TargetInventory: targetInventory,
 			EnableCFAuth:    enableCFAuth,
 			EnableDiegoAuth: enableDiegoAuth,
+			ConfigFile:      configFile,
 		}
 
 		runner = testrunner.New(sshProxyPath, args)
@@ -120,6 +135,17 @@ var _ = Describe("SSH proxy", func() {
 			})
 		})
 
+		Context("when the target inventory cannot be loaded", func() {
+			BeforeEach(func() {
+				targetInventory = "/does/not/exist.json"
+			})
+
+			It("reports the problem and terminates", func() {
+				Expect(runner).To(gbytes.Say("failed-to-load-target-inventory"))
+				Expect(runner).NotTo(gexec.Exit(0))
+			})
+		})
+
 		Context("when the cc URL cannot be parsed", func() {
 			BeforeEach(func() {
 				ccAPIURL = ":://goober-swallow#yuck"
@@ -130,6 +156,69 @@ var _ = Describe("SSH proxy", func() {
 				Expect(runner).NotTo(gexec.Exit(0))
 			})
 		})
+
+		Context("when the config file contains an unknown setting", func() {
+			BeforeEach(func() {
+				configFile = writeConfigFile(map[string]interface{}{"notASetting": true})
+			})
+
+			AfterEach(func() {
+				os.Remove(configFile)
+			})
+
+			It("reports the problem and terminates", func() {
+				Expect(runner).To(gbytes.Say("failed-to-load-config-file"))
+				Expect(runner).NotTo(gexec.Exit(0))
+			})
+		})
+	})
+
+	Describe("validation mode", func() {
+		var session *gexec.Session
+
+		AfterEach(func() {
+			os.Remove(configFile)
+		})
+
+		validateConfig := func(config map[string]interface{}) {
+			configFile = writeConfigFile(config)
+
+			var err error
+			session, err = gexec.Start(exec.Command(sshProxyPath, "-validate", "-config="+configFile), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Context("when the configuration is valid", func() {
+			BeforeEach(func() {
+				validateConfig(map[string]interface{}{
+					"hostKey":     hostKeyPem,
+					"diegoAPIURL": fakeReceptor.URL(),
+				})
+			})
+
+			It("reports success and exits", func() {
+				Eventually(session, 5*time.Second).Should(gexec.Exit(0))
+				Expect(session.Out).To(gbytes.Say("configuration is valid"))
+			})
+
+			It("does not start listening", func() {
+				Eventually(session, 5*time.Second).Should(gexec.Exit(0))
+				Expect(session).NotTo(gbytes.Say("ssh-proxy.started"))
+			})
+		})
+
+		Context("when the configuration is invalid", func() {
+			BeforeEach(func() {
+				validateConfig(map[string]interface{}{
+					"diegoAPIURL": fakeReceptor.URL(),
+				})
+			})
+
+			It("reports the problem and exits with an error", func() {
+				Eventually(session, 5*time.Second).Should(gexec.Exit(1))
+				Expect(session.Out).To(gbytes.Say("hostKey is required"))
+			})
+		})
 	})
 
 	Describe("execution", func() {
@@ -213,6 +302,44 @@ var _ = Describe("SSH proxy", func() {
 				proxyPublicHostKey := proxyHostKey.PublicKey()
 				Expect(proxyPublicHostKey.Marshal()).To(Equal(handshakeHostKey.Marshal()))
 			})
+
+			Context("when the host keys are loaded from files", func() {
+				var keyDir string
+
+				BeforeEach(func() {
+					var err error
+					keyDir, err = ioutil.TempDir("", "host-keys")
+					Expect(err).NotTo(HaveOccurred())
+
+					hostKeyFiles = filepath.Join(keyDir, "host-key")
+					Expect(ioutil.WriteFile(hostKeyFiles, []byte(hostKeyPem), 0600)).To(Succeed())
+					hostKey = ""
+				})
+
+				AfterEach(func() {
+					os.RemoveAll(keyDir)
+				})
+
+				It("reloads the host keys on SIGHUP", func() {
+					_, err := ssh.Dial("tcp", address, clientConfig)
+					Expect(err).To(HaveOccurred())
+
+					proxyHostKey, err := ssh.ParsePrivateKey([]byte(hostKeyPem))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(handshakeHostKey.Marshal()).To(Equal(proxyHostKey.PublicKey().Marshal()))
+
+					newHostKey, err := keys.RSAKeyPairFactory.NewKeyPair(1024)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ioutil.WriteFile(hostKeyFiles, []byte(newHostKey.PEMEncodedPrivateKey()), 0600)).To(Succeed())
+
+					process.Signal(syscall.SIGHUP)
+					Eventually(runner).Should(gbytes.Say("reloader.reloaded"))
+
+					_, err = ssh.Dial("tcp", address, clientConfig)
+					Expect(err).To(HaveOccurred())
+					Expect(handshakeHostKey.Marshal()).To(Equal(newHostKey.PublicKey().Marshal()))
+				})
+			})
 		})
 
 		Context("when the client uses the cf realm", func() {
@@ -449,6 +576,85 @@ var _ = Describe("SSH proxy", func() {
 					Expect(fakeReceptor.ReceivedRequests()).To(HaveLen(0))
 				})
 			})
+
+			Context("when the settings come from a config file", func() {
+				BeforeEach(func() {
+					configFile = writeConfigFile(map[string]interface{}{
+						"hostKey":         hostKeyPem,
+						"diegoAPIURL":     diegoAPIURL,
+						"enableDiegoAuth": false,
+					})
+
+					hostKey = ""
+					diegoAPIURL = ""
+					enableCFAuth = false
+					enableDiegoAuth = false
+				})
+
+				AfterEach(func() {
+					os.Remove(configFile)
+				})
+
+				It("applies the configuration", func() {
+					_, err := ssh.Dial("tcp", address, clientConfig)
+					Expect(err).To(MatchError(ContainSubstring("ssh: handshake failed")))
+				})
+
+				It("reloads the configuration on SIGHUP", func() {
+					writeConfig(configFile, map[string]interface{}{
+						"hostKey":         hostKeyPem,
+						"diegoAPIURL":     fakeReceptor.URL(),
+						"enableDiegoAuth": true,
+					})
+
+					process.Signal(syscall.SIGHUP)
+					Eventually(runner).Should(gbytes.Say("reloader.reloaded"))
+
+					client, err := ssh.Dial("tcp", address, clientConfig)
+					Expect(err).NotTo(HaveOccurred())
+					client.Close()
+				})
+
+				It("keeps the current configuration when the reload fails", func() {
+					Expect(ioutil.WriteFile(configFile, []byte("{"), 0600)).To(Succeed())
+
+					process.Signal(syscall.SIGHUP)
+					Eventually(runner).Should(gbytes.Say("reloader.reload-failed"))
+
+					_, err := ssh.Dial("tcp", address, clientConfig)
+					Expect(err).To(MatchError(ContainSubstring("ssh: handshake failed")))
+				})
+
+				It("applies none of the settings when one of them is invalid", func() {
+					writeConfig(configFile, map[string]interface{}{
+						"hostKey":           hostKeyPem,
+						"diegoAPIURL":       fakeReceptor.URL(),
+						"enableDiegoAuth":   true,
+						"targetDialRetries": "many",
+					})
+
+					process.Signal(syscall.SIGHUP)
+					Eventually(runner).Should(gbytes.Say("reloader.reload-failed"))
+
+					_, err := ssh.Dial("tcp", address, clientConfig)
+					Expect(err).To(MatchError(ContainSubstring("ssh: handshake failed")))
+				})
+			})
 		})
 	})
 })
+
+func writeConfigFile(config map[string]interface{}) string {
+	file, err := ioutil.TempFile("", "ssh-proxy-config")
+	Expect(err).NotTo(HaveOccurred())
+	Expect(file.Close()).To(Succeed())
+
+	writeConfig(file.Name(), config)
+	return file.Name()
+}
+
+func writeConfig(path string, config map[string]interface{}) {
+	payload, err := json.Marshal(config)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(ioutil.WriteFile(path, payload, 0600)).To(Succeed())
+}
@@ -2,7 +2,6 @@ package testrunner
 
 import (
 	"os/exec"
-	"strconv"
 	"time"
 
 	"github.com/tedsuo/ifrit/ginkgomon"
@@ -11,21 +10,43 @@ import (
 type Args struct {
 	Address         string
 	HostKey         string
+	HostKeyFiles    string
 	DiegoAPIURL     string
 	CCAPIURL        string
+	TargetInventory string
 	EnableCFAuth    bool
 	EnableDiegoAuth bool
+	ConfigFile      string
 }
 
+// ArgSlice omits empty and false arguments so that they do not override the
+// settings in a config file.
 func (args Args) ArgSlice() []string {
-	return []string{
-		"-address=" + args.Address,
-		"-hostKey=" + args.HostKey,
-		"-diegoAPIURL=" + args.DiegoAPIURL,
-		"-ccAPIURL=" + args.CCAPIURL,
-		"-enableCFAuth=" + strconv.FormatBool(args.EnableCFAuth),
-		"-enableDiegoAuth=" + strconv.FormatBool(args.EnableDiegoAuth),
+	argSlice := []string{}
+
+	stringArgs := []struct{ name, value string }{
+		{"address", args.Address},
+		{"hostKey", args.HostKey},
+		{"hostKeyFiles", args.HostKeyFiles},
+		{"diegoAPIURL", args.DiegoAPIURL},
+		{"ccAPIURL", args.CCAPIURL},
+		{"targetInventory", args.TargetInventory},
+		{"config", args.ConfigFile},
+	}
+	for _, arg := range stringArgs {
+		if arg.value != "" {
+			argSlice = append(argSlice, "-"+arg.name+"="+arg.value)
+		}
+	}
+
+	if args.EnableCFAuth {
+		argSlice = append(argSlice, "-enableCFAuth=true")
+	}
+	if args.EnableDiegoAuth {
+		argSlice = append(argSlice, "-enableDiegoAuth=true")
 	}
+
+	return argSlice
 }
 
 func New(binPath string, args Args) *ginkgomon.Runner {
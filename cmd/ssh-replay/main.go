@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/recording"
+)
+
+var speed = flag.Float64(
+	"speed",
+	1.0,
+	"Playback speed multiplier",
+)
+
+var maxIdle = flag.Duration(
+	"maxIdle",
+	2*time.Second,
+	"Longest pause between output events during playback (0 keeps the recorded pauses)",
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] recording.cast\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var input io.Reader = os.Stdin
+	if flag.Arg(0) != "-" {
+		file, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open recording: %s\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	_, err := recording.Replay(os.Stdout, input, recording.ReplayOptions{
+		Speed:   *speed,
+		MaxIdle: *maxIdle,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to replay recording: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -10,14 +10,20 @@ import (
 
 	"github.com/cloudfoundry-incubator/cf-debug-server"
 	"github.com/cloudfoundry-incubator/cf-lager"
+	"github.com/cloudfoundry-incubator/diego-ssh/algorithms"
 	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
 	"github.com/cloudfoundry-incubator/diego-ssh/daemon"
 	"github.com/cloudfoundry-incubator/diego-ssh/handlers"
+	"github.com/cloudfoundry-incubator/diego-ssh/helpers"
 	"github.com/cloudfoundry-incubator/diego-ssh/keys"
+	"github.com/cloudfoundry-incubator/diego-ssh/recording"
 	"github.com/cloudfoundry-incubator/diego-ssh/server"
+	"github.com/cloudfoundry-incubator/diego-ssh/stats"
+	"github.com/cloudfoundry/dropsonde"
 	"github.com/pivotal-golang/lager"
 	"github.com/tedsuo/ifrit"
 	"github.com/tedsuo/ifrit/grouper"
+	"github.com/tedsuo/ifrit/http_server"
 	"github.com/tedsuo/ifrit/sigmon"
 	"golang.org/x/crypto/ssh"
 )
@@ -46,6 +52,48 @@ var allowUnauthenticatedClients = flag.Bool(
 	"Allow access to unauthenticated clients",
 )
 
+var recordingDir = flag.String(
+	"recordingDir",
+	"",
+	"Directory in which interactive (pty) sessions are recorded in asciicast v2 format",
+)
+
+var metricsAddress = flag.String(
+	"metricsAddress",
+	"",
+	"Listen address for Prometheus metrics (disabled when empty)",
+)
+
+var dropsondeDestination = flag.String(
+	"dropsondeDestination",
+	"",
+	"Address of the metron agent that daemon metrics are emitted to (disabled when empty)",
+)
+
+var cryptoProfile = flag.String(
+	"cryptoProfile",
+	"",
+	"Named set of allowed ciphers, key exchanges and MACs: modern, fips or compatible (library defaults when empty)",
+)
+
+var ciphers = flag.String(
+	"ciphers",
+	"",
+	"Comma separated ciphers in order of preference; overrides the profile",
+)
+
+var kexAlgorithms = flag.String(
+	"kexAlgorithms",
+	"",
+	"Comma separated key exchange algorithms in order of preference; overrides the profile",
+)
+
+var macs = flag.String(
+	"macs",
+	"",
+	"Comma separated MAC algorithms in order of preference; overrides the profile",
+)
+
 var inheritDaemonEnv = flag.Bool(
 	"inheritDaemonEnv",
 	false,
@@ -58,6 +106,14 @@ func main() {
 	flag.Parse()
 
 	logger, reconfigurableSink := cf_lager.New("sshd")
+	logger = helpers.NewRedactingLogger(logger)
+
+	if *dropsondeDestination != "" {
+		err := dropsonde.Initialize(*dropsondeDestination, "sshd")
+		if err != nil {
+			logger.Error("failed-to-initialize-dropsonde", err)
+		}
+	}
 
 	serverConfig, err := configure(logger)
 	if err != nil {
@@ -74,7 +130,7 @@ func main() {
 		serverConfig,
 		nil,
 		map[string]handlers.NewChannelHandler{
-			"session":      handlers.NewSessionChannelHandler(runner, shellLocator, getDaemonEnvironment(), 15*time.Second),
+			"session":      handlers.NewSessionChannelHandler(runner, shellLocator, getDaemonEnvironment(), 15*time.Second, recordingSink()),
 			"direct-tcpip": handlers.NewDirectTcpipChannelHandler(dialer),
 		},
 	)
@@ -84,6 +140,10 @@ func main() {
 		{"sshd", server},
 	}
 
+	if *metricsAddress != "" {
+		members = append(members, grouper.Member{"metrics-server", http_server.New(*metricsAddress, stats.NewHandler(stats.Default, "sshd_"))})
+	}
+
 	if dbgAddr := cf_debug_server.DebugAddress(flag.CommandLine); dbgAddr != "" {
 		members = append(grouper.Members{
 			{"debug-server", cf_debug_server.Runner(dbgAddr, reconfigurableSink)},
@@ -105,6 +165,13 @@ func main() {
 	os.Exit(0)
 }
 
+func recordingSink() recording.Sink {
+	if *recordingDir == "" {
+		return nil
+	}
+	return recording.NewFileSink(*recordingDir)
+}
+
 func getDaemonEnvironment() map[string]string {
 	dameonEnv := map[string]string{}
 
@@ -148,6 +215,14 @@ func configure(logger lager.Logger) (*ssh.ServerConfig, error) {
 		}
 	}
 
+	profile, err := algorithms.NewProfile(*cryptoProfile, *ciphers, *kexAlgorithms, *macs)
+	if err == nil {
+		profile.Apply(&sshConfig.Config)
+	} else {
+		logger.Error("invalid-algorithm-profile", err)
+		errorStrings = append(errorStrings, err.Error())
+	}
+
 	err = nil
 	if len(errorStrings) > 0 {
 		err = errors.New(strings.Join(errorStrings, ", "))
@@ -3,11 +3,19 @@ package daemon
 import (
 	"net"
 
+	"github.com/cloudfoundry-incubator/diego-ssh/algorithms"
 	"github.com/cloudfoundry-incubator/diego-ssh/handlers"
+	"github.com/cloudfoundry-incubator/diego-ssh/stats"
 	"github.com/pivotal-golang/lager"
 	"golang.org/x/crypto/ssh"
 )
 
+var (
+	activeConnections = stats.NewGauge("sshd_active_connections", "ActiveConnections", "Metric", "Connections currently handled by the daemon")
+	handshakeFailures = stats.NewCounter("sshd_handshake_failures_total", "HandshakeFailures", "Connections that failed the ssh handshake")
+	channelOpens      = stats.NewCounter("sshd_channel_opens_total", "ChannelOpens", "Channel open requests by type", "type")
+)
+
 type Daemon struct {
 	logger                lager.Logger
 	serverConfig          *ssh.ServerConfig
@@ -36,12 +44,20 @@ func (d *Daemon) HandleConnection(netConn net.Conn) {
 	defer logger.Info("completed")
 	defer netConn.Close()
 
-	serverConn, serverChannels, serverRequests, err := ssh.NewServerConn(netConn, d.serverConfig)
+	activeConnections.Add(1)
+	defer activeConnections.Add(-1)
+
+	conn := algorithms.NewConn(netConn)
+
+	serverConn, serverChannels, serverRequests, err := ssh.NewServerConn(conn, d.serverConfig)
 	if err != nil {
+		handshakeFailures.Inc()
 		logger.Error("handshake-failed", err)
 		return
 	}
 
+	algorithms.LogNegotiated(logger, conn, serverConn)
+
 	go d.handleGlobalRequests(logger, serverRequests)
 	go d.handleNewChannels(logger, serverChannels)
 
@@ -83,10 +99,12 @@ func (d *Daemon) handleNewChannels(logger lager.Logger, newChannelRequests <-cha
 		})
 
 		if handler, ok := d.newChannelHandlers[newChannel.ChannelType()]; ok {
+			channelOpens.Inc(newChannel.ChannelType())
 			go handler.HandleNewChannel(logger, newChannel)
 			continue
 		}
 
+		channelOpens.Inc("unknown")
 		newChannel.Reject(ssh.UnknownChannelType, newChannel.ChannelType())
 	}
 }
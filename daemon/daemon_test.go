@@ -9,6 +9,7 @@ import (
 	"github.com/cloudfoundry-incubator/diego-ssh/handlers/fake_handlers"
 	"github.com/cloudfoundry-incubator/diego-ssh/test_helpers"
 	"github.com/cloudfoundry-incubator/diego-ssh/test_helpers/fake_net"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/pivotal-golang/lager"
 	"github.com/pivotal-golang/lager/lagertest"
 	"golang.org/x/crypto/ssh"
@@ -19,7 +20,7 @@ import (
 
 var _ = Describe("Daemon", func() {
 	var (
-		logger lager.Logger
+		logger *lagertest.TestLogger
 		sshd   *daemon.Daemon
 
 		serverSSHConfig *ssh.ServerConfig
@@ -95,6 +96,10 @@ var _ = Describe("Daemon", func() {
 			It("performs a handshake", func() {
 				Expect(clientConnErr).NotTo(HaveOccurred())
 			})
+
+			It("logs the client version and the negotiated algorithms", func() {
+				Eventually(logger).Should(gbytes.Say(`negotiated-algorithms.*"client-version":"SSH-2.0-Go"`))
+			})
 		})
 	})
 
@@ -5,15 +5,20 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"regexp"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/cloudfoundry-incubator/diego-ssh/helpers"
+	"github.com/cloudfoundry-incubator/diego-ssh/identity"
+	"github.com/cloudfoundry-incubator/diego-ssh/recording"
 	"github.com/cloudfoundry-incubator/diego-ssh/scp"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
 	"github.com/docker/docker/pkg/term"
 	"github.com/kr/pty"
 	"github.com/pivotal-golang/lager"
@@ -69,10 +74,11 @@ func (shellLocator) ShellPath() string {
 }
 
 type SessionChannelHandler struct {
-	runner       Runner
-	shellLocator ShellLocator
-	defaultEnv   map[string]string
-	keepalive    time.Duration
+	runner        Runner
+	shellLocator  ShellLocator
+	defaultEnv    map[string]string
+	keepalive     time.Duration
+	recordingSink recording.Sink
 }
 
 func NewSessionChannelHandler(
@@ -80,12 +86,14 @@ func NewSessionChannelHandler(
 	shellLocator ShellLocator,
 	defaultEnv map[string]string,
 	keepalive time.Duration,
+	recordingSink recording.Sink,
 ) *SessionChannelHandler {
 	return &SessionChannelHandler{
-		runner:       runner,
-		shellLocator: shellLocator,
-		defaultEnv:   defaultEnv,
-		keepalive:    keepalive,
+		runner:        runner,
+		shellLocator:  shellLocator,
+		defaultEnv:    defaultEnv,
+		keepalive:     keepalive,
+		recordingSink: recordingSink,
 	}
 }
 
@@ -114,9 +122,10 @@ type session struct {
 	keepaliveDuration time.Duration
 	keepaliveStopCh   chan struct{}
 
-	shellPath string
-	runner    Runner
-	channel   ssh.Channel
+	shellPath     string
+	runner        Runner
+	channel       ssh.Channel
+	recordingSink recording.Sink
 
 	sync.Mutex
 	env     map[string]string
@@ -127,16 +136,23 @@ type session struct {
 	ptyRequest ptyRequestMsg
 
 	ptyMaster *os.File
+	recorder  *recording.Recorder
 }
 
 func (handler *SessionChannelHandler) newSession(logger lager.Logger, channel ssh.Channel, keepalive time.Duration) *session {
+	env := map[string]string{}
+	for k, v := range handler.defaultEnv {
+		env[k] = v
+	}
+
 	return &session{
 		logger:            logger.Session("session-channel"),
 		keepaliveDuration: keepalive,
 		runner:            handler.runner,
 		shellPath:         handler.shellLocator.ShellPath(),
 		channel:           channel,
-		env:               handler.defaultEnv,
+		recordingSink:     handler.recordingSink,
+		env:               env,
 	}
 }
 
@@ -186,6 +202,14 @@ func (sess *session) handleEnvironmentRequest(request *ssh.Request) {
 		return
 	}
 
+	if envMessage.Name == tracing.TRACE_ID_ENV {
+		logger.Info("traced-session", lager.Data{"trace-id": envMessage.Value})
+	}
+
+	if identity.IsEnv(envMessage.Name) {
+		logger.Info("identified-session", lager.Data{"name": envMessage.Name, "value": envMessage.Value})
+	}
+
 	sess.Lock()
 	sess.env[envMessage.Name] = envMessage.Value
 	sess.Unlock()
@@ -289,6 +313,7 @@ func (sess *session) handleWindowChangeRequest(request *ssh.Request) {
 		if err != nil {
 			logger.Error("failed-to-set-window-size", err)
 		}
+		sess.recorder.Resize(sess.ptyRequest.Columns, sess.ptyRequest.Rows)
 	}
 
 	if request.WantReply {
@@ -554,10 +579,24 @@ func (sess *session) runWithPty(command *exec.Cmd) error {
 	setTerminalAttributes(logger, ptyMaster, sess.ptyRequest.Modelist)
 	setWindowSize(logger, ptyMaster, sess.ptyRequest.Columns, sess.ptyRequest.Rows)
 
+	if sess.recordingSink != nil {
+		sess.recorder, err = recording.NewRecorder(sess.recordingSink, recording.Header{
+			Width:   sess.ptyRequest.Columns,
+			Height:  sess.ptyRequest.Rows,
+			Command: strings.Join(command.Args, " "),
+			Env:     map[string]string{"TERM": sess.ptyRequest.Term, "SHELL": sess.shellPath},
+		})
+		if err != nil {
+			logger.Error("failed-to-create-recorder", err)
+		}
+	}
+
+	recorder := sess.recorder
+
 	sess.wg.Add(1)
-	go helpers.Copy(logger.Session("to-pty"), nil, ptyMaster, sess.channel)
+	go helpers.Copy(logger.Session("to-pty"), nil, ptyMaster, io.TeeReader(sess.channel, recorder.Input()))
 	go func() {
-		helpers.Copy(logger.Session("from-pty"), &sess.wg, sess.channel, ptyMaster)
+		helpers.Copy(logger.Session("from-pty"), &sess.wg, io.MultiWriter(recorder.Output(), sess.channel), ptyMaster)
 		sess.channel.CloseWrite()
 	}()
 
@@ -618,6 +657,11 @@ func (sess *session) destroy() {
 		sess.ptyMaster = nil
 	}
 
+	if sess.recorder != nil {
+		sess.recorder.Close()
+		sess.recorder = nil
+	}
+
 	if sess.keepaliveStopCh != nil {
 		close(sess.keepaliveStopCh)
 	}
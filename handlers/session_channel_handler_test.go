@@ -16,9 +16,11 @@ import (
 	"github.com/cloudfoundry-incubator/diego-ssh/daemon"
 	"github.com/cloudfoundry-incubator/diego-ssh/handlers"
 	"github.com/cloudfoundry-incubator/diego-ssh/handlers/fakes"
+	"github.com/cloudfoundry-incubator/diego-ssh/recording/fake_recording"
 	"github.com/cloudfoundry-incubator/diego-ssh/test_helpers"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/pivotal-golang/lager/lagertest"
 	"golang.org/x/crypto/ssh"
 )
@@ -59,7 +61,7 @@ var _ = Describe("SessionChannelHandler", func() {
 		defaultEnv = map[string]string{}
 		defaultEnv["TEST"] = "FOO"
 
-		sessionChannelHandler = handlers.NewSessionChannelHandler(runner, shellLocator, defaultEnv, time.Second)
+		sessionChannelHandler = handlers.NewSessionChannelHandler(runner, shellLocator, defaultEnv, time.Second, nil)
 
 		newChannelHandlers = map[string]handlers.NewChannelHandler{
 			"session": sessionChannelHandler,
@@ -536,6 +538,41 @@ var _ = Describe("SessionChannelHandler", func() {
 				Expect(result).To(ContainSubstring("43 80"))
 			})
 
+			Context("when a recording sink is provided", func() {
+				var (
+					recordingSink *fake_recording.FakeSink
+					recording     *gbytes.Buffer
+				)
+
+				BeforeEach(func() {
+					recording = gbytes.NewBuffer()
+					recordingSink = &fake_recording.FakeSink{}
+					recordingSink.CreateReturns(recording, nil)
+
+					newChannelHandlers["session"] = handlers.NewSessionChannelHandler(runner, shellLocator, defaultEnv, time.Second, recordingSink)
+
+					var sessionErr error
+					session, sessionErr = client.NewSession()
+					Expect(sessionErr).NotTo(HaveOccurred())
+				})
+
+				It("records the session in asciicast v2 format", func() {
+					_, err := session.Output("/bin/echo -n recorded-output")
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(recordingSink.CreateCallCount()).To(Equal(1))
+					header := recordingSink.CreateArgsForCall(0)
+					Expect(header.Version).To(Equal(2))
+					Expect(header.Width).To(BeEquivalentTo(80))
+					Expect(header.Height).To(BeEquivalentTo(43))
+					Expect(header.Env).To(HaveKeyWithValue("TERM", "vt100"))
+
+					Eventually(recording.Closed).Should(BeTrue())
+					Expect(recording).To(gbytes.Say(`{"version":2,"width":80,"height":43,`))
+					Expect(recording).To(gbytes.Say(`\[[0-9.e-]+,"o","recorded-output"\]`))
+				})
+			})
+
 			Context("when control character mappings are specified in TerminalModes", func() {
 				BeforeEach(func() {
 					// Swap CTRL-Z (suspend) with CTRL-D (eof)
@@ -0,0 +1,125 @@
+package helpers
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pivotal-golang/lager"
+)
+
+const REDACTED = "*REDACTED*"
+
+var SensitiveKeyRegex *regexp.Regexp = regexp.MustCompile(`(?i)(password|passwd|private[-_]?key|secret|token|authorization|credentials)`)
+
+type redactingLogger struct {
+	lager.Logger
+}
+
+// NewRedactingLogger wraps a logger so that the values of sensitive keys in
+// the log data, including keys nested in JSON encoded strings, are masked.
+func NewRedactingLogger(logger lager.Logger) lager.Logger {
+	if _, ok := logger.(*redactingLogger); ok {
+		return logger
+	}
+	return &redactingLogger{Logger: logger}
+}
+
+func (l *redactingLogger) Session(task string, data ...lager.Data) lager.Logger {
+	return &redactingLogger{Logger: l.Logger.Session(task, RedactData(data...)...)}
+}
+
+func (l *redactingLogger) WithData(data lager.Data) lager.Logger {
+	return &redactingLogger{Logger: l.Logger.WithData(RedactData(data)[0])}
+}
+
+func (l *redactingLogger) Debug(action string, data ...lager.Data) {
+	l.Logger.Debug(action, RedactData(data...)...)
+}
+
+func (l *redactingLogger) Info(action string, data ...lager.Data) {
+	l.Logger.Info(action, RedactData(data...)...)
+}
+
+func (l *redactingLogger) Error(action string, err error, data ...lager.Data) {
+	l.Logger.Error(action, err, RedactData(data...)...)
+}
+
+func (l *redactingLogger) Fatal(action string, err error, data ...lager.Data) {
+	l.Logger.Fatal(action, err, RedactData(data...)...)
+}
+
+func RedactData(data ...lager.Data) []lager.Data {
+	redacted := make([]lager.Data, 0, len(data))
+	for _, d := range data {
+		r := lager.Data{}
+		for k, v := range d {
+			r[k] = redactValue(k, v)
+		}
+		redacted = append(redacted, r)
+	}
+	return redacted
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	if SensitiveKeyRegex.MatchString(key) {
+		return REDACTED
+	}
+
+	switch v := value.(type) {
+	case nil, bool, int, int64, uint32, uint64, float64, error, []byte:
+		return v
+	case string:
+		return redactString(v)
+	case lager.Data:
+		return redactMap(v)
+	case map[string]interface{}:
+		return redactMap(v)
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i := range v {
+			redacted[i] = redactValue("", v[i])
+		}
+		return redacted
+	}
+
+	switch reflect.Indirect(reflect.ValueOf(value)).Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		var generic interface{}
+		payload, err := json.Marshal(value)
+		if err != nil || json.Unmarshal(payload, &generic) != nil {
+			return value
+		}
+		return redactValue("", generic)
+	}
+
+	return value
+}
+
+func redactMap(m map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		redacted[k] = redactValue(k, v)
+	}
+	return redacted
+}
+
+func redactString(s string) string {
+	trimmed := strings.TrimSpace(s)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return s
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal([]byte(trimmed), &generic); err != nil {
+		return s
+	}
+
+	payload, err := json.Marshal(redactValue("", generic))
+	if err != nil {
+		return s
+	}
+
+	return string(payload)
+}
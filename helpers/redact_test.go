@@ -0,0 +1,133 @@
+package helpers_test
+
+import (
+	"errors"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/helpers"
+	"github.com/pivotal-golang/lager"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Redact", func() {
+	Describe("RedactData", func() {
+		It("masks sensitive keys", func() {
+			data := helpers.RedactData(lager.Data{
+				"password":      "some-password",
+				"private_key":   "some-key",
+				"Authorization": "bearer token",
+				"user":          "some-user",
+			})
+
+			Expect(data).To(HaveLen(1))
+			Expect(data[0]).To(Equal(lager.Data{
+				"password":      helpers.REDACTED,
+				"private_key":   helpers.REDACTED,
+				"Authorization": helpers.REDACTED,
+				"user":          "some-user",
+			}))
+		})
+
+		It("masks sensitive keys in nested data", func() {
+			data := helpers.RedactData(lager.Data{
+				"route": map[string]interface{}{
+					"user":     "some-user",
+					"password": "some-password",
+				},
+			})
+
+			Expect(data[0]["route"]).To(Equal(map[string]interface{}{
+				"user":     "some-user",
+				"password": helpers.REDACTED,
+			}))
+		})
+
+		It("masks sensitive keys in JSON encoded strings", func() {
+			data := helpers.RedactData(lager.Data{
+				"proxy-target-config": `{"address":"1.2.3.4:5678","password":"some-password"}`,
+			})
+
+			Expect(data[0]["proxy-target-config"]).To(MatchJSON(`{"address":"1.2.3.4:5678","password":"*REDACTED*"}`))
+		})
+
+		It("masks sensitive fields of structs", func() {
+			data := helpers.RedactData(lager.Data{
+				"config": struct {
+					Address  string `json:"address"`
+					Password string `json:"password"`
+				}{"1.2.3.4:5678", "some-password"},
+			})
+
+			Expect(data[0]["config"]).To(Equal(map[string]interface{}{
+				"address":  "1.2.3.4:5678",
+				"password": helpers.REDACTED,
+			}))
+		})
+
+		It("leaves other values alone", func() {
+			data := helpers.RedactData(lager.Data{
+				"count":   3,
+				"message": "{not json",
+			})
+
+			Expect(data[0]).To(Equal(lager.Data{
+				"count":   3,
+				"message": "{not json",
+			}))
+		})
+	})
+
+	Describe("NewRedactingLogger", func() {
+		var (
+			testLogger *lagertest.TestLogger
+			logger     lager.Logger
+		)
+
+		BeforeEach(func() {
+			testLogger = lagertest.NewTestLogger("test")
+			logger = helpers.NewRedactingLogger(testLogger)
+		})
+
+		It("redacts data passed to log calls", func() {
+			logger.Info("info", lager.Data{"password": "info-secret"})
+			logger.Debug("debug", lager.Data{"token": "debug-secret"})
+			logger.Error("error", errors.New("boom"), lager.Data{"secret": "error-secret"})
+
+			contents := string(testLogger.Buffer().Contents())
+			Expect(contents).To(ContainSubstring(helpers.REDACTED))
+			Expect(contents).NotTo(ContainSubstring("info-secret"))
+			Expect(contents).NotTo(ContainSubstring("debug-secret"))
+			Expect(contents).NotTo(ContainSubstring("error-secret"))
+		})
+
+		It("redacts session data and the data of nested sessions", func() {
+			session := logger.Session("outer", lager.Data{"password": "session-secret"})
+			session.Session("inner").Info("info", lager.Data{"private_key": "nested-secret"})
+
+			contents := string(testLogger.Buffer().Contents())
+			Expect(contents).To(ContainSubstring("outer.inner.info"))
+			Expect(contents).NotTo(ContainSubstring("session-secret"))
+			Expect(contents).NotTo(ContainSubstring("nested-secret"))
+		})
+
+		It("redacts the data of loggers derived with WithData", func() {
+			derived := logger.WithData(lager.Data{"token": "with-data-secret"})
+			derived.Info("info", lager.Data{"password": "derived-secret"})
+
+			contents := string(testLogger.Buffer().Contents())
+			Expect(contents).To(ContainSubstring(helpers.REDACTED))
+			Expect(contents).NotTo(ContainSubstring("with-data-secret"))
+			Expect(contents).NotTo(ContainSubstring("derived-secret"))
+		})
+
+		It("does not modify the data passed to it", func() {
+			values := []interface{}{`{"password": "slice-secret"}`}
+			logger.Info("info", lager.Data{"values": values})
+
+			Expect(values).To(Equal([]interface{}{`{"password": "slice-secret"}`}))
+			Expect(string(testLogger.Buffer().Contents())).NotTo(ContainSubstring("slice-secret"))
+		})
+	})
+})
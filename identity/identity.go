@@ -0,0 +1,61 @@
+package identity
+
+import (
+	"encoding/json"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Environment variables the proxy uses to pass the authenticated user of a
+// session to the daemon.
+const (
+	USER_ID_ENV     = "CF_SSH_USER_ID"
+	USER_NAME_ENV   = "CF_SSH_USER"
+	REALM_ENV       = "CF_SSH_REALM"
+	CLIENT_ADDR_ENV = "CF_SSH_CLIENT_ADDR"
+)
+
+var EnvNames = []string{USER_ID_ENV, USER_NAME_ENV, REALM_ENV, CLIENT_ADDR_ENV}
+
+// Identity is the end user of a session as authenticated by the proxy.
+type Identity struct {
+	UserID     string `json:"user_id,omitempty"`
+	UserName   string `json:"user_name,omitempty"`
+	Realm      string `json:"realm"`
+	ClientAddr string `json:"client_addr,omitempty"`
+}
+
+func FromPermissions(permissions *ssh.Permissions) (*Identity, error) {
+	if permissions == nil || permissions.CriticalOptions["user-identity"] == "" {
+		return nil, nil
+	}
+
+	identity := &Identity{}
+	err := json.Unmarshal([]byte(permissions.CriticalOptions["user-identity"]), identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// Env returns the value of each of the EnvNames; values that are not known
+// are empty.
+func (i Identity) Env() map[string]string {
+	return map[string]string{
+		USER_ID_ENV:     i.UserID,
+		USER_NAME_ENV:   i.UserName,
+		REALM_ENV:       i.Realm,
+		CLIENT_ADDR_ENV: i.ClientAddr,
+	}
+}
+
+// IsEnv reports whether the variable is one of the EnvNames.
+func IsEnv(name string) bool {
+	for _, envName := range EnvNames {
+		if name == envName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package identity_test
+
+import (
+	"github.com/cloudfoundry-incubator/diego-ssh/identity"
+	"golang.org/x/crypto/ssh"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Identity", func() {
+	Describe("FromPermissions", func() {
+		It("decodes the user identity option", func() {
+			id, err := identity.FromPermissions(&ssh.Permissions{
+				CriticalOptions: map[string]string{
+					"user-identity": `{"user_id":"user-guid","user_name":"jane","realm":"cf"}`,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(id).To(Equal(&identity.Identity{UserID: "user-guid", UserName: "jane", Realm: "cf"}))
+		})
+
+		It("returns nil when there is no identity", func() {
+			id, err := identity.FromPermissions(&ssh.Permissions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(id).To(BeNil())
+		})
+
+		It("fails when the identity is invalid", func() {
+			_, err := identity.FromPermissions(&ssh.Permissions{
+				CriticalOptions: map[string]string{"user-identity": "{"},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("maps the identity to environment variables", func() {
+		id := identity.Identity{UserID: "user-guid", UserName: "jane", Realm: "cf", ClientAddr: "1.2.3.4:5678"}
+		Expect(id.Env()).To(Equal(map[string]string{
+			"CF_SSH_USER_ID":     "user-guid",
+			"CF_SSH_USER":        "jane",
+			"CF_SSH_REALM":       "cf",
+			"CF_SSH_CLIENT_ADDR": "1.2.3.4:5678",
+		}))
+	})
+})
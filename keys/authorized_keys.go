@@ -0,0 +1,35 @@
+package keys
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LoadAuthorizedKeys reads the public keys of a file in authorized_keys
+// format. Blank lines and comments are skipped.
+func LoadAuthorizedKeys(path string) ([]ssh.PublicKey, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeys := []ssh.PublicKey{}
+	for _, line := range bytes.Split(contents, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+
+		publicKeys = append(publicKeys, key)
+	}
+
+	return publicKeys, nil
+}
@@ -0,0 +1,61 @@
+package keys_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/keys"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LoadAuthorizedKeys", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "authorized-keys")
+		Expect(err).NotTo(HaveOccurred())
+
+		path = filepath.Join(dir, "authorized_keys")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("loads every key in the file", func() {
+		firstKey, err := keys.RSAKeyPairFactory.NewKeyPair(1024)
+		Expect(err).NotTo(HaveOccurred())
+		secondKey, err := keys.RSAKeyPairFactory.NewKeyPair(1024)
+		Expect(err).NotTo(HaveOccurred())
+
+		contents := "# central proxies\n" + strings.TrimSpace(firstKey.AuthorizedKey()) + " proxy-a\n\n" + secondKey.AuthorizedKey()
+		Expect(ioutil.WriteFile(path, []byte(contents), 0600)).To(Succeed())
+
+		publicKeys, err := keys.LoadAuthorizedKeys(path)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(publicKeys).To(HaveLen(2))
+		Expect(publicKeys[0].Marshal()).To(Equal(firstKey.PublicKey().Marshal()))
+		Expect(publicKeys[1].Marshal()).To(Equal(secondKey.PublicKey().Marshal()))
+	})
+
+	It("fails when the file is missing", func() {
+		_, err := keys.LoadAuthorizedKeys(path)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails when a line is not a public key", func() {
+		Expect(ioutil.WriteFile(path, []byte("garbage\n"), 0600)).To(Succeed())
+
+		_, err := keys.LoadAuthorizedKeys(path)
+		Expect(err).To(MatchError(ContainSubstring(path)))
+	})
+})
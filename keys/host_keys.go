@@ -0,0 +1,29 @@
+package keys
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LoadHostKeys reads a PEM encoded private key from each of the files.
+func LoadHostKeys(paths ...string) ([]ssh.Signer, error) {
+	hostKeys := []ssh.Signer{}
+
+	for _, path := range paths {
+		pemBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		hostKey, err := ssh.ParsePrivateKey(pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+
+		hostKeys = append(hostKeys, hostKey)
+	}
+
+	return hostKeys, nil
+}
@@ -0,0 +1,59 @@
+package keys_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/keys"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LoadHostKeys", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "host-keys")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	writeKey := func(name string) keys.KeyPair {
+		keyPair, err := keys.RSAKeyPairFactory.NewKeyPair(1024)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte(keyPair.PEMEncodedPrivateKey()), 0600)).To(Succeed())
+		return keyPair
+	}
+
+	It("loads the keys in order", func() {
+		oldKey := writeKey("old")
+		newKey := writeKey("new")
+
+		hostKeys, err := keys.LoadHostKeys(filepath.Join(dir, "old"), filepath.Join(dir, "new"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(hostKeys).To(HaveLen(2))
+		Expect(hostKeys[0].PublicKey().Marshal()).To(Equal(oldKey.PublicKey().Marshal()))
+		Expect(hostKeys[1].PublicKey().Marshal()).To(Equal(newKey.PublicKey().Marshal()))
+	})
+
+	It("fails when a file is missing", func() {
+		_, err := keys.LoadHostKeys(filepath.Join(dir, "missing"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails when a file is not a private key", func() {
+		path := filepath.Join(dir, "garbage")
+		Expect(ioutil.WriteFile(path, []byte("garbage"), 0600)).To(Succeed())
+
+		_, err := keys.LoadHostKeys(path)
+		Expect(err).To(MatchError(ContainSubstring(path)))
+	})
+})
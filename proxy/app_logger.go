@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/logs"
+	"golang.org/x/crypto/ssh"
+)
+
+// AppLogger sends the end of a session and the commands executed during it to
+// the app log stream of the target. A nil AppLogger sends nothing.
+type AppLogger struct {
+	guid       string
+	indexes    []string
+	remoteAddr string
+	started    time.Time
+}
+
+// NewAppLogger returns nil unless the target has opted in to session logs.
+// Messages are sent to each of the instances the session reaches.
+func NewAppLogger(logMessage *LogMessage, instances []int, remoteAddr string) *AppLogger {
+	if logMessage == nil || logMessage.Guid == "" || !logMessage.SessionLogs {
+		return nil
+	}
+
+	indexes := []string{}
+	for _, index := range instances {
+		indexes = append(indexes, strconv.Itoa(index))
+	}
+
+	return &AppLogger{
+		guid:       logMessage.Guid,
+		indexes:    indexes,
+		remoteAddr: remoteAddr,
+		started:    time.Now(),
+	}
+}
+
+// Requests observes the requests sent to a channel before passing them on.
+func (a *AppLogger) Requests(requests <-chan *ssh.Request) <-chan *ssh.Request {
+	if a == nil {
+		return requests
+	}
+
+	observed := make(chan *ssh.Request)
+
+	go func() {
+		defer close(observed)
+		for req := range requests {
+			if req.Type == "exec" {
+				var exec struct {
+					Command string
+				}
+				if ssh.Unmarshal(req.Payload, &exec) == nil {
+					a.send(fmt.Sprintf("Remote command by %s: %s", a.remoteAddr, exec.Command))
+				}
+			}
+			observed <- req
+		}
+	}()
+
+	return observed
+}
+
+func (a *AppLogger) Ended() {
+	if a == nil {
+		return
+	}
+
+	duration := time.Since(a.started)
+	duration -= duration % time.Second
+
+	a.send(fmt.Sprintf("Remote access by %s ended after %s", a.remoteAddr, duration))
+}
+
+func (a *AppLogger) send(message string) {
+	for _, index := range a.indexes {
+		logs.SendAppLog(a.guid, message, "SSH", index)
+	}
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/algorithms"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	TargetUnreachableErr      = errors.New("Unable to connect to the app instance")
+	TargetDialTimeoutErr      = errors.New("Timed out connecting to the app instance")
+	TargetHandshakeErr        = errors.New("SSH handshake with the app instance failed")
+	TargetHandshakeTimeoutErr = errors.New("Timed out during the SSH handshake with the app instance")
+)
+
+// DialConfig bounds how long the proxy waits for a target and how often it
+// retries transient failures. Zero values disable the timeouts and retries.
+type DialConfig struct {
+	DialTimeout      time.Duration
+	HandshakeTimeout time.Duration
+	Retries          int
+	RetryBackoff     time.Duration
+	Algorithms       algorithms.Profile
+}
+
+// targetError keeps the detailed failure for logs and the reason that is
+// safe to show to the user.
+type targetError struct {
+	reason    error
+	err       error
+	transient bool
+}
+
+func (e *targetError) Error() string {
+	return e.err.Error()
+}
+
+// TargetErrorReason returns the user facing reason for a failure to connect
+// to a target.
+func TargetErrorReason(err error) error {
+	if targetErr, ok := err.(*targetError); ok {
+		return targetErr.reason
+	}
+	return TargetUnreachableErr
+}
+
+func dialTarget(
+	logger lager.Logger,
+	span *tracing.Span,
+	address string,
+	clientConfig *ssh.ClientConfig,
+	config DialConfig,
+) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	backoff := config.RetryBackoff
+	attempts := config.Retries + 1
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		conn, ch, req, dialErr := dialTargetOnce(logger, span, address, clientConfig, config)
+		if dialErr == nil {
+			return conn, ch, req, nil
+		}
+
+		err = dialErr
+		if !dialErr.transient {
+			break
+		}
+
+		if attempt < attempts {
+			logger.Info("retrying", lager.Data{"attempt": attempt, "backoff": backoff.String(), "error": err.Error()})
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	return nil, nil, nil, err
+}
+
+func dialTargetOnce(
+	logger lager.Logger,
+	span *tracing.Span,
+	address string,
+	clientConfig *ssh.ClientConfig,
+	config DialConfig,
+) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, *targetError) {
+	dial := span.Child("tcp-dial")
+	nConn, err := net.DialTimeout("tcp", address, config.DialTimeout)
+	dial.Finish(err)
+	if err != nil {
+		logger.Error("dial-failed", err)
+
+		reason := TargetUnreachableErr
+		if isTimeout(err) {
+			reason = TargetDialTimeoutErr
+		}
+		return nil, nil, nil, &targetError{reason: reason, err: err, transient: true}
+	}
+
+	var deadline time.Time
+	if config.HandshakeTimeout > 0 {
+		deadline = time.Now().Add(config.HandshakeTimeout)
+		nConn.SetDeadline(deadline)
+	}
+
+	handshake := span.Child("target-handshake")
+	conn, ch, req, err := ssh.NewClientConn(nConn, address, clientConfig)
+	handshake.Finish(err)
+	if err != nil {
+		logger.Error("handshake-failed", err)
+		nConn.Close()
+
+		// The handshake error does not preserve the underlying net.Error, so
+		// a timeout is detected from the deadline.
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return nil, nil, nil, &targetError{reason: TargetHandshakeTimeoutErr, err: err, transient: true}
+		}
+		return nil, nil, nil, &targetError{reason: TargetHandshakeErr, err: err}
+	}
+
+	nConn.SetDeadline(time.Time{})
+
+	return conn, ch, req, nil
+}
+
+// targetFailureNotice is how long a connection whose target could not be
+// reached is kept open so the client can be told why when it opens a channel.
+var targetFailureNotice = 500 * time.Millisecond
+
+func noticeTargetFailure(channels <-chan ssh.NewChannel, requests <-chan *ssh.Request, reason error) {
+	go ssh.DiscardRequests(requests)
+
+	select {
+	case newChannel, ok := <-channels:
+		if ok {
+			newChannel.Reject(ssh.ConnectionFailed, reason.Error())
+		}
+	case <-time.After(targetFailureNotice):
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
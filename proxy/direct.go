@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+var PortForwardingOnlyErr = errors.New("Only port forwarding is available for this app")
+
+// DirectPort maps a port declared by the app to the address it is exposed on.
+type DirectPort struct {
+	ContainerPort uint16 `json:"container_port"`
+	Address       string `json:"address"`
+}
+
+// directConn stands in for the connection to the SSH daemon of a target that
+// does not run one. Port forwards are dialed straight to the host side
+// mappings of the declared ports and everything else is refused.
+type directConn struct {
+	logger      lager.Logger
+	target      TargetConfig
+	dialTimeout time.Duration
+
+	channels chan ssh.NewChannel
+	requests chan *ssh.Request
+
+	lock      sync.Mutex
+	conns     map[net.Conn]struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newDirectConn(logger lager.Logger, target TargetConfig, dialTimeout time.Duration) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request) {
+	conn := &directConn{
+		logger:      logger.Session("direct-conn"),
+		target:      target,
+		dialTimeout: dialTimeout,
+		channels:    make(chan ssh.NewChannel),
+		requests:    make(chan *ssh.Request),
+		conns:       map[net.Conn]struct{}{},
+		closed:      make(chan struct{}),
+	}
+
+	return conn, conn.channels, conn.requests
+}
+
+func (c *directConn) User() string          { return c.target.User }
+func (c *directConn) SessionID() []byte     { return nil }
+func (c *directConn) ClientVersion() []byte { return nil }
+func (c *directConn) ServerVersion() []byte { return nil }
+func (c *directConn) RemoteAddr() net.Addr  { return directAddr(c.target.Address) }
+func (c *directConn) LocalAddr() net.Addr   { return directAddr("") }
+
+func (c *directConn) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	return false, nil, nil
+}
+
+func (c *directConn) OpenChannel(channelType string, extraData []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+	if channelType != "direct-tcpip" {
+		return nil, nil, &ssh.OpenChannelError{Reason: ssh.Prohibited, Message: PortForwardingOnlyErr.Error()}
+	}
+
+	var forward struct {
+		Host           string
+		Port           uint32
+		OriginatorHost string
+		OriginatorPort uint32
+	}
+	if ssh.Unmarshal(extraData, &forward) != nil {
+		return nil, nil, &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "Failed to parse open channel message"}
+	}
+
+	address, ok := c.portAddress(forward.Port)
+	if !ok {
+		return nil, nil, &ssh.OpenChannelError{
+			Reason:  ssh.Prohibited,
+			Message: fmt.Sprintf("Port %d is not exposed by the app", forward.Port),
+		}
+	}
+
+	logger := c.logger.Session("open-channel", lager.Data{"port": forward.Port, "address": address})
+
+	conn, err := net.DialTimeout("tcp", address, c.dialTimeout)
+	if err != nil {
+		logger.Error("dial-failed", err)
+		return nil, nil, &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: TargetUnreachableErr.Error()}
+	}
+
+	if !c.track(conn) {
+		conn.Close()
+		return nil, nil, io.EOF
+	}
+
+	requests := make(chan *ssh.Request)
+	close(requests)
+
+	return &directChannel{Conn: conn, release: func() { c.untrack(conn) }}, requests, nil
+}
+
+func (c *directConn) portAddress(port uint32) (string, bool) {
+	for _, mapping := range c.target.Ports {
+		if uint32(mapping.ContainerPort) == port {
+			return mapping.Address, true
+		}
+	}
+	return "", false
+}
+
+func (c *directConn) track(conn net.Conn) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	select {
+	case <-c.closed:
+		return false
+	default:
+	}
+
+	c.conns[conn] = struct{}{}
+	return true
+}
+
+func (c *directConn) untrack(conn net.Conn) {
+	c.lock.Lock()
+	delete(c.conns, conn)
+	c.lock.Unlock()
+}
+
+// Close closes the forwarded connections that are still open.
+func (c *directConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.lock.Lock()
+		close(c.closed)
+		conns := c.conns
+		c.conns = map[net.Conn]struct{}{}
+		c.lock.Unlock()
+
+		for conn := range conns {
+			conn.Close()
+		}
+
+		close(c.channels)
+		close(c.requests)
+	})
+
+	return nil
+}
+
+func (c *directConn) Wait() error {
+	<-c.closed
+	return nil
+}
+
+type directAddr string
+
+func (a directAddr) Network() string { return "tcp" }
+func (a directAddr) String() string  { return string(a) }
+
+// directChannel carries a port forward over a TCP connection. It has no
+// requests or extended data.
+type directChannel struct {
+	net.Conn
+	release func()
+}
+
+func (c *directChannel) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
+
+func (c *directChannel) CloseWrite() error {
+	if tcpConn, ok := c.Conn.(*net.TCPConn); ok {
+		return tcpConn.CloseWrite()
+	}
+	return nil
+}
+
+func (c *directChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	return false, nil
+}
+
+func (c *directChannel) Stderr() io.ReadWriter {
+	return &bytes.Buffer{}
+}
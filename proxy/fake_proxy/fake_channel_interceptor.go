@@ -0,0 +1,153 @@
+// This file was generated by counterfeiter
+package fake_proxy
+
+import (
+	"io"
+	"sync"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	"golang.org/x/crypto/ssh"
+)
+
+type FakeChannelInterceptor struct {
+	RequestsStub        func(requests <-chan *ssh.Request) <-chan *ssh.Request
+	requestsMutex       sync.RWMutex
+	requestsArgsForCall []struct {
+		requests <-chan *ssh.Request
+	}
+	requestsReturns struct {
+		result1 <-chan *ssh.Request
+	}
+	InputStub        func(w io.Writer) io.Writer
+	inputMutex       sync.RWMutex
+	inputArgsForCall []struct {
+		w io.Writer
+	}
+	inputReturns struct {
+		result1 io.Writer
+	}
+	OutputStub        func(w io.Writer) io.Writer
+	outputMutex       sync.RWMutex
+	outputArgsForCall []struct {
+		w io.Writer
+	}
+	outputReturns struct {
+		result1 io.Writer
+	}
+	CloseStub        func()
+	closeMutex       sync.RWMutex
+	closeArgsForCall []struct{}
+}
+
+func (fake *FakeChannelInterceptor) Requests(requests <-chan *ssh.Request) <-chan *ssh.Request {
+	fake.requestsMutex.Lock()
+	fake.requestsArgsForCall = append(fake.requestsArgsForCall, struct {
+		requests <-chan *ssh.Request
+	}{requests})
+	fake.requestsMutex.Unlock()
+	if fake.RequestsStub != nil {
+		return fake.RequestsStub(requests)
+	} else {
+		return fake.requestsReturns.result1
+	}
+}
+
+func (fake *FakeChannelInterceptor) RequestsCallCount() int {
+	fake.requestsMutex.RLock()
+	defer fake.requestsMutex.RUnlock()
+	return len(fake.requestsArgsForCall)
+}
+
+func (fake *FakeChannelInterceptor) RequestsArgsForCall(i int) <-chan *ssh.Request {
+	fake.requestsMutex.RLock()
+	defer fake.requestsMutex.RUnlock()
+	return fake.requestsArgsForCall[i].requests
+}
+
+func (fake *FakeChannelInterceptor) RequestsReturns(result1 <-chan *ssh.Request) {
+	fake.RequestsStub = nil
+	fake.requestsReturns = struct {
+		result1 <-chan *ssh.Request
+	}{result1}
+}
+
+func (fake *FakeChannelInterceptor) Input(w io.Writer) io.Writer {
+	fake.inputMutex.Lock()
+	fake.inputArgsForCall = append(fake.inputArgsForCall, struct {
+		w io.Writer
+	}{w})
+	fake.inputMutex.Unlock()
+	if fake.InputStub != nil {
+		return fake.InputStub(w)
+	} else {
+		return fake.inputReturns.result1
+	}
+}
+
+func (fake *FakeChannelInterceptor) InputCallCount() int {
+	fake.inputMutex.RLock()
+	defer fake.inputMutex.RUnlock()
+	return len(fake.inputArgsForCall)
+}
+
+func (fake *FakeChannelInterceptor) InputArgsForCall(i int) io.Writer {
+	fake.inputMutex.RLock()
+	defer fake.inputMutex.RUnlock()
+	return fake.inputArgsForCall[i].w
+}
+
+func (fake *FakeChannelInterceptor) InputReturns(result1 io.Writer) {
+	fake.InputStub = nil
+	fake.inputReturns = struct {
+		result1 io.Writer
+	}{result1}
+}
+
+func (fake *FakeChannelInterceptor) Output(w io.Writer) io.Writer {
+	fake.outputMutex.Lock()
+	fake.outputArgsForCall = append(fake.outputArgsForCall, struct {
+		w io.Writer
+	}{w})
+	fake.outputMutex.Unlock()
+	if fake.OutputStub != nil {
+		return fake.OutputStub(w)
+	} else {
+		return fake.outputReturns.result1
+	}
+}
+
+func (fake *FakeChannelInterceptor) OutputCallCount() int {
+	fake.outputMutex.RLock()
+	defer fake.outputMutex.RUnlock()
+	return len(fake.outputArgsForCall)
+}
+
+func (fake *FakeChannelInterceptor) OutputArgsForCall(i int) io.Writer {
+	fake.outputMutex.RLock()
+	defer fake.outputMutex.RUnlock()
+	return fake.outputArgsForCall[i].w
+}
+
+func (fake *FakeChannelInterceptor) OutputReturns(result1 io.Writer) {
+	fake.OutputStub = nil
+	fake.outputReturns = struct {
+		result1 io.Writer
+	}{result1}
+}
+
+func (fake *FakeChannelInterceptor) Close() {
+	fake.closeMutex.Lock()
+	fake.closeArgsForCall = append(fake.closeArgsForCall, struct{}{})
+	fake.closeMutex.Unlock()
+	if fake.CloseStub != nil {
+		fake.CloseStub()
+	}
+}
+
+func (fake *FakeChannelInterceptor) CloseCallCount() int {
+	fake.closeMutex.RLock()
+	defer fake.closeMutex.RUnlock()
+	return len(fake.closeArgsForCall)
+}
+
+var _ proxy.ChannelInterceptor = new(FakeChannelInterceptor)
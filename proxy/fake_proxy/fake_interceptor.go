@@ -0,0 +1,147 @@
+// This file was generated by counterfeiter
+package fake_proxy
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+type FakeInterceptor struct {
+	OpenChannelStub        func(logger lager.Logger, channelType string, extraData []byte) ([]byte, error)
+	openChannelMutex       sync.RWMutex
+	openChannelArgsForCall []struct {
+		logger      lager.Logger
+		channelType string
+		extraData   []byte
+	}
+	openChannelReturns struct {
+		result1 []byte
+		result2 error
+	}
+	ChannelOpenedStub        func(logger lager.Logger, channelType string, extraData []byte) proxy.ChannelInterceptor
+	channelOpenedMutex       sync.RWMutex
+	channelOpenedArgsForCall []struct {
+		logger      lager.Logger
+		channelType string
+		extraData   []byte
+	}
+	channelOpenedReturns struct {
+		result1 proxy.ChannelInterceptor
+	}
+	GlobalRequestStub        func(logger lager.Logger, req *ssh.Request) error
+	globalRequestMutex       sync.RWMutex
+	globalRequestArgsForCall []struct {
+		logger lager.Logger
+		req    *ssh.Request
+	}
+	globalRequestReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeInterceptor) OpenChannel(logger lager.Logger, channelType string, extraData []byte) ([]byte, error) {
+	fake.openChannelMutex.Lock()
+	fake.openChannelArgsForCall = append(fake.openChannelArgsForCall, struct {
+		logger      lager.Logger
+		channelType string
+		extraData   []byte
+	}{logger, channelType, extraData})
+	fake.openChannelMutex.Unlock()
+	if fake.OpenChannelStub != nil {
+		return fake.OpenChannelStub(logger, channelType, extraData)
+	} else {
+		return fake.openChannelReturns.result1, fake.openChannelReturns.result2
+	}
+}
+
+func (fake *FakeInterceptor) OpenChannelCallCount() int {
+	fake.openChannelMutex.RLock()
+	defer fake.openChannelMutex.RUnlock()
+	return len(fake.openChannelArgsForCall)
+}
+
+func (fake *FakeInterceptor) OpenChannelArgsForCall(i int) (lager.Logger, string, []byte) {
+	fake.openChannelMutex.RLock()
+	defer fake.openChannelMutex.RUnlock()
+	return fake.openChannelArgsForCall[i].logger, fake.openChannelArgsForCall[i].channelType, fake.openChannelArgsForCall[i].extraData
+}
+
+func (fake *FakeInterceptor) OpenChannelReturns(result1 []byte, result2 error) {
+	fake.OpenChannelStub = nil
+	fake.openChannelReturns = struct {
+		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeInterceptor) ChannelOpened(logger lager.Logger, channelType string, extraData []byte) proxy.ChannelInterceptor {
+	fake.channelOpenedMutex.Lock()
+	fake.channelOpenedArgsForCall = append(fake.channelOpenedArgsForCall, struct {
+		logger      lager.Logger
+		channelType string
+		extraData   []byte
+	}{logger, channelType, extraData})
+	fake.channelOpenedMutex.Unlock()
+	if fake.ChannelOpenedStub != nil {
+		return fake.ChannelOpenedStub(logger, channelType, extraData)
+	} else {
+		return fake.channelOpenedReturns.result1
+	}
+}
+
+func (fake *FakeInterceptor) ChannelOpenedCallCount() int {
+	fake.channelOpenedMutex.RLock()
+	defer fake.channelOpenedMutex.RUnlock()
+	return len(fake.channelOpenedArgsForCall)
+}
+
+func (fake *FakeInterceptor) ChannelOpenedArgsForCall(i int) (lager.Logger, string, []byte) {
+	fake.channelOpenedMutex.RLock()
+	defer fake.channelOpenedMutex.RUnlock()
+	return fake.channelOpenedArgsForCall[i].logger, fake.channelOpenedArgsForCall[i].channelType, fake.channelOpenedArgsForCall[i].extraData
+}
+
+func (fake *FakeInterceptor) ChannelOpenedReturns(result1 proxy.ChannelInterceptor) {
+	fake.ChannelOpenedStub = nil
+	fake.channelOpenedReturns = struct {
+		result1 proxy.ChannelInterceptor
+	}{result1}
+}
+
+func (fake *FakeInterceptor) GlobalRequest(logger lager.Logger, req *ssh.Request) error {
+	fake.globalRequestMutex.Lock()
+	fake.globalRequestArgsForCall = append(fake.globalRequestArgsForCall, struct {
+		logger lager.Logger
+		req    *ssh.Request
+	}{logger, req})
+	fake.globalRequestMutex.Unlock()
+	if fake.GlobalRequestStub != nil {
+		return fake.GlobalRequestStub(logger, req)
+	} else {
+		return fake.globalRequestReturns.result1
+	}
+}
+
+func (fake *FakeInterceptor) GlobalRequestCallCount() int {
+	fake.globalRequestMutex.RLock()
+	defer fake.globalRequestMutex.RUnlock()
+	return len(fake.globalRequestArgsForCall)
+}
+
+func (fake *FakeInterceptor) GlobalRequestArgsForCall(i int) (lager.Logger, *ssh.Request) {
+	fake.globalRequestMutex.RLock()
+	defer fake.globalRequestMutex.RUnlock()
+	return fake.globalRequestArgsForCall[i].logger, fake.globalRequestArgsForCall[i].req
+}
+
+func (fake *FakeInterceptor) GlobalRequestReturns(result1 error) {
+	fake.GlobalRequestStub = nil
+	fake.globalRequestReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ proxy.Interceptor = new(FakeInterceptor)
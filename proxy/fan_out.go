@@ -0,0 +1,482 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+var FanOutExecOnlyErr = errors.New("Only commands can be run on all instances")
+
+// fanOutFailureStatus is reported for instances that could not run the
+// command, mirroring the status ssh uses for connection failures.
+const fanOutFailureStatus = 255
+
+// FanOutTarget is one of the instances a fan-out login runs commands on.
+type FanOutTarget struct {
+	Index        int          `json:"index"`
+	TargetConfig TargetConfig `json:"target"`
+}
+
+func FanOutTargetsFromPermissions(permissions *ssh.Permissions) ([]FanOutTarget, error) {
+	if permissions == nil || permissions.CriticalOptions["proxy-fan-out-targets"] == "" {
+		return nil, nil
+	}
+
+	var targets []FanOutTarget
+	err := json.Unmarshal([]byte(permissions.CriticalOptions["proxy-fan-out-targets"]), &targets)
+	if err != nil {
+		return nil, err
+	}
+
+	return targets, nil
+}
+
+// fanOutIndexes returns the indexes of the instances a fan-out login runs
+// commands on.
+func fanOutIndexes(targets []FanOutTarget) []int {
+	indexes := make([]int, 0, len(targets))
+	for _, target := range targets {
+		indexes = append(indexes, target.Index)
+	}
+	return indexes
+}
+
+// fanOutConn stands in for the connection to the SSH daemon of a login that
+// runs commands on several instances. Each session channel accepts
+// environment variables and a single exec request; the command runs on every
+// instance in parallel and each line of output is prefixed with the index of
+// the instance it came from. The exit status is the highest status of the
+// instances. Other channels are refused.
+type fanOutConn struct {
+	logger     lager.Logger
+	targets    []FanOutTarget
+	logGuid    string
+	options    map[string]string
+	origin     *HopOrigin
+	dialConfig DialConfig
+
+	channels chan ssh.NewChannel
+	requests chan *ssh.Request
+
+	lock      sync.Mutex
+	sessions  map[*fanOutChannel]struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newFanOutConn(
+	logger lager.Logger,
+	targets []FanOutTarget,
+	logGuid string,
+	options map[string]string,
+	origin *HopOrigin,
+	dialConfig DialConfig,
+) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request) {
+	conn := &fanOutConn{
+		logger:     logger.Session("fan-out", lager.Data{"instances": len(targets)}),
+		targets:    targets,
+		logGuid:    logGuid,
+		options:    options,
+		origin:     origin,
+		dialConfig: dialConfig,
+		channels:   make(chan ssh.NewChannel),
+		requests:   make(chan *ssh.Request),
+		sessions:   map[*fanOutChannel]struct{}{},
+		closed:     make(chan struct{}),
+	}
+
+	return conn, conn.channels, conn.requests
+}
+
+func (c *fanOutConn) User() string          { return "" }
+func (c *fanOutConn) SessionID() []byte     { return nil }
+func (c *fanOutConn) ClientVersion() []byte { return nil }
+func (c *fanOutConn) ServerVersion() []byte { return nil }
+func (c *fanOutConn) LocalAddr() net.Addr   { return directAddr("") }
+
+func (c *fanOutConn) RemoteAddr() net.Addr {
+	addresses := []string{}
+	for _, target := range c.targets {
+		addresses = append(addresses, target.TargetConfig.Address)
+	}
+	return directAddr(strings.Join(addresses, ","))
+}
+
+func (c *fanOutConn) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	return false, nil, nil
+}
+
+func (c *fanOutConn) OpenChannel(channelType string, extraData []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+	if channelType != "session" {
+		return nil, nil, &ssh.OpenChannelError{Reason: ssh.Prohibited, Message: FanOutExecOnlyErr.Error()}
+	}
+
+	channel := newFanOutChannel(c)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	select {
+	case <-c.closed:
+		return nil, nil, io.EOF
+	default:
+	}
+
+	c.sessions[channel] = struct{}{}
+
+	return channel, channel.requests, nil
+}
+
+func (c *fanOutConn) untrack(channel *fanOutChannel) {
+	c.lock.Lock()
+	delete(c.sessions, channel)
+	c.lock.Unlock()
+}
+
+// Close stops the commands that are still running.
+func (c *fanOutConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.lock.Lock()
+		close(c.closed)
+		sessions := c.sessions
+		c.sessions = map[*fanOutChannel]struct{}{}
+		c.lock.Unlock()
+
+		for channel := range sessions {
+			channel.Close()
+		}
+
+		close(c.channels)
+		close(c.requests)
+	})
+
+	return nil
+}
+
+func (c *fanOutConn) Wait() error {
+	<-c.closed
+	return nil
+}
+
+// fanOutChannel is a session channel of a fan-out login. The output of the
+// instances is read from it; input is discarded.
+type fanOutChannel struct {
+	conn     *fanOutConn
+	requests chan *ssh.Request
+
+	stdout       *io.PipeReader
+	stdoutWriter *io.PipeWriter
+	stderr       *io.PipeReader
+	stderrWriter *io.PipeWriter
+
+	lock      sync.Mutex
+	env       []envVar
+	running   bool
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type envVar struct {
+	Name  string
+	Value string
+}
+
+func newFanOutChannel(conn *fanOutConn) *fanOutChannel {
+	stdout, stdoutWriter := io.Pipe()
+	stderr, stderrWriter := io.Pipe()
+
+	return &fanOutChannel{
+		conn:         conn,
+		requests:     make(chan *ssh.Request),
+		stdout:       stdout,
+		stdoutWriter: stdoutWriter,
+		stderr:       stderr,
+		stderrWriter: stderrWriter,
+		closed:       make(chan struct{}),
+	}
+}
+
+func (c *fanOutChannel) Read(data []byte) (int, error) {
+	return c.stdout.Read(data)
+}
+
+func (c *fanOutChannel) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (c *fanOutChannel) CloseWrite() error {
+	return nil
+}
+
+func (c *fanOutChannel) Stderr() io.ReadWriter {
+	return struct {
+		io.Reader
+		io.Writer
+	}{c.stderr, ioutil.Discard}
+}
+
+func (c *fanOutChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	switch name {
+	case "env":
+		var v envVar
+		if ssh.Unmarshal(payload, &v) != nil {
+			return false, nil
+		}
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		if c.running {
+			return false, nil
+		}
+		c.env = append(c.env, v)
+
+		return true, nil
+
+	case "exec":
+		var exec struct {
+			Command string
+		}
+		if ssh.Unmarshal(payload, &exec) != nil {
+			return false, nil
+		}
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		select {
+		case <-c.closed:
+			return false, nil
+		default:
+		}
+
+		if c.running {
+			return false, nil
+		}
+		c.running = true
+
+		go c.exec(c.env, exec.Command)
+
+		return true, nil
+
+	case "shell", "subsystem":
+		go fmt.Fprintf(c.stderrWriter, "%s\r\n", FanOutExecOnlyErr.Error())
+		return false, nil
+
+	default:
+		return false, nil
+	}
+}
+
+func (c *fanOutChannel) exec(env []envVar, command string) {
+	status := c.conn.run(c.stdoutWriter, c.stderrWriter, env, command, c.closed)
+
+	c.stdoutWriter.Close()
+	c.stderrWriter.Close()
+
+	select {
+	case c.requests <- &ssh.Request{Type: "exit-status", Payload: ssh.Marshal(struct{ Status uint32 }{status})}:
+	case <-c.closed:
+	}
+
+	close(c.requests)
+}
+
+// Close stops the command if it is still running.
+func (c *fanOutChannel) Close() error {
+	c.closeOnce.Do(func() {
+		c.lock.Lock()
+		close(c.closed)
+		running := c.running
+		c.lock.Unlock()
+
+		c.stdout.Close()
+		c.stderr.Close()
+
+		// A running command closes the requests once it has stopped.
+		if !running {
+			close(c.requests)
+		}
+
+		c.conn.untrack(c)
+	})
+
+	return nil
+}
+
+func (c *fanOutConn) run(stdoutWriter, stderrWriter io.Writer, env []envVar, command string, cancel <-chan struct{}) uint32 {
+	outputLock := &sync.Mutex{}
+	statuses := make([]uint32, len(c.targets))
+
+	wg := &sync.WaitGroup{}
+	for i := range c.targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			target := c.targets[i]
+			stdout := newPrefixWriter(stdoutWriter, outputLock, target.Index)
+			stderr := newPrefixWriter(stderrWriter, outputLock, target.Index)
+
+			status, err := c.runOnInstance(target, env, command, stdout, stderr, cancel)
+			if err != nil {
+				fmt.Fprintf(stderr, "%s\n", err.Error())
+			}
+
+			stdout.Flush()
+			stderr.Flush()
+			statuses[i] = status
+		}(i)
+	}
+	wg.Wait()
+
+	var highest uint32
+	for _, status := range statuses {
+		if status > highest {
+			highest = status
+		}
+	}
+
+	c.logger.Info("completed", lager.Data{"command": command, "exit-status": highest})
+
+	return highest
+}
+
+func (c *fanOutConn) runOnInstance(target FanOutTarget, env []envVar, command string, stdout, stderr io.Writer, cancel <-chan struct{}) (uint32, error) {
+	logger := c.logger.Session("instance", lager.Data{"index": target.Index, "address": target.TargetConfig.Address})
+
+	if target.TargetConfig.Direct && target.TargetConfig.NextHop == nil {
+		return fanOutFailureStatus, PortForwardingOnlyErr
+	}
+
+	logMessage, err := json.Marshal(LogMessage{Guid: c.logGuid, Index: target.Index})
+	if err != nil {
+		return fanOutFailureStatus, err
+	}
+
+	options := map[string]string{"log-message": string(logMessage)}
+	for _, option := range forwardedOptions {
+		if option != "log-message" {
+			options[option] = c.options[option]
+		}
+	}
+
+	address, clientConfig, err := dialAddress(logger, target.TargetConfig, options, c.origin, c.dialConfig)
+	if err != nil {
+		return fanOutFailureStatus, err
+	}
+
+	conn, channels, requests, err := dialTarget(logger, nil, address, clientConfig, c.dialConfig)
+	if err != nil {
+		return fanOutFailureStatus, TargetErrorReason(err)
+	}
+
+	client := ssh.NewClient(conn, channels, requests)
+	defer client.Close()
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-cancel:
+			client.Close()
+		case <-finished:
+		}
+	}()
+
+	session, err := client.NewSession()
+	if err != nil {
+		logger.Error("new-session-failed", err)
+		return fanOutFailureStatus, err
+	}
+	defer session.Close()
+
+	for _, v := range env {
+		session.Setenv(v.Name, v.Value)
+	}
+
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	err = session.Run(command)
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		return uint32(exitErr.ExitStatus()), nil
+	}
+	if err != nil {
+		logger.Error("run-failed", err)
+		return fanOutFailureStatus, err
+	}
+
+	return 0, nil
+}
+
+// prefixWriter prefixes every line written to it with an instance index.
+// Complete lines are written to the shared writer while holding its lock so
+// the output of different instances is not interleaved within a line.
+type prefixWriter struct {
+	writer  io.Writer
+	lock    *sync.Mutex
+	prefix  []byte
+	pending []byte
+}
+
+func newPrefixWriter(writer io.Writer, lock *sync.Mutex, index int) *prefixWriter {
+	return &prefixWriter{
+		writer: writer,
+		lock:   lock,
+		prefix: []byte(fmt.Sprintf("[%d] ", index)),
+	}
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+
+	end := bytes.LastIndexByte(w.pending, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+
+	err := w.writeLines(w.pending[:end+1])
+	w.pending = append([]byte{}, w.pending[end+1:]...)
+
+	return len(p), err
+}
+
+// Flush writes a final line that was not terminated.
+func (w *prefixWriter) Flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+
+	err := w.writeLines(append(w.pending, '\n'))
+	w.pending = nil
+
+	return err
+}
+
+func (w *prefixWriter) writeLines(lines []byte) error {
+	buffer := &bytes.Buffer{}
+	for _, line := range bytes.SplitAfter(lines, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		buffer.Write(w.prefix)
+		buffer.Write(line)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	_, err := w.writer.Write(buffer.Bytes())
+	return err
+}
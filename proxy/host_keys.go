@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+var UnknownHostKeyErr = errors.New("unknown host key")
+
+const (
+	hostKeysRequest      = "hostkeys-00@openssh.com"
+	hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+// SetServerConfig replaces the configuration used for new connections.
+// Connections in progress keep the configuration they started with. The host
+// keys are advertised to clients with the hostkeys-00@openssh.com extension
+// so they can learn about new keys before old ones are retired.
+func (p *Proxy) SetServerConfig(serverConfig *ssh.ServerConfig, hostKeys []ssh.Signer) {
+	p.configLock.Lock()
+	defer p.configLock.Unlock()
+
+	p.serverConfig = serverConfig
+	p.hostKeys = hostKeys
+}
+
+func advertiseHostKeys(logger lager.Logger, conn ssh.Conn, hostKeys []ssh.Signer) {
+	payload := []byte{}
+	for _, hostKey := range hostKeys {
+		payload = append(payload, ssh.Marshal(struct{ Key []byte }{hostKey.PublicKey().Marshal()})...)
+	}
+
+	_, _, err := conn.SendRequest(hostKeysRequest, false, payload)
+	if err != nil {
+		logger.Error("advertise-host-keys-failed", err)
+	}
+}
+
+// proveHostKeys answers the requests of clients that want proof that the
+// proxy holds the private half of advertised host keys and passes all other
+// requests on.
+func proveHostKeys(logger lager.Logger, sessionID []byte, hostKeys []ssh.Signer, requests <-chan *ssh.Request) <-chan *ssh.Request {
+	passed := make(chan *ssh.Request)
+
+	go func() {
+		defer close(passed)
+
+		for req := range requests {
+			if req.Type != hostKeysProveRequest {
+				passed <- req
+				continue
+			}
+
+			proof, err := proveHostKeyPayload(sessionID, hostKeys, req.Payload)
+			if err != nil {
+				logger.Error("prove-host-keys-failed", err)
+				req.Reply(false, nil)
+				continue
+			}
+
+			req.Reply(true, proof)
+		}
+	}()
+
+	return passed
+}
+
+func proveHostKeyPayload(sessionID []byte, hostKeys []ssh.Signer, payload []byte) ([]byte, error) {
+	proof := []byte{}
+
+	for len(payload) > 0 {
+		var blob struct {
+			Key  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(payload, &blob); err != nil {
+			return nil, err
+		}
+		payload = blob.Rest
+
+		hostKey := findHostKey(hostKeys, blob.Key)
+		if hostKey == nil {
+			return nil, UnknownHostKeyErr
+		}
+
+		signature, err := signHostKeyProof(hostKey, ssh.Marshal(struct {
+			Type      string
+			SessionID []byte
+			Key       []byte
+		}{hostKeysProveRequest, sessionID, blob.Key}))
+		if err != nil {
+			return nil, err
+		}
+
+		proof = append(proof, ssh.Marshal(struct{ Signature []byte }{ssh.Marshal(signature)})...)
+	}
+
+	return proof, nil
+}
+
+// signHostKeyProof signs with rsa-sha2-512 for RSA keys. OpenSSH clients
+// verify RSA proofs with the SHA-2 algorithm negotiated during key exchange
+// and reject ssh-rsa signatures, which use SHA-1.
+func signHostKeyProof(hostKey ssh.Signer, data []byte) (*ssh.Signature, error) {
+	if hostKey.PublicKey().Type() == ssh.KeyAlgoRSA {
+		if algorithmSigner, ok := hostKey.(ssh.AlgorithmSigner); ok {
+			return algorithmSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+		}
+	}
+
+	return hostKey.Sign(rand.Reader, data)
+}
+
+func findHostKey(hostKeys []ssh.Signer, blob []byte) ssh.Signer {
+	for _, hostKey := range hostKeys {
+		if bytes.Equal(hostKey.PublicKey().Marshal(), blob) {
+			return hostKey
+		}
+	}
+	return nil
+}
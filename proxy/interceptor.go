@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"io"
+
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+// Interceptor hooks into the proxying of a connection. Interceptors are
+// applied in order; the first interceptor sees traffic from the source first.
+//
+//go:generate counterfeiter -o fake_proxy/fake_interceptor.go . Interceptor
+type Interceptor interface {
+	// OpenChannel is called before a channel is opened on the target. It
+	// returns the extra data to open the channel with or an error to reject
+	// the channel. An *ssh.OpenChannelError is passed to the source as is;
+	// other errors reject the channel as prohibited.
+	OpenChannel(logger lager.Logger, channelType string, extraData []byte) ([]byte, error)
+
+	// ChannelOpened is called once both sides have accepted the channel. It
+	// may return nil when the channel is of no interest.
+	ChannelOpened(logger lager.Logger, channelType string, extraData []byte) ChannelInterceptor
+
+	// GlobalRequest is called before a global request is sent to the target.
+	// An error rejects the request.
+	GlobalRequest(logger lager.Logger, req *ssh.Request) error
+}
+
+//go:generate counterfeiter -o fake_proxy/fake_channel_interceptor.go . ChannelInterceptor
+type ChannelInterceptor interface {
+	// Requests filters the channel requests sent from the source.
+	Requests(requests <-chan *ssh.Request) <-chan *ssh.Request
+
+	// Input wraps the writer that source data is copied to.
+	Input(w io.Writer) io.Writer
+
+	// Output wraps the writer that target data is copied to.
+	Output(w io.Writer) io.Writer
+
+	// Close is called once data no longer flows in either direction.
+	Close()
+}
+
+type channelInterceptors []ChannelInterceptor
+
+func openChannel(logger lager.Logger, interceptors []Interceptor, newChannel ssh.NewChannel) ([]byte, error) {
+	extraData := newChannel.ExtraData()
+	for _, interceptor := range interceptors {
+		var err error
+		extraData, err = interceptor.OpenChannel(logger, newChannel.ChannelType(), extraData)
+		if _, ok := err.(*ssh.OpenChannelError); err != nil && !ok {
+			return nil, &ssh.OpenChannelError{Reason: ssh.Prohibited, Message: err.Error()}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return extraData, nil
+}
+
+func channelOpened(logger lager.Logger, interceptors []Interceptor, channelType string, extraData []byte) channelInterceptors {
+	channels := channelInterceptors{}
+	for _, interceptor := range interceptors {
+		if channel := interceptor.ChannelOpened(logger, channelType, extraData); channel != nil {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+func globalRequest(logger lager.Logger, interceptors []Interceptor, req *ssh.Request) error {
+	for _, interceptor := range interceptors {
+		if err := interceptor.GlobalRequest(logger, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c channelInterceptors) Requests(requests <-chan *ssh.Request) <-chan *ssh.Request {
+	for _, channel := range c {
+		requests = channel.Requests(requests)
+	}
+	return requests
+}
+
+func (c channelInterceptors) Input(w io.Writer) io.Writer {
+	for i := len(c) - 1; i >= 0; i-- {
+		w = c[i].Input(w)
+	}
+	return w
+}
+
+func (c channelInterceptors) Output(w io.Writer) io.Writer {
+	for i := len(c) - 1; i >= 0; i-- {
+		w = c[i].Output(w)
+	}
+	return w
+}
+
+func (c channelInterceptors) Close() {
+	for _, channel := range c {
+		channel.Close()
+	}
+}
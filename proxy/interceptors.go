@@ -0,0 +1,320 @@
+package proxy
+
+import (
+	"io"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/audit"
+	"github.com/cloudfoundry-incubator/diego-ssh/bandwidth"
+	"github.com/cloudfoundry-incubator/diego-ssh/identity"
+	"github.com/cloudfoundry-incubator/diego-ssh/recording"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+func NewPolicyInterceptor(policy *Policy) Interceptor {
+	return &policyInterceptor{policy: policy}
+}
+
+type policyInterceptor struct {
+	policy *Policy
+}
+
+func (i *policyInterceptor) OpenChannel(logger lager.Logger, channelType string, extraData []byte) ([]byte, error) {
+	if !i.policy.AllowsChannel(channelType, extraData) {
+		logger.Info("channel-denied", lager.Data{"channelType": channelType})
+		return nil, &ssh.OpenChannelError{Reason: ssh.Prohibited, Message: "channel not permitted by policy"}
+	}
+	return extraData, nil
+}
+
+func (i *policyInterceptor) ChannelOpened(logger lager.Logger, channelType string, extraData []byte) ChannelInterceptor {
+	return &channelObserver{
+		requests: func(requests <-chan *ssh.Request) <-chan *ssh.Request {
+			return i.policy.Requests(logger, requests)
+		},
+	}
+}
+
+func (i *policyInterceptor) GlobalRequest(logger lager.Logger, req *ssh.Request) error {
+	if !i.policy.AllowsGlobalRequest(req.Type) {
+		logger.Info("global-request-denied", lager.Data{"type": req.Type})
+		return GlobalRequestDeniedErr
+	}
+	return nil
+}
+
+func NewRecordingInterceptor(sink recording.Sink) Interceptor {
+	return &recordingInterceptor{sink: sink}
+}
+
+type recordingInterceptor struct {
+	sink recording.Sink
+}
+
+func (i *recordingInterceptor) OpenChannel(logger lager.Logger, channelType string, extraData []byte) ([]byte, error) {
+	return extraData, nil
+}
+
+func (i *recordingInterceptor) ChannelOpened(logger lager.Logger, channelType string, extraData []byte) ChannelInterceptor {
+	if channelType != "session" {
+		return nil
+	}
+
+	tap := recording.NewSessionTap(logger, i.sink)
+
+	return &channelObserver{
+		requests: tap.Requests,
+		input:    tap.Input(),
+		output:   tap.Output(),
+		close:    func() { tap.Close() },
+	}
+}
+
+func (i *recordingInterceptor) GlobalRequest(logger lager.Logger, req *ssh.Request) error {
+	return nil
+}
+
+func NewAuditInterceptor(session *audit.Session) Interceptor {
+	return &auditInterceptor{session: session}
+}
+
+type auditInterceptor struct {
+	session *audit.Session
+}
+
+func (i *auditInterceptor) OpenChannel(logger lager.Logger, channelType string, extraData []byte) ([]byte, error) {
+	return extraData, nil
+}
+
+func (i *auditInterceptor) ChannelOpened(logger lager.Logger, channelType string, extraData []byte) ChannelInterceptor {
+	channel := i.session.Channel(channelType, extraData)
+
+	return &channelObserver{
+		requests: channel.Requests,
+		input:    channel.Input(),
+		output:   channel.Output(),
+		close:    channel.Close,
+	}
+}
+
+func (i *auditInterceptor) GlobalRequest(logger lager.Logger, req *ssh.Request) error {
+	return nil
+}
+
+func NewAppLogInterceptor(appLogger *AppLogger) Interceptor {
+	return &appLogInterceptor{appLogger: appLogger}
+}
+
+type appLogInterceptor struct {
+	appLogger *AppLogger
+}
+
+func (i *appLogInterceptor) OpenChannel(logger lager.Logger, channelType string, extraData []byte) ([]byte, error) {
+	return extraData, nil
+}
+
+func (i *appLogInterceptor) ChannelOpened(logger lager.Logger, channelType string, extraData []byte) ChannelInterceptor {
+	return &channelObserver{requests: i.appLogger.Requests}
+}
+
+func (i *appLogInterceptor) GlobalRequest(logger lager.Logger, req *ssh.Request) error {
+	return nil
+}
+
+// NewTraceInterceptor passes the trace id of the login to the daemon as the
+// first environment request of every session channel.
+func NewTraceInterceptor(traceID string) Interceptor {
+	return &traceInterceptor{traceID: traceID}
+}
+
+type traceInterceptor struct {
+	traceID string
+}
+
+func (i *traceInterceptor) OpenChannel(logger lager.Logger, channelType string, extraData []byte) ([]byte, error) {
+	return extraData, nil
+}
+
+func (i *traceInterceptor) ChannelOpened(logger lager.Logger, channelType string, extraData []byte) ChannelInterceptor {
+	if channelType != "session" {
+		return nil
+	}
+
+	return &channelObserver{
+		requests: func(requests <-chan *ssh.Request) <-chan *ssh.Request {
+			traced := make(chan *ssh.Request)
+			go func() {
+				defer close(traced)
+
+				traced <- &ssh.Request{
+					Type: "env",
+					Payload: ssh.Marshal(struct {
+						Name  string
+						Value string
+					}{tracing.TRACE_ID_ENV, i.traceID}),
+				}
+
+				for req := range requests {
+					traced <- req
+				}
+			}()
+			return traced
+		},
+	}
+}
+
+func (i *traceInterceptor) GlobalRequest(logger lager.Logger, req *ssh.Request) error {
+	return nil
+}
+
+// NewIdentityInterceptor passes the authenticated user to the daemon as
+// environment variables of each session. Clients cannot set the variables
+// themselves.
+func NewIdentityInterceptor(id identity.Identity) Interceptor {
+	return &identityInterceptor{identity: id}
+}
+
+type identityInterceptor struct {
+	identity identity.Identity
+}
+
+func (i *identityInterceptor) OpenChannel(logger lager.Logger, channelType string, extraData []byte) ([]byte, error) {
+	return extraData, nil
+}
+
+func (i *identityInterceptor) ChannelOpened(logger lager.Logger, channelType string, extraData []byte) ChannelInterceptor {
+	if channelType != "session" {
+		return nil
+	}
+
+	return &channelObserver{
+		requests: func(requests <-chan *ssh.Request) <-chan *ssh.Request {
+			identified := make(chan *ssh.Request)
+			go func() {
+				defer close(identified)
+
+				env := i.identity.Env()
+				for _, name := range identity.EnvNames {
+					if env[name] == "" {
+						continue
+					}
+					identified <- &ssh.Request{
+						Type: "env",
+						Payload: ssh.Marshal(struct {
+							Name  string
+							Value string
+						}{name, env[name]}),
+					}
+				}
+
+				for req := range requests {
+					if req.Type == "env" && identityEnv(req.Payload) {
+						logger.Info("identity-env-rejected")
+						if req.WantReply {
+							req.Reply(false, nil)
+						}
+						continue
+					}
+					identified <- req
+				}
+			}()
+			return identified
+		},
+	}
+}
+
+func (i *identityInterceptor) GlobalRequest(logger lager.Logger, req *ssh.Request) error {
+	return nil
+}
+
+func identityEnv(payload []byte) bool {
+	var env struct {
+		Name  string
+		Value string
+	}
+	if ssh.Unmarshal(payload, &env) != nil {
+		return false
+	}
+	return identity.IsEnv(env.Name)
+}
+
+// NewBandwidthInterceptor limits the channel data of the connections it is
+// used for to the rate of every bucket and to the quota.
+func NewBandwidthInterceptor(quota *bandwidth.Quota, buckets ...*bandwidth.Bucket) Interceptor {
+	return &bandwidthInterceptor{quota: quota, buckets: buckets}
+}
+
+type bandwidthInterceptor struct {
+	quota   *bandwidth.Quota
+	buckets []*bandwidth.Bucket
+}
+
+func (i *bandwidthInterceptor) OpenChannel(logger lager.Logger, channelType string, extraData []byte) ([]byte, error) {
+	if i.quota.Exhausted() {
+		return nil, &ssh.OpenChannelError{Reason: ssh.ResourceShortage, Message: bandwidth.QuotaExceededErr.Error()}
+	}
+	return extraData, nil
+}
+
+func (i *bandwidthInterceptor) ChannelOpened(logger lager.Logger, channelType string, extraData []byte) ChannelInterceptor {
+	return &throttledChannel{interceptor: i}
+}
+
+func (i *bandwidthInterceptor) GlobalRequest(logger lager.Logger, req *ssh.Request) error {
+	return nil
+}
+
+type throttledChannel struct {
+	interceptor *bandwidthInterceptor
+}
+
+func (c *throttledChannel) Requests(requests <-chan *ssh.Request) <-chan *ssh.Request {
+	return requests
+}
+
+func (c *throttledChannel) Input(w io.Writer) io.Writer {
+	return bandwidth.NewWriter(w, c.interceptor.quota, c.interceptor.buckets...)
+}
+
+func (c *throttledChannel) Output(w io.Writer) io.Writer {
+	return bandwidth.NewWriter(w, c.interceptor.quota, c.interceptor.buckets...)
+}
+
+func (c *throttledChannel) Close() {}
+
+// channelObserver adapts request filters and data observers to a
+// ChannelInterceptor. Observers see data before it is written on.
+type channelObserver struct {
+	requests func(<-chan *ssh.Request) <-chan *ssh.Request
+	input    io.Writer
+	output   io.Writer
+	close    func()
+}
+
+func (o *channelObserver) Requests(requests <-chan *ssh.Request) <-chan *ssh.Request {
+	if o.requests == nil {
+		return requests
+	}
+	return o.requests(requests)
+}
+
+func (o *channelObserver) Input(w io.Writer) io.Writer {
+	if o.input == nil {
+		return w
+	}
+	return io.MultiWriter(o.input, w)
+}
+
+func (o *channelObserver) Output(w io.Writer) io.Writer {
+	if o.output == nil {
+		return w
+	}
+	return io.MultiWriter(o.output, w)
+}
+
+func (o *channelObserver) Close() {
+	if o.close != nil {
+		o.close()
+	}
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/audit"
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+const DefaultMaintenanceMessage = "The SSH proxy is down for maintenance. Please try again later."
+
+const maintenanceReason = "the proxy is going down for maintenance"
+
+var InMaintenanceErr = errors.New("the proxy is in maintenance")
+
+// MaintenanceStatus describes whether the proxy is refusing new logins.
+type MaintenanceStatus struct {
+	Enabled       bool       `json:"enabled"`
+	Message       string     `json:"message,omitempty"`
+	DrainDeadline *time.Time `json:"drain_deadline,omitempty"`
+}
+
+func (s MaintenanceStatus) drainDeadline() time.Time {
+	if s.DrainDeadline == nil {
+		return time.Time{}
+	}
+	return *s.DrainDeadline
+}
+
+// EnterMaintenance refuses new logins with the message. When drain is
+// positive, sessions in progress are warned and closed once it has passed;
+// otherwise they continue until they end.
+func (p *Proxy) EnterMaintenance(message string, drain time.Duration) {
+	if message == "" {
+		message = DefaultMaintenanceMessage
+	}
+
+	status := MaintenanceStatus{Enabled: true, Message: message}
+	if drain > 0 {
+		deadline := time.Now().Add(drain)
+		status.DrainDeadline = &deadline
+	}
+
+	p.maintenanceLock.Lock()
+	p.maintenance = status
+	timers := p.activeTimers()
+	p.maintenanceLock.Unlock()
+
+	for _, timer := range timers {
+		timer.Drain(status.drainDeadline(), maintenanceReason)
+	}
+
+	p.logger.Info("entered-maintenance", lager.Data{"message": message, "drain": drain.String()})
+}
+
+// ExitMaintenance accepts logins again and cancels any pending drain.
+func (p *Proxy) ExitMaintenance() {
+	p.maintenanceLock.Lock()
+	p.maintenance = MaintenanceStatus{}
+	timers := p.activeTimers()
+	p.maintenanceLock.Unlock()
+
+	for _, timer := range timers {
+		timer.Drain(time.Time{}, "")
+	}
+
+	p.logger.Info("exited-maintenance")
+}
+
+func (p *Proxy) Maintenance() MaintenanceStatus {
+	p.maintenanceLock.Lock()
+	defer p.maintenanceLock.Unlock()
+
+	return p.maintenance
+}
+
+// trackTimer makes the timer subject to maintenance drains until the returned
+// function is called.
+func (p *Proxy) trackTimer(timer *SessionTimer) func() {
+	p.maintenanceLock.Lock()
+	p.timers[timer] = struct{}{}
+	status := p.maintenance
+	p.maintenanceLock.Unlock()
+
+	if status.DrainDeadline != nil {
+		timer.Drain(*status.DrainDeadline, maintenanceReason)
+	}
+
+	return func() {
+		p.maintenanceLock.Lock()
+		delete(p.timers, timer)
+		p.maintenanceLock.Unlock()
+	}
+}
+
+func (p *Proxy) activeTimers() []*SessionTimer {
+	timers := make([]*SessionTimer, 0, len(p.timers))
+	for timer := range p.timers {
+		timers = append(timers, timer)
+	}
+	return timers
+}
+
+// maintenanceConfig refuses every login without contacting the
+// authentication backends and shows the message to clients as the
+// authentication banner. Refusals are audited as failed authentications.
+func maintenanceConfig(serverConfig *ssh.ServerConfig, message string, auditor *audit.Auditor) *ssh.ServerConfig {
+	config := *serverConfig
+	config.NoClientAuth = false
+	config.BannerCallback = func(ssh.ConnMetadata) string {
+		return message + "\r\n"
+	}
+	config.PasswordCallback = auditor.PasswordCallback(func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+		return nil, InMaintenanceErr
+	})
+	if config.PublicKeyCallback != nil {
+		config.PublicKeyCallback = auditor.PublicKeyCallback(func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, InMaintenanceErr
+		})
+	}
+	config.KeyboardInteractiveCallback = nil
+	config.AuthLogCallback = nil
+	return &config
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"github.com/cloudfoundry-incubator/diego-ssh/stats"
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	activeConnections   = stats.NewGauge("ssh_proxy_active_connections", "ActiveConnections", "Metric", "Client connections currently handled by the proxy")
+	handshakeFailures   = stats.NewCounter("ssh_proxy_handshake_failures_total", "HandshakeFailures", "Client connections that failed the ssh handshake")
+	targetDialFailures  = stats.NewCounter("ssh_proxy_target_dial_failures_total", "TargetDialFailures", "Connections to target daemons that could not be established")
+	maintenanceRefusals = stats.NewCounter("ssh_proxy_maintenance_refusals_total", "MaintenanceRefusals", "Logins refused while the proxy was in maintenance")
+	channelOpens        = stats.NewCounter("ssh_proxy_channel_opens_total", "ChannelOpens", "Channels opened on targets by type", "type")
+	bytesProxied        = stats.NewCounter("ssh_proxy_bytes_total", "BytesProxied", "Channel data proxied by direction", "direction")
+)
+
+// metricsInterceptor counts the channels and data of proxied connections.
+type metricsInterceptor struct{}
+
+func (metricsInterceptor) OpenChannel(logger lager.Logger, channelType string, extraData []byte) ([]byte, error) {
+	return extraData, nil
+}
+
+func (metricsInterceptor) ChannelOpened(logger lager.Logger, channelType string, extraData []byte) ChannelInterceptor {
+	channelOpens.Inc(channelType)
+
+	return &channelObserver{
+		input:  &counterWriter{counter: bytesProxied, direction: "in"},
+		output: &counterWriter{counter: bytesProxied, direction: "out"},
+	}
+}
+
+func (metricsInterceptor) GlobalRequest(logger lager.Logger, req *ssh.Request) error {
+	return nil
+}
+
+type counterWriter struct {
+	counter   *stats.Counter
+	direction string
+}
+
+func (w *counterWriter) Write(p []byte) (int, error) {
+	w.counter.Add(uint64(len(p)), w.direction)
+	return len(p), nil
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+// NextHop is a proxy that can reach targets this proxy cannot, such as the
+// proxy of an isolation segment.
+type NextHop struct {
+	Address         string `json:"address"`
+	HostFingerprint string `json:"host_fingerprint,omitempty"`
+	PrivateKey      string `json:"private_key"`
+}
+
+// HopOrigin identifies the connection a chain of proxies started from.
+type HopOrigin struct {
+	User       string `json:"user"`
+	RemoteAddr string `json:"remote_addr"`
+	SessionID  string `json:"session_id"`
+}
+
+// HopCriticalOptions are the options a next hop accepts in the certificates
+// presented by the proxies that forward to it.
+var HopCriticalOptions = []string{"proxy-target-config", "log-message", "user-identity", "proxy-hop-origin"}
+
+// forwardedOptions are the critical options of a login passed on to the next
+// hop as they are.
+var forwardedOptions = []string{"log-message", "user-identity"}
+
+// hopCertificateLifetime bounds the clock skew tolerated between hops and
+// how long a certificate can be replayed.
+const hopCertificateLifetime = time.Minute
+
+func HopOriginFromPermissions(permissions *ssh.Permissions) (*HopOrigin, error) {
+	if permissions == nil || permissions.CriticalOptions["proxy-hop-origin"] == "" {
+		return nil, nil
+	}
+
+	origin := &HopOrigin{}
+	err := json.Unmarshal([]byte(permissions.CriticalOptions["proxy-hop-origin"]), origin)
+	if err != nil {
+		return nil, err
+	}
+
+	return origin, nil
+}
+
+func connectionOrigin(serverConn *ssh.ServerConn) *HopOrigin {
+	origin := &HopOrigin{
+		User:      serverConn.User(),
+		SessionID: hex.EncodeToString(serverConn.SessionID()),
+	}
+
+	if serverConn.RemoteAddr() != nil {
+		origin.RemoteAddr = serverConn.RemoteAddr().String()
+	}
+
+	return origin
+}
+
+// hopClientConfig authenticates to the next hop with a short lived
+// certificate signed by the hop key. The certificate carries the target, the
+// forwarded options and the origin of the connection so the next hop can
+// complete the session without authenticating the user again.
+func hopClientConfig(
+	logger lager.Logger,
+	targetConfig TargetConfig,
+	options map[string]string,
+	origin *HopOrigin,
+	dialConfig DialConfig,
+) (*ssh.ClientConfig, error) {
+	nextHop := targetConfig.NextHop
+
+	key, err := ssh.ParsePrivateKey([]byte(nextHop.PrivateKey))
+	if err != nil {
+		logger.Error("parsing-hop-key-failed", err)
+		return nil, err
+	}
+
+	targetConfig.NextHop = nil
+	targetConfigJson, err := json.Marshal(targetConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	originJson, err := json.Marshal(origin)
+	if err != nil {
+		return nil, err
+	}
+
+	criticalOptions := map[string]string{
+		"proxy-target-config": string(targetConfigJson),
+		"proxy-hop-origin":    string(originJson),
+	}
+	for _, option := range forwardedOptions {
+		if options[option] != "" {
+			criticalOptions[option] = options[option]
+		}
+	}
+
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             key.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           origin.SessionID,
+		ValidPrincipals: []string{origin.User},
+		ValidAfter:      uint64(now.Add(-hopCertificateLifetime).Unix()),
+		ValidBefore:     uint64(now.Add(hopCertificateLifetime).Unix()),
+		Permissions:     ssh.Permissions{CriticalOptions: criticalOptions},
+	}
+
+	err = cert.SignCert(rand.Reader, key)
+	if err != nil {
+		logger.Error("signing-hop-certificate-failed", err)
+		return nil, err
+	}
+
+	signer, err := ssh.NewCertSigner(cert, key)
+	if err != nil {
+		logger.Error("signing-hop-certificate-failed", err)
+		return nil, err
+	}
+
+	clientConfig := &ssh.ClientConfig{
+		User: origin.User,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+	}
+	dialConfig.Algorithms.Apply(&clientConfig.Config)
+
+	if nextHop.HostFingerprint != "" {
+		clientConfig.HostKeyCallback = fingerprintCallback(logger, nextHop.HostFingerprint)
+	}
+
+	return clientConfig, nil
+}
+
+// dialAddress returns the address and client configuration used to reach the
+// target, either directly or through its next hop.
+func dialAddress(
+	logger lager.Logger,
+	targetConfig TargetConfig,
+	options map[string]string,
+	origin *HopOrigin,
+	dialConfig DialConfig,
+) (string, *ssh.ClientConfig, error) {
+	if targetConfig.NextHop == nil {
+		clientConfig, err := targetClientConfig(logger, targetConfig, dialConfig)
+		return targetConfig.Address, clientConfig, err
+	}
+
+	clientConfig, err := hopClientConfig(logger, targetConfig, options, origin, dialConfig)
+	return targetConfig.NextHop.Address, clientConfig, err
+}
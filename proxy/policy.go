@@ -0,0 +1,420 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+var GlobalRequestDeniedErr = errors.New("global request not permitted by policy")
+
+// Policy restricts what an authenticated user may do through the proxy. An
+// empty allow list allows everything that is not explicitly denied. A nil
+// policy allows everything.
+type Policy struct {
+	AllowedChannelTypes []string `json:"allowed_channel_types,omitempty"`
+	DeniedChannelTypes  []string `json:"denied_channel_types,omitempty"`
+
+	AllowedRequestTypes []string `json:"allowed_request_types,omitempty"`
+	DeniedRequestTypes  []string `json:"denied_request_types,omitempty"`
+
+	AllowedGlobalRequestTypes []string `json:"allowed_global_request_types,omitempty"`
+	DeniedGlobalRequestTypes  []string `json:"denied_global_request_types,omitempty"`
+
+	AllowedForwardDestinations []string `json:"allowed_forward_destinations,omitempty"`
+	DeniedForwardDestinations  []string `json:"denied_forward_destinations,omitempty"`
+
+	// RateLimit is the number of bytes per second of channel data allowed on
+	// a connection. TransferQuota is the total number of bytes a connection
+	// may transfer. Zero is unlimited.
+	RateLimit     int64 `json:"rate_limit,omitempty"`
+	TransferQuota int64 `json:"transfer_quota,omitempty"`
+}
+
+func LoadPolicies(path string) (map[string]*Policy, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	policies := map[string]*Policy{}
+	err = json.NewDecoder(file).Decode(&policies)
+	if err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+func PolicyFromPermissions(permissions *ssh.Permissions) (*Policy, error) {
+	if permissions == nil || permissions.CriticalOptions["proxy-policy"] == "" {
+		return nil, nil
+	}
+
+	var policy Policy
+	err := json.Unmarshal([]byte(permissions.CriticalOptions["proxy-policy"]), &policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// Merge returns a policy that only allows what both policies allow.
+func (p *Policy) Merge(other *Policy) *Policy {
+	if p == nil {
+		return other
+	}
+	if other == nil {
+		return p
+	}
+
+	merged := &Policy{
+		RateLimit:     lowest(p.RateLimit, other.RateLimit),
+		TransferQuota: lowest(p.TransferQuota, other.TransferQuota),
+	}
+
+	merged.AllowedChannelTypes, merged.DeniedChannelTypes = mergeRules(
+		p.AllowedChannelTypes, other.AllowedChannelTypes,
+		p.DeniedChannelTypes, other.DeniedChannelTypes,
+		meetType, anyType,
+	)
+	merged.AllowedRequestTypes, merged.DeniedRequestTypes = mergeRules(
+		p.AllowedRequestTypes, other.AllowedRequestTypes,
+		p.DeniedRequestTypes, other.DeniedRequestTypes,
+		meetType, anyType,
+	)
+	merged.AllowedGlobalRequestTypes, merged.DeniedGlobalRequestTypes = mergeRules(
+		p.AllowedGlobalRequestTypes, other.AllowedGlobalRequestTypes,
+		p.DeniedGlobalRequestTypes, other.DeniedGlobalRequestTypes,
+		meetType, anyType,
+	)
+	merged.AllowedForwardDestinations, merged.DeniedForwardDestinations = mergeRules(
+		p.AllowedForwardDestinations, other.AllowedForwardDestinations,
+		p.DeniedForwardDestinations, other.DeniedForwardDestinations,
+		meetDestination, anyDestination,
+	)
+
+	return merged
+}
+
+func (p *Policy) AllowsChannel(channelType string, extraData []byte) bool {
+	if p == nil {
+		return true
+	}
+
+	if !allowed(channelType, p.AllowedChannelTypes, p.DeniedChannelTypes, matchType) {
+		return false
+	}
+
+	if channelType == "direct-tcpip" {
+		var forward struct {
+			Host           string
+			Port           uint32
+			OriginatorHost string
+			OriginatorPort uint32
+		}
+		if ssh.Unmarshal(extraData, &forward) != nil {
+			return false
+		}
+
+		destination := fmt.Sprintf("%s:%d", forward.Host, forward.Port)
+		return allowed(destination, p.AllowedForwardDestinations, p.DeniedForwardDestinations, matchDestination)
+	}
+
+	return true
+}
+
+func (p *Policy) AllowsRequest(requestType string) bool {
+	if p == nil {
+		return true
+	}
+
+	return allowed(requestType, p.AllowedRequestTypes, p.DeniedRequestTypes, matchType)
+}
+
+// AllowsGlobalRequest checks global requests such as tcpip-forward. A remote
+// forward is also denied when the forwarded-tcpip channels it would open
+// from the target are not allowed.
+func (p *Policy) AllowsGlobalRequest(requestType string) bool {
+	if p == nil {
+		return true
+	}
+
+	if requestType == "tcpip-forward" && !allowed("forwarded-tcpip", p.AllowedChannelTypes, p.DeniedChannelTypes, matchType) {
+		return false
+	}
+
+	return allowed(requestType, p.AllowedGlobalRequestTypes, p.DeniedGlobalRequestTypes, matchType)
+}
+
+// Requests rejects the channel requests denied by the policy and passes the
+// rest on.
+func (p *Policy) Requests(logger lager.Logger, requests <-chan *ssh.Request) <-chan *ssh.Request {
+	if p == nil {
+		return requests
+	}
+
+	permitted := make(chan *ssh.Request)
+
+	go func() {
+		defer close(permitted)
+		for req := range requests {
+			if !p.AllowsRequest(req.Type) {
+				logger.Info("request-denied", lager.Data{"type": req.Type})
+				if req.WantReply {
+					req.Reply(false, nil)
+				}
+				continue
+			}
+			permitted <- req
+		}
+	}()
+
+	return permitted
+}
+
+func allowed(value string, allow, deny []string, match func(pattern, value string) bool) bool {
+	for _, pattern := range deny {
+		if match(pattern, value) {
+			return false
+		}
+	}
+
+	if len(allow) == 0 {
+		return true
+	}
+
+	for _, pattern := range allow {
+		if match(pattern, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+const (
+	anyType        = "*"
+	anyDestination = "*:*"
+)
+
+// matchType matches channel and request types. The pattern * matches every
+// type.
+func matchType(pattern, value string) bool {
+	return pattern == anyType || pattern == value
+}
+
+// matchDestination matches host:port destinations against a pattern in which
+// either part may be the wildcard *. Hosts are compared in canonical form.
+func matchDestination(pattern, destination string) bool {
+	patternHost, patternPort := splitDestination(pattern)
+	host, port := splitDestination(destination)
+
+	if patternHost != "*" && patternHost != host {
+		return false
+	}
+
+	if !validPort(patternPort) {
+		return false
+	}
+
+	return patternPort == "*" || patternPort == port
+}
+
+// splitDestination returns the canonical host and port of a destination or
+// pattern, so that the different names of one address compare equal.
+func splitDestination(destination string) (string, string) {
+	host, port := destination, "*"
+	if i := strings.LastIndex(destination, ":"); i >= 0 {
+		host, port = destination[:i], destination[i+1:]
+	}
+
+	if number, err := strconv.Atoi(port); err == nil {
+		port = strconv.Itoa(number)
+	}
+
+	return canonicalHost(host), port
+}
+
+var localhostNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+}
+
+// canonicalHost lower cases names, removes IPv6 brackets and zones, turns the
+// numeric IPv4 forms the resolver accepts, such as 127.1 and 2130706433, into
+// dotted quads, and names every loopback and unspecified address localhost.
+// Names that only resolve to an address through DNS are not recognized.
+func canonicalHost(host string) string {
+	if host == "*" {
+		return host
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	if i := strings.LastIndex(host, "%"); i >= 0 && strings.Contains(host, ":") {
+		host = host[:i]
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip = parseNumericIPv4(host)
+	}
+	if ip != nil {
+		if ip.IsLoopback() || ip.IsUnspecified() {
+			return "localhost"
+		}
+		return ip.String()
+	}
+
+	if localhostNames[host] || strings.HasSuffix(host, ".localhost") {
+		return "localhost"
+	}
+
+	return host
+}
+
+// parseNumericIPv4 parses the forms of inet_aton: one to four decimal, octal,
+// or hexadecimal parts where the last part fills the remaining bytes.
+func parseNumericIPv4(host string) net.IP {
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+
+	var address uint64
+	for i, part := range parts {
+		base := 10
+		switch {
+		case strings.HasPrefix(part, "0x"):
+			base, part = 16, part[2:]
+		case len(part) > 1 && part[0] == '0':
+			base, part = 8, part[1:]
+		}
+		if part == "" || strings.ContainsAny(part, "+-_") {
+			return nil
+		}
+
+		bits := uint(8)
+		if i == len(parts)-1 {
+			bits = uint(8 * (4 - i))
+		}
+
+		value, err := strconv.ParseUint(part, base, int(bits))
+		if err != nil {
+			return nil
+		}
+		address = address<<bits | value
+	}
+
+	return net.IPv4(byte(address>>24), byte(address>>16), byte(address>>8), byte(address))
+}
+
+// lowest returns the lower of two limits where zero is unlimited.
+func lowest(a, b int64) int64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// mergeRules returns allow and deny lists that only allow what both sets of
+// lists allow. When the allow lists have nothing in common, everything is
+// denied.
+func mergeRules(allowA, allowB, denyA, denyB []string, meet func(a, b string) (string, bool), any string) ([]string, []string) {
+	deny := append(append([]string{}, denyA...), denyB...)
+
+	allow, ok := intersect(allowA, allowB, meet)
+	if !ok {
+		return nil, append(deny, any)
+	}
+
+	return allow, deny
+}
+
+// intersect returns the patterns that match what a pattern of each list
+// matches. An empty list allows everything. It returns false when two
+// non-empty lists have nothing in common.
+func intersect(a, b []string, meet func(a, b string) (string, bool)) ([]string, bool) {
+	if len(a) == 0 {
+		return b, true
+	}
+	if len(b) == 0 {
+		return a, true
+	}
+
+	result := []string{}
+	for _, x := range a {
+		for _, y := range b {
+			if pattern, ok := meet(x, y); ok {
+				result = append(result, pattern)
+			}
+		}
+	}
+
+	return result, len(result) > 0
+}
+
+func meetType(a, b string) (string, bool) {
+	switch {
+	case a == anyType:
+		return b, true
+	case b == anyType || a == b:
+		return a, true
+	}
+	return "", false
+}
+
+// meetDestination returns the pattern of the destinations matched by both
+// patterns, e.g. 10.0.0.1:22 for *:22 and 10.0.0.1:*.
+func meetDestination(a, b string) (string, bool) {
+	aHost, aPort := splitDestination(a)
+	bHost, bPort := splitDestination(b)
+
+	host, ok := meetPart(aHost, bHost)
+	if !ok {
+		return "", false
+	}
+
+	if !validPort(aPort) || !validPort(bPort) {
+		return "", false
+	}
+
+	port, ok := meetPart(aPort, bPort)
+	if !ok {
+		return "", false
+	}
+
+	return host + ":" + port, true
+}
+
+func meetPart(a, b string) (string, bool) {
+	switch {
+	case a == "*":
+		return b, true
+	case b == "*" || a == b:
+		return a, true
+	}
+	return "", false
+}
+
+func validPort(port string) bool {
+	if port == "*" {
+		return true
+	}
+	_, err := strconv.Atoi(port)
+	return err == nil
+}
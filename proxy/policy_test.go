@@ -0,0 +1,215 @@
+package proxy_test
+
+import (
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+)
+
+var _ = Describe("Policy", func() {
+	var policy *proxy.Policy
+
+	forward := func(host string, port uint32) []byte {
+		return ssh.Marshal(struct {
+			Host           string
+			Port           uint32
+			OriginatorHost string
+			OriginatorPort uint32
+		}{host, port, "127.0.0.1", 1234})
+	}
+
+	Context("when the policy is nil", func() {
+		It("allows everything", func() {
+			Expect(policy.AllowsChannel("direct-tcpip", forward("10.0.0.1", 22))).To(BeTrue())
+			Expect(policy.AllowsRequest("exec")).To(BeTrue())
+			Expect(policy.AllowsGlobalRequest("tcpip-forward")).To(BeTrue())
+		})
+	})
+
+	Context("when channel types are restricted", func() {
+		BeforeEach(func() {
+			policy = &proxy.Policy{
+				AllowedChannelTypes: []string{"session", "direct-tcpip"},
+				DeniedChannelTypes:  []string{"direct-tcpip"},
+			}
+		})
+
+		It("only allows channel types that are allowed and not denied", func() {
+			Expect(policy.AllowsChannel("session", nil)).To(BeTrue())
+			Expect(policy.AllowsChannel("direct-tcpip", forward("localhost", 8080))).To(BeFalse())
+			Expect(policy.AllowsChannel("x11", nil)).To(BeFalse())
+		})
+	})
+
+	Context("when forwarding destinations are restricted", func() {
+		BeforeEach(func() {
+			policy = &proxy.Policy{
+				AllowedForwardDestinations: []string{"localhost:*", "*:8080"},
+				DeniedForwardDestinations:  []string{"localhost:22"},
+			}
+		})
+
+		It("matches the destination against the patterns", func() {
+			Expect(policy.AllowsChannel("direct-tcpip", forward("localhost", 9000))).To(BeTrue())
+			Expect(policy.AllowsChannel("direct-tcpip", forward("10.0.0.1", 8080))).To(BeTrue())
+			Expect(policy.AllowsChannel("direct-tcpip", forward("localhost", 22))).To(BeFalse())
+			Expect(policy.AllowsChannel("direct-tcpip", forward("10.0.0.1", 22))).To(BeFalse())
+		})
+
+		It("denies malformed forwarding requests", func() {
+			Expect(policy.AllowsChannel("direct-tcpip", []byte("garbage"))).To(BeFalse())
+		})
+	})
+
+	Context("when a loopback destination is denied", func() {
+		BeforeEach(func() {
+			policy = &proxy.Policy{DeniedForwardDestinations: []string{"localhost:5432"}}
+		})
+
+		It("denies the other names of the loopback address", func() {
+			for _, host := range []string{
+				"localhost", "LOCALHOST", "localhost.", "db.localhost", "ip6-localhost",
+				"127.0.0.1", "127.0.1.1", "127.1", "2130706433", "0x7f000001", "0177.0.0.1",
+				"::1", "[::1]", "[0:0:0:0:0:0:0:1]", "::ffff:127.0.0.1",
+				"0.0.0.0", "0", "::",
+			} {
+				Expect(policy.AllowsChannel("direct-tcpip", forward(host, 5432))).To(BeFalse(), host)
+			}
+		})
+
+		It("allows other destinations", func() {
+			Expect(policy.AllowsChannel("direct-tcpip", forward("localhost", 5433))).To(BeTrue())
+			Expect(policy.AllowsChannel("direct-tcpip", forward("10.0.0.1", 5432))).To(BeTrue())
+			Expect(policy.AllowsChannel("direct-tcpip", forward("localhost.example.com", 5432))).To(BeTrue())
+		})
+	})
+
+	Context("when an address is denied", func() {
+		BeforeEach(func() {
+			policy = &proxy.Policy{DeniedForwardDestinations: []string{"10.0.0.1:22", "[FE80::1]:22", "db.internal:*"}}
+		})
+
+		It("denies the other forms of the address", func() {
+			Expect(policy.AllowsChannel("direct-tcpip", forward("10.1", 22))).To(BeFalse())
+			Expect(policy.AllowsChannel("direct-tcpip", forward("012.0.0.1", 22))).To(BeFalse())
+			Expect(policy.AllowsChannel("direct-tcpip", forward("::ffff:10.0.0.1", 22))).To(BeFalse())
+			Expect(policy.AllowsChannel("direct-tcpip", forward("fe80:0::1", 22))).To(BeFalse())
+			Expect(policy.AllowsChannel("direct-tcpip", forward("fe80::1%eth0", 22))).To(BeFalse())
+			Expect(policy.AllowsChannel("direct-tcpip", forward("DB.Internal.", 5432))).To(BeFalse())
+		})
+	})
+
+	Context("when request types are restricted", func() {
+		BeforeEach(func() {
+			policy = &proxy.Policy{DeniedRequestTypes: []string{"exec", "subsystem"}}
+		})
+
+		It("denies the listed request types", func() {
+			Expect(policy.AllowsRequest("shell")).To(BeTrue())
+			Expect(policy.AllowsRequest("exec")).To(BeFalse())
+			Expect(policy.AllowsRequest("subsystem")).To(BeFalse())
+		})
+	})
+
+	Context("when global request types are restricted", func() {
+		BeforeEach(func() {
+			policy = &proxy.Policy{DeniedGlobalRequestTypes: []string{"streamlocal-forward@openssh.com"}}
+		})
+
+		It("denies the listed global request types", func() {
+			Expect(policy.AllowsGlobalRequest("keepalive@openssh.com")).To(BeTrue())
+			Expect(policy.AllowsGlobalRequest("tcpip-forward")).To(BeTrue())
+			Expect(policy.AllowsGlobalRequest("streamlocal-forward@openssh.com")).To(BeFalse())
+		})
+	})
+
+	Context("when forwarded-tcpip channels are not allowed", func() {
+		BeforeEach(func() {
+			policy = &proxy.Policy{AllowedChannelTypes: []string{"session"}}
+		})
+
+		It("denies remote forwarding requests", func() {
+			Expect(policy.AllowsGlobalRequest("tcpip-forward")).To(BeFalse())
+			Expect(policy.AllowsGlobalRequest("keepalive@openssh.com")).To(BeTrue())
+		})
+	})
+
+	Context("when * is denied", func() {
+		BeforeEach(func() {
+			policy = &proxy.Policy{DeniedRequestTypes: []string{"*"}}
+		})
+
+		It("denies every type", func() {
+			Expect(policy.AllowsRequest("shell")).To(BeFalse())
+			Expect(policy.AllowsRequest("exec")).To(BeFalse())
+		})
+	})
+
+	Describe("Merge", func() {
+		It("only allows what both policies allow", func() {
+			policy = &proxy.Policy{AllowedRequestTypes: []string{"shell", "exec"}}
+			merged := policy.Merge(&proxy.Policy{
+				AllowedRequestTypes: []string{"exec", "env"},
+				DeniedChannelTypes:  []string{"direct-tcpip"},
+			})
+
+			Expect(merged.AllowsRequest("exec")).To(BeTrue())
+			Expect(merged.AllowsRequest("shell")).To(BeFalse())
+			Expect(merged.AllowsRequest("env")).To(BeFalse())
+			Expect(merged.AllowsChannel("direct-tcpip", forward("localhost", 80))).To(BeFalse())
+		})
+
+		It("keeps the lowest bandwidth limits", func() {
+			policy = &proxy.Policy{RateLimit: 1000, TransferQuota: 0}
+			merged := policy.Merge(&proxy.Policy{RateLimit: 5000, TransferQuota: 1 << 20})
+
+			Expect(merged.RateLimit).To(BeEquivalentTo(1000))
+			Expect(merged.TransferQuota).To(BeEquivalentTo(1 << 20))
+		})
+
+		It("allows nothing when the allow lists are disjoint", func() {
+			policy = &proxy.Policy{AllowedChannelTypes: []string{"session"}}
+			merged := policy.Merge(&proxy.Policy{AllowedChannelTypes: []string{"direct-tcpip"}})
+
+			Expect(merged.AllowsChannel("session", nil)).To(BeFalse())
+			Expect(merged.AllowsChannel("direct-tcpip", forward("localhost", 80))).To(BeFalse())
+			Expect(merged.AllowsChannel("", nil)).To(BeFalse())
+		})
+
+		It("intersects wildcard destinations by the destinations they match", func() {
+			policy = &proxy.Policy{AllowedForwardDestinations: []string{"*:22", "localhost:*"}}
+			merged := policy.Merge(&proxy.Policy{AllowedForwardDestinations: []string{"10.0.0.1:*", "*:8080"}})
+
+			Expect(merged.AllowsChannel("direct-tcpip", forward("10.0.0.1", 22))).To(BeTrue())
+			Expect(merged.AllowsChannel("direct-tcpip", forward("localhost", 8080))).To(BeTrue())
+			Expect(merged.AllowsChannel("direct-tcpip", forward("10.0.0.1", 8080))).To(BeFalse())
+			Expect(merged.AllowsChannel("direct-tcpip", forward("10.0.0.2", 22))).To(BeFalse())
+			Expect(merged.AllowsChannel("direct-tcpip", forward("localhost", 9000))).To(BeFalse())
+		})
+
+		It("intersects a wildcard type with the types of the other policy", func() {
+			policy = &proxy.Policy{AllowedChannelTypes: []string{"*"}}
+			merged := policy.Merge(&proxy.Policy{AllowedChannelTypes: []string{"session"}})
+
+			Expect(merged.AllowsChannel("session", nil)).To(BeTrue())
+			Expect(merged.AllowsChannel("x11", nil)).To(BeFalse())
+		})
+
+		It("intersects the destinations of different names of one address", func() {
+			policy = &proxy.Policy{AllowedForwardDestinations: []string{"127.0.0.1:*"}}
+			merged := policy.Merge(&proxy.Policy{AllowedForwardDestinations: []string{"localhost:8080"}})
+
+			Expect(merged.AllowsChannel("direct-tcpip", forward("[::1]", 8080))).To(BeTrue())
+			Expect(merged.AllowsChannel("direct-tcpip", forward("localhost", 9000))).To(BeFalse())
+		})
+
+		It("allows no destination when the destination patterns do not overlap", func() {
+			policy = &proxy.Policy{AllowedForwardDestinations: []string{"localhost:*"}}
+			merged := policy.Merge(&proxy.Policy{AllowedForwardDestinations: []string{"10.0.0.1:*"}})
+
+			Expect(merged.AllowsChannel("direct-tcpip", forward("localhost", 80))).To(BeFalse())
+			Expect(merged.AllowsChannel("direct-tcpip", forward("", 80))).To(BeFalse())
+		})
+	})
+})
@@ -8,7 +8,13 @@ import (
 	"sync"
 	"unicode/utf8"
 
+	"github.com/cloudfoundry-incubator/diego-ssh/algorithms"
+	"github.com/cloudfoundry-incubator/diego-ssh/audit"
+	"github.com/cloudfoundry-incubator/diego-ssh/bandwidth"
 	"github.com/cloudfoundry-incubator/diego-ssh/helpers"
+	"github.com/cloudfoundry-incubator/diego-ssh/identity"
+	"github.com/cloudfoundry-incubator/diego-ssh/recording"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
 	"github.com/cloudfoundry/dropsonde/logs"
 	"github.com/pivotal-golang/lager"
 	"golang.org/x/crypto/ssh"
@@ -24,73 +30,338 @@ type TargetConfig struct {
 	User            string `json:"user,omitempty"`
 	Password        string `json:"password,omitempty"`
 	PrivateKey      string `json:"private_key,omitempty"`
+
+	// Direct targets do not run an SSH daemon; only port forwards to the
+	// declared Ports are allowed.
+	Direct bool         `json:"direct,omitempty"`
+	Ports  []DirectPort `json:"ports,omitempty"`
+
+	// NextHop is the proxy to connect through when the target cannot be
+	// reached from here.
+	NextHop *NextHop `json:"next_hop,omitempty"`
 }
 
 type LogMessage struct {
-	Guid    string `json:"guid"`
-	Message string `json:"message"`
-	Index   int    `json:"index"`
+	Guid        string `json:"guid"`
+	Message     string `json:"message"`
+	Index       int    `json:"index"`
+	SessionLogs bool   `json:"session_logs,omitempty"`
 }
 
 type Proxy struct {
-	logger       lager.Logger
-	serverConfig *ssh.ServerConfig
+	logger        lager.Logger
+	configLock    sync.RWMutex
+	serverConfig  *ssh.ServerConfig
+	hostKeys      []ssh.Signer
+	recordingSink recording.Sink
+	auditor       *audit.Auditor
+	tracer        *tracing.Tracer
+	timeouts      Timeouts
+	dialConfig    DialConfig
+	interceptors  []Interceptor
+	limiter       *SessionLimiter
+	registry      *SessionRegistry
+
+	maintenanceLock sync.Mutex
+	maintenance     MaintenanceStatus
+	timers          map[*SessionTimer]struct{}
 }
 
 func New(
 	logger lager.Logger,
 	serverConfig *ssh.ServerConfig,
+	recordingSink recording.Sink,
+	auditor *audit.Auditor,
+	tracer *tracing.Tracer,
+	timeouts Timeouts,
+	dialConfig DialConfig,
+	interceptors ...Interceptor,
 ) *Proxy {
 	return &Proxy{
-		logger:       logger,
-		serverConfig: serverConfig,
+		logger:        logger,
+		serverConfig:  serverConfig,
+		recordingSink: recordingSink,
+		auditor:       auditor,
+		tracer:        tracer,
+		timeouts:      timeouts,
+		dialConfig:    dialConfig,
+		interceptors:  interceptors,
+		limiter:       NewSessionLimiter(),
+		registry:      NewSessionRegistry(),
+		timers:        map[*SessionTimer]struct{}{},
 	}
 }
 
+func (p *Proxy) Sessions() *SessionRegistry {
+	return p.registry
+}
+
 func (p *Proxy) HandleConnection(netConn net.Conn) {
-	logger := p.logger.Session("handle-connection")
 	defer netConn.Close()
 
-	serverConn, serverChannels, serverRequests, err := ssh.NewServerConn(netConn, p.serverConfig)
+	activeConnections.Add(1)
+	defer activeConnections.Add(-1)
+
+	var err error
+	login := p.tracer.StartTrace("ssh-login")
+	defer func() { login.Finish(err) }()
+
+	logger := p.logger.Session("handle-connection")
+	if login != nil {
+		logger = p.logger.Session("handle-connection", lager.Data{"trace-id": login.ID()})
+		login.SetAttribute("remote-addr", netConn.RemoteAddr().String())
+	}
+
+	serverConfig, hostKeys, timeouts, dialConfig := p.currentSettings()
+	maintenance := p.Maintenance()
+	if maintenance.Enabled {
+		serverConfig = maintenanceConfig(serverConfig, maintenance.Message, p.auditor)
+	}
+	conn := algorithms.NewConn(netConn)
+
+	handshake := login.Child("handshake")
+	serverConn, serverChannels, serverRequests, err := ssh.NewServerConn(conn, tracedServerConfig(serverConfig, handshake))
+	handshake.Finish(err)
 	if err != nil {
+		if maintenance.Enabled {
+			logger.Info("refused-for-maintenance")
+			maintenanceRefusals.Inc()
+			return
+		}
+		handshakeFailures.Inc()
 		return
 	}
 	defer serverConn.Close()
 
-	clientConn, clientChannels, clientRequests, err := NewClientConn(logger, serverConn.Permissions)
+	algorithms.LogNegotiated(logger, conn, serverConn)
+
+	if len(hostKeys) > 0 {
+		go advertiseHostKeys(logger, serverConn, hostKeys)
+		serverRequests = proveHostKeys(logger, serverConn.SessionID(), hostKeys, serverRequests)
+	}
+
+	login.SetAttribute("user", serverConn.User())
+
+	auditSession := p.auditor.Session(serverConn)
+
+	userIdentity, err := identity.FromPermissions(serverConn.Permissions)
+	if err != nil {
+		logger.Error("invalid-user-identity", err)
+		auditSession.Failed(err)
+		return
+	}
+	if userIdentity != nil {
+		auditSession.SetIdentity(userIdentity.UserID, userIdentity.UserName, userIdentity.Realm)
+	}
+
+	policy, err := PolicyFromPermissions(serverConn.Permissions)
+	if err != nil {
+		logger.Error("invalid-policy", err)
+		auditSession.Failed(err)
+		return
+	}
+
+	limits, err := SessionLimitsFromPermissions(serverConn.Permissions)
+	if err != nil {
+		logger.Error("invalid-session-limits", err)
+		auditSession.Failed(err)
+		return
+	}
+
+	fanOutTargets, err := FanOutTargetsFromPermissions(serverConn.Permissions)
+	if err != nil {
+		logger.Error("invalid-fan-out-targets", err)
+		auditSession.Failed(err)
+		return
+	}
+
+	origin, err := HopOriginFromPermissions(serverConn.Permissions)
+	if err != nil {
+		logger.Error("invalid-hop-origin", err)
+		auditSession.Failed(err)
+		return
+	}
+
+	forwarded := origin != nil
+	if forwarded {
+		logger.Info("forwarded", lager.Data{"origin-addr": origin.RemoteAddr, "origin-session-id": origin.SessionID})
+		auditSession.SetOrigin(origin.RemoteAddr, origin.SessionID)
+	} else {
+		origin = connectionOrigin(serverConn)
+	}
+
+	if userIdentity != nil {
+		userIdentity.ClientAddr = origin.RemoteAddr
+	}
+
+	release, err := p.limiter.Acquire(limits)
 	if err != nil {
+		logger.Info("session-limit-exceeded", lager.Data{
+			"principal": limits.Principal,
+			"target":    limits.Target,
+			"reason":    err.Error(),
+		})
+		auditSession.Failed(err)
+		rejectChannels(serverChannels, serverRequests, err)
 		return
 	}
+	defer release()
+
+	logMessage := logMessageFromPermissions(logger, serverConn.Permissions)
+	instances := []int{logMessage.Index}
+
+	var clientConn ssh.Conn
+	var clientChannels <-chan ssh.NewChannel
+	var clientRequests <-chan *ssh.Request
+	if fanOutTargets != nil {
+		instances = fanOutIndexes(fanOutTargets)
+		clientConn, clientChannels, clientRequests = newFanOutConn(logger, fanOutTargets, logMessage.Guid, serverConn.Permissions.CriticalOptions, origin, dialConfig)
+	} else {
+		clientConn, clientChannels, clientRequests, err = newClientConn(logger, login.Child("dial-target"), serverConn.Permissions, origin, dialConfig)
+		if err != nil {
+			targetDialFailures.Inc()
+			auditSession.Failed(err)
+			noticeTargetFailure(serverChannels, serverRequests, TargetErrorReason(err))
+			return
+		}
+	}
 	defer clientConn.Close()
 
-	emitLogMessage(logger, serverConn.Permissions)
+	// The first proxy of a chain tells the app about the session.
+	var appLogger *AppLogger
+	if !forwarded {
+		emitLogMessage(logMessage, instances)
+		appLogger = NewAppLogger(logMessage, instances, serverConn.RemoteAddr().String())
+	}
+	defer appLogger.Ended()
+
+	if fanOutTargets != nil {
+		auditSession.SetTargets(clientConn.RemoteAddr().String(), logMessage.Guid, instances)
+	} else {
+		auditSession.SetTarget(clientConn.RemoteAddr().String(), logMessage.Guid, logMessage.Index)
+	}
+	auditSession.Started()
+	defer auditSession.Ended()
 
-	go ProxyGlobalRequests(logger, clientConn, serverRequests)
+	interceptors := p.connectionInterceptors(policy, auditSession, appLogger)
+	if login != nil {
+		interceptors = append(interceptors, NewTraceInterceptor(login.ID()))
+	}
+	if userIdentity != nil {
+		interceptors = append(interceptors, NewIdentityInterceptor(*userIdentity))
+	}
+	login.Finish(nil)
+
+	trackedSession := p.registry.Register(newSessionInfo(serverConn, clientConn, logMessage, fanOutTargets, limits), func() {
+		logger.Info("session-terminated")
+		serverConn.Close()
+		clientConn.Close()
+	})
+	defer trackedSession.Unregister()
+	interceptors = append(interceptors, trackedSession)
+
+	timer := NewSessionTimer(logger, timeouts, func() {
+		serverConn.Close()
+		clientConn.Close()
+	})
+	defer p.trackTimer(timer)()
+	interceptors = append(interceptors, timer)
+
+	done := make(chan struct{})
+	defer close(done)
+	go timer.Run(done)
+
+	go ProxyGlobalRequests(logger, clientConn, serverRequests, interceptors...)
 	go ProxyGlobalRequests(logger, serverConn, clientRequests)
 
-	go ProxyChannels(logger, clientConn, serverChannels)
+	go ProxyChannels(logger, clientConn, serverChannels, interceptors...)
 	go ProxyChannels(logger, serverConn, clientChannels)
 
 	Wait(logger, serverConn, clientConn)
 }
 
-func emitLogMessage(logger lager.Logger, perms *ssh.Permissions) {
-	logMessageJson := perms.CriticalOptions["log-message"]
-	if logMessageJson == "" {
+// tracedServerConfig hands the handshake span to the password callback so
+// authenticators can trace their work as part of the login.
+func tracedServerConfig(serverConfig *ssh.ServerConfig, span *tracing.Span) *ssh.ServerConfig {
+	if span == nil || serverConfig.PasswordCallback == nil {
+		return serverConfig
+	}
+
+	config := *serverConfig
+	config.PasswordCallback = func(metadata ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		return serverConfig.PasswordCallback(tracing.WithSpan(metadata, span), password)
+	}
+
+	return &config
+}
+
+func (p *Proxy) connectionInterceptors(policy *Policy, auditSession *audit.Session, appLogger *AppLogger) []Interceptor {
+	interceptors := []Interceptor{}
+
+	if policy != nil {
+		interceptors = append(interceptors, NewPolicyInterceptor(policy))
+
+		if policy.RateLimit > 0 || policy.TransferQuota > 0 {
+			var quota *bandwidth.Quota
+			if policy.TransferQuota > 0 {
+				quota = bandwidth.NewQuota(policy.TransferQuota)
+			}
+
+			var bucket *bandwidth.Bucket
+			if policy.RateLimit > 0 {
+				bucket = bandwidth.NewBucket(policy.RateLimit, policy.RateLimit)
+			}
+
+			interceptors = append(interceptors, NewBandwidthInterceptor(quota, bucket))
+		}
+	}
+	if p.recordingSink != nil {
+		interceptors = append(interceptors, NewRecordingInterceptor(p.recordingSink))
+	}
+	if auditSession != nil {
+		interceptors = append(interceptors, NewAuditInterceptor(auditSession))
+	}
+	if appLogger != nil {
+		interceptors = append(interceptors, NewAppLogInterceptor(appLogger))
+	}
+
+	interceptors = append(interceptors, metricsInterceptor{})
+
+	return append(interceptors, p.interceptors...)
+}
+
+// emitLogMessage tells every instance the session reaches about it.
+func emitLogMessage(logMessage *LogMessage, instances []int) {
+	if logMessage.Guid == "" && logMessage.Message == "" {
 		return
 	}
 
+	for _, index := range instances {
+		logs.SendAppLog(logMessage.Guid, logMessage.Message, "SSH", strconv.Itoa(index))
+	}
+}
+
+func logMessageFromPermissions(logger lager.Logger, perms *ssh.Permissions) *LogMessage {
 	logMessage := &LogMessage{}
+	if perms == nil {
+		return logMessage
+	}
+
+	logMessageJson := perms.CriticalOptions["log-message"]
+	if logMessageJson == "" {
+		return logMessage
+	}
+
 	err := json.Unmarshal([]byte(logMessageJson), logMessage)
 	if err != nil {
 		logger.Error("json-unmarshal-failed", err)
-		return
+		return &LogMessage{}
 	}
 
-	logs.SendAppLog(logMessage.Guid, logMessage.Message, "SSH", strconv.Itoa(logMessage.Index))
+	return logMessage
 }
 
-func ProxyGlobalRequests(logger lager.Logger, conn ssh.Conn, reqs <-chan *ssh.Request) {
+func ProxyGlobalRequests(logger lager.Logger, conn ssh.Conn, reqs <-chan *ssh.Request, interceptors ...Interceptor) {
 	logger = logger.Session("proxy-global-requests")
 
 	logger.Info("started")
@@ -100,8 +371,20 @@ func ProxyGlobalRequests(logger lager.Logger, conn ssh.Conn, reqs <-chan *ssh.Re
 		logger.Info("request", lager.Data{
 			"type":      req.Type,
 			"wantReply": req.WantReply,
-			"payload":   req.Payload,
 		})
+		logger.Debug("request-payload", lager.Data{
+			"type":    req.Type,
+			"payload": req.Payload,
+		})
+
+		if err := globalRequest(logger, interceptors, req); err != nil {
+			logger.Info("request-rejected", lager.Data{"type": req.Type, "reason": err.Error()})
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			continue
+		}
+
 		success, reply, err := conn.SendRequest(req.Type, req.WantReply, req.Payload)
 		if err != nil {
 			logger.Error("send-request-failed", err)
@@ -114,7 +397,7 @@ func ProxyGlobalRequests(logger lager.Logger, conn ssh.Conn, reqs <-chan *ssh.Re
 	}
 }
 
-func ProxyChannels(logger lager.Logger, conn ssh.Conn, channels <-chan ssh.NewChannel) {
+func ProxyChannels(logger lager.Logger, conn ssh.Conn, channels <-chan ssh.NewChannel, interceptors ...Interceptor) {
 	logger = logger.Session("proxy-channels")
 
 	logger.Info("started")
@@ -127,14 +410,15 @@ func ProxyChannels(logger lager.Logger, conn ssh.Conn, channels <-chan ssh.NewCh
 			"extraData":   newChannel.ExtraData(),
 		})
 
-		targetChan, targetReqs, err := conn.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
+		extraData, err := openChannel(logger, interceptors, newChannel)
 		if err != nil {
-			logger.Error("failed-to-open-channel", err)
-			if openErr, ok := err.(*ssh.OpenChannelError); ok {
-				newChannel.Reject(openErr.Reason, openErr.Message)
-			} else {
-				newChannel.Reject(ssh.ConnectionFailed, err.Error())
-			}
+			rejectChannel(logger, newChannel, err)
+			continue
+		}
+
+		targetChan, targetReqs, err := conn.OpenChannel(newChannel.ChannelType(), extraData)
+		if err != nil {
+			rejectChannel(logger, newChannel, err)
 			continue
 		}
 
@@ -144,21 +428,74 @@ func ProxyChannels(logger lager.Logger, conn ssh.Conn, channels <-chan ssh.NewCh
 			continue
 		}
 
+		channelInterceptors := channelOpened(logger, interceptors, newChannel.ChannelType(), extraData)
+
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+
+		outputCopied := make(chan struct{})
+
 		go func() {
-			helpers.Copy(logger.Session("to-target"), nil, targetChan, sourceChan)
+			helpers.Copy(logger.Session("to-target"), wg, channelInterceptors.Input(targetChan), sourceChan)
 			targetChan.CloseWrite()
 		}()
 		go func() {
-			helpers.Copy(logger.Session("to-source"), nil, sourceChan, targetChan)
+			stderrCopied := make(chan struct{})
+			go func() {
+				helpers.Copy(logger.Session("stderr-to-source"), nil, sourceChan.Stderr(), targetChan.Stderr())
+				close(stderrCopied)
+			}()
+
+			helpers.Copy(logger.Session("to-source"), wg, channelInterceptors.Output(sourceChan), targetChan)
+			<-stderrCopied
 			sourceChan.CloseWrite()
+			close(outputCopied)
+		}()
+		go func() {
+			wg.Wait()
+			channelInterceptors.Close()
 		}()
 
-		go ProxyRequests(logger, newChannel.ChannelType(), sourceReqs, targetChan)
-		go ProxyRequests(logger, newChannel.ChannelType(), targetReqs, sourceChan)
+		replies := &sync.RWMutex{}
+		go proxyRequests(logger, newChannel.ChannelType(), channelInterceptors.Requests(sourceReqs), targetChan, replies)
+		go ProxyRequests(logger, newChannel.ChannelType(), targetReqs, &drainedChannel{Channel: sourceChan, drained: outputCopied, replies: replies})
+	}
+}
+
+// drainedChannel delays closing the source channel until all of the target
+// output has been copied to it and the replies to its requests have been
+// sent.
+type drainedChannel struct {
+	ssh.Channel
+	drained <-chan struct{}
+	replies *sync.RWMutex
+}
+
+func (c *drainedChannel) Close() error {
+	<-c.drained
+
+	c.replies.Lock()
+	defer c.replies.Unlock()
+
+	return c.Channel.Close()
+}
+
+func rejectChannel(logger lager.Logger, newChannel ssh.NewChannel, err error) {
+	logger.Error("failed-to-open-channel", err)
+	if openErr, ok := err.(*ssh.OpenChannelError); ok {
+		newChannel.Reject(openErr.Reason, openErr.Message)
+	} else {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
 	}
 }
 
 func ProxyRequests(logger lager.Logger, channelType string, reqs <-chan *ssh.Request, channel ssh.Channel) {
+	proxyRequests(logger, channelType, reqs, channel, nil)
+}
+
+// proxyRequests holds replies for read while a request is forwarded and
+// answered so the channel the request came from is not closed first.
+func proxyRequests(logger lager.Logger, channelType string, reqs <-chan *ssh.Request, channel ssh.Channel, replies *sync.RWMutex) {
 	logger = logger.Session("proxy-requests", lager.Data{
 		"channel-type": channelType,
 	})
@@ -171,16 +508,24 @@ func ProxyRequests(logger lager.Logger, channelType string, reqs <-chan *ssh.Req
 		logger.Info("request", lager.Data{
 			"type":      req.Type,
 			"wantReply": req.WantReply,
-			"payload":   req.Payload,
 		})
+		logger.Debug("request-payload", lager.Data{
+			"type":    req.Type,
+			"payload": req.Payload,
+		})
+		if replies != nil {
+			replies.RLock()
+		}
+
 		success, err := channel.SendRequest(req.Type, req.WantReply, req.Payload)
 		if err != nil {
 			logger.Error("send-request-failed", err)
-			continue
+		} else if req.WantReply {
+			req.Reply(success, nil)
 		}
 
-		if req.WantReply {
-			req.Reply(success, nil)
+		if replies != nil {
+			replies.RUnlock()
 		}
 	}
 }
@@ -197,32 +542,55 @@ func Wait(logger lager.Logger, waiters ...Waiter) {
 	wg.Wait()
 }
 
-func NewClientConn(logger lager.Logger, permissions *ssh.Permissions) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+func NewClientConn(logger lager.Logger, permissions *ssh.Permissions, dialConfig DialConfig) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	return newClientConn(logger, nil, permissions, &HopOrigin{}, dialConfig)
+}
+
+func newClientConn(logger lager.Logger, span *tracing.Span, permissions *ssh.Permissions, origin *HopOrigin, dialConfig DialConfig) (conn ssh.Conn, ch <-chan ssh.NewChannel, req <-chan *ssh.Request, err error) {
+	defer func() { span.Finish(err) }()
+
 	if permissions == nil || permissions.CriticalOptions == nil {
 		err := errors.New("Invalid permissions from authentication")
 		logger.Error("permissions-and-critical-options-required", err)
 		return nil, nil, nil, err
 	}
 
-	targetConfigJson := permissions.CriticalOptions["proxy-target-config"]
+	var targetConfig TargetConfig
+	err = json.Unmarshal([]byte(permissions.CriticalOptions["proxy-target-config"]), &targetConfig)
+
 	logger = logger.Session("new-client-conn", lager.Data{
-		"proxy-target-config": targetConfigJson,
+		"address":          targetConfig.Address,
+		"host-fingerprint": targetConfig.HostFingerprint,
+		"user":             targetConfig.User,
 	})
 
-	var targetConfig TargetConfig
-	err := json.Unmarshal([]byte(permissions.CriticalOptions["proxy-target-config"]), &targetConfig)
 	if err != nil {
 		logger.Error("unmarshal-failed", err)
 		return nil, nil, nil, err
 	}
 
-	nConn, err := net.Dial("tcp", targetConfig.Address)
+	span.SetAttribute("address", targetConfig.Address)
+
+	if targetConfig.Direct && targetConfig.NextHop == nil {
+		conn, ch, req = newDirectConn(logger, targetConfig, dialConfig.DialTimeout)
+		return conn, ch, req, nil
+	}
+
+	address, clientConfig, err := dialAddress(logger, targetConfig, permissions.CriticalOptions, origin, dialConfig)
 	if err != nil {
-		logger.Error("dial-failed", err)
 		return nil, nil, nil, err
 	}
 
+	if targetConfig.NextHop != nil {
+		span.SetAttribute("next-hop", address)
+	}
+
+	return dialTarget(logger, span, address, clientConfig, dialConfig)
+}
+
+func targetClientConfig(logger lager.Logger, targetConfig TargetConfig, dialConfig DialConfig) (*ssh.ClientConfig, error) {
 	clientConfig := &ssh.ClientConfig{}
+	dialConfig.Algorithms.Apply(&clientConfig.Config)
 
 	if targetConfig.User != "" {
 		clientConfig.User = targetConfig.User
@@ -232,7 +600,7 @@ func NewClientConn(logger lager.Logger, permissions *ssh.Permissions) (ssh.Conn,
 		key, err := ssh.ParsePrivateKey([]byte(targetConfig.PrivateKey))
 		if err != nil {
 			logger.Error("parsing-key-failed", err)
-			return nil, nil, nil, err
+			return nil, err
 		}
 		clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeys(key))
 	}
@@ -242,32 +610,28 @@ func NewClientConn(logger lager.Logger, permissions *ssh.Permissions) (ssh.Conn,
 	}
 
 	if targetConfig.HostFingerprint != "" {
-		clientConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			expectedFingerprint := targetConfig.HostFingerprint
-
-			var actualFingerprint string
-			switch utf8.RuneCountInString(expectedFingerprint) {
-			case helpers.MD5_FINGERPRINT_LENGTH:
-				actualFingerprint = helpers.MD5Fingerprint(key)
-			case helpers.SHA1_FINGERPRINT_LENGTH:
-				actualFingerprint = helpers.SHA1Fingerprint(key)
-			}
+		clientConfig.HostKeyCallback = fingerprintCallback(logger, targetConfig.HostFingerprint)
+	}
 
-			if expectedFingerprint != actualFingerprint {
-				err := errors.New("Host fingerprint mismatch")
-				logger.Error("host-key-fingerprint-mismatch", err)
-				return err
-			}
+	return clientConfig, nil
+}
 
-			return nil
+func fingerprintCallback(logger lager.Logger, expectedFingerprint string) func(string, net.Addr, ssh.PublicKey) error {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		var actualFingerprint string
+		switch utf8.RuneCountInString(expectedFingerprint) {
+		case helpers.MD5_FINGERPRINT_LENGTH:
+			actualFingerprint = helpers.MD5Fingerprint(key)
+		case helpers.SHA1_FINGERPRINT_LENGTH:
+			actualFingerprint = helpers.SHA1Fingerprint(key)
 		}
-	}
 
-	conn, ch, req, err := ssh.NewClientConn(nConn, targetConfig.Address, clientConfig)
-	if err != nil {
-		logger.Error("handshake-failed", err)
-		return nil, nil, nil, err
-	}
+		if expectedFingerprint != actualFingerprint {
+			err := errors.New("Host fingerprint mismatch")
+			logger.Error("host-key-fingerprint-mismatch", err)
+			return err
+		}
 
-	return conn, ch, req, nil
+		return nil
+	}
 }
@@ -1,22 +1,40 @@
 package proxy_test
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"strings"
+	"time"
 
+	"github.com/cloudfoundry-incubator/diego-ssh/audit"
+	"github.com/cloudfoundry-incubator/diego-ssh/audit/fake_audit"
+	"github.com/cloudfoundry-incubator/diego-ssh/authenticators"
 	"github.com/cloudfoundry-incubator/diego-ssh/authenticators/fake_authenticators"
+	"github.com/cloudfoundry-incubator/diego-ssh/bandwidth"
 	"github.com/cloudfoundry-incubator/diego-ssh/daemon"
 	"github.com/cloudfoundry-incubator/diego-ssh/handlers"
 	"github.com/cloudfoundry-incubator/diego-ssh/handlers/fake_handlers"
 	"github.com/cloudfoundry-incubator/diego-ssh/helpers"
+	"github.com/cloudfoundry-incubator/diego-ssh/keys"
 	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy/fake_proxy"
+	"github.com/cloudfoundry-incubator/diego-ssh/recording/fake_recording"
 	"github.com/cloudfoundry-incubator/diego-ssh/server"
 	server_fakes "github.com/cloudfoundry-incubator/diego-ssh/server/fakes"
+	"github.com/cloudfoundry-incubator/diego-ssh/stats"
 	"github.com/cloudfoundry-incubator/diego-ssh/test_helpers"
 	"github.com/cloudfoundry-incubator/diego-ssh/test_helpers/fake_net"
 	"github.com/cloudfoundry-incubator/diego-ssh/test_helpers/fake_ssh"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing/fake_tracing"
 	fake_logs "github.com/cloudfoundry/dropsonde/log_sender/fake"
 	"github.com/cloudfoundry/dropsonde/logs"
 	"github.com/pivotal-golang/lager"
@@ -59,6 +77,11 @@ var _ = Describe("Proxy", func() {
 
 			proxyServer *server.Server
 			sshdServer  *server.Server
+
+			auditor    *audit.Auditor
+			tracer     *tracing.Tracer
+			timeouts   proxy.Timeouts
+			dialConfig proxy.DialConfig
 		)
 
 		BeforeEach(func() {
@@ -113,10 +136,15 @@ var _ = Describe("Proxy", func() {
 				},
 			}
 			proxyAuthenticator.AuthenticateReturns(permissions, nil)
+
+			auditor = nil
+			tracer = nil
+			timeouts = proxy.Timeouts{}
+			dialConfig = proxy.DialConfig{}
 		})
 
 		JustBeforeEach(func() {
-			sshProxy = proxy.New(logger.Session("proxy"), proxySSHConfig)
+			sshProxy = proxy.New(logger.Session("proxy"), proxySSHConfig, nil, auditor, tracer, timeouts, dialConfig)
 			proxyServer = server.NewServer(logger, "127.0.0.1:0", sshProxy)
 			proxyServer.SetListener(proxyListener)
 			go proxyServer.Serve()
@@ -187,6 +215,12 @@ var _ = Describe("Proxy", func() {
 					Expect(string(password)).To(Equal("some-password"))
 				})
 
+				It("does not log the target credentials", func() {
+					Eventually(daemonAuthenticator.AuthenticateCallCount).Should(Equal(1))
+					Eventually(logger).Should(gbytes.Say(`proxy-global-requests.started`))
+					Expect(logger.(*lagertest.TestLogger).Buffer().Contents()).NotTo(ContainSubstring("some-password"))
+				})
+
 				It("emits a successful log message on behalf of the lrp", func() {
 					Eventually(fakeLogSender.GetLogs).Should(HaveLen(1))
 					logMessage := fakeLogSender.GetLogs()[0]
@@ -292,6 +326,11 @@ var _ = Describe("Proxy", func() {
 					It("logs the failure", func() {
 						Eventually(logger).Should(gbytes.Say(`new-client-conn.dial-failed.*0\.0\.0\.0:0`))
 					})
+
+					It("tells the client why when it opens a channel", func() {
+						_, err := client.NewSession()
+						Expect(err).To(MatchError(ContainSubstring(proxy.TargetUnreachableErr.Error())))
+					})
 				})
 
 				Context("when the handshake fails", func() {
@@ -309,6 +348,107 @@ var _ = Describe("Proxy", func() {
 				})
 			})
 
+			Context("when host keys are advertised", func() {
+				var (
+					newHostKey ssh.Signer
+					clientConn ssh.Conn
+					requests   <-chan *ssh.Request
+				)
+
+				BeforeEach(func() {
+					keyPair, err := keys.RSAKeyPairFactory.NewKeyPair(1024)
+					Expect(err).NotTo(HaveOccurred())
+					newHostKey = keyPair.PrivateKey()
+				})
+
+				JustBeforeEach(func() {
+					sshProxy.SetServerConfig(proxySSHConfig, []ssh.Signer{TestHostKey, newHostKey})
+
+					netConn, err := net.Dial("tcp", proxyAddress)
+					Expect(err).NotTo(HaveOccurred())
+
+					clientConn, _, requests, err = ssh.NewClientConn(netConn, proxyAddress, clientConfig)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				AfterEach(func() {
+					clientConn.Close()
+				})
+
+				It("sends all host keys to the client", func() {
+					var req *ssh.Request
+					Eventually(requests).Should(Receive(&req))
+					Expect(req.Type).To(Equal("hostkeys-00@openssh.com"))
+					Expect(req.WantReply).To(BeFalse())
+
+					var advertised struct {
+						First  []byte
+						Second []byte
+					}
+					Expect(ssh.Unmarshal(req.Payload, &advertised)).To(Succeed())
+					Expect(advertised.First).To(Equal(TestHostKey.PublicKey().Marshal()))
+					Expect(advertised.Second).To(Equal(newHostKey.PublicKey().Marshal()))
+				})
+
+				It("proves possession of an advertised host key", func() {
+					blob := newHostKey.PublicKey().Marshal()
+
+					ok, reply, err := clientConn.SendRequest("hostkeys-prove-00@openssh.com", true, ssh.Marshal(struct{ Key []byte }{blob}))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ok).To(BeTrue())
+
+					var proof struct{ Signature []byte }
+					Expect(ssh.Unmarshal(reply, &proof)).To(Succeed())
+
+					var signature ssh.Signature
+					Expect(ssh.Unmarshal(proof.Signature, &signature)).To(Succeed())
+
+					signed := ssh.Marshal(struct {
+						Type      string
+						SessionID []byte
+						Key       []byte
+					}{"hostkeys-prove-00@openssh.com", clientConn.SessionID(), blob})
+					Expect(newHostKey.PublicKey().Verify(signed, &signature)).To(Succeed())
+				})
+
+				It("proves RSA host keys with a SHA-2 signature", func() {
+					blob := newHostKey.PublicKey().Marshal()
+
+					ok, reply, err := clientConn.SendRequest("hostkeys-prove-00@openssh.com", true, ssh.Marshal(struct{ Key []byte }{blob}))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ok).To(BeTrue())
+
+					var proof struct{ Signature []byte }
+					Expect(ssh.Unmarshal(reply, &proof)).To(Succeed())
+
+					var signature ssh.Signature
+					Expect(ssh.Unmarshal(proof.Signature, &signature)).To(Succeed())
+
+					// OpenSSH verifies RSA proofs with the negotiated SHA-2
+					// algorithm and rejects ssh-rsa signatures.
+					Expect(signature.Format).To(Equal(ssh.KeyAlgoRSASHA512))
+
+					rsaKey, ok := newHostKey.PublicKey().(ssh.CryptoPublicKey).CryptoPublicKey().(*rsa.PublicKey)
+					Expect(ok).To(BeTrue())
+
+					digest := sha512.Sum512(ssh.Marshal(struct {
+						Type      string
+						SessionID []byte
+						Key       []byte
+					}{"hostkeys-prove-00@openssh.com", clientConn.SessionID(), blob}))
+					Expect(rsa.VerifyPKCS1v15(rsaKey, crypto.SHA512, digest[:], signature.Blob)).To(Succeed())
+				})
+
+				It("refuses to prove an unknown host key", func() {
+					keyPair, err := keys.RSAKeyPairFactory.NewKeyPair(1024)
+					Expect(err).NotTo(HaveOccurred())
+
+					ok, _, err := clientConn.SendRequest("hostkeys-prove-00@openssh.com", true, ssh.Marshal(struct{ Key []byte }{keyPair.PublicKey().Marshal()}))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ok).To(BeFalse())
+				})
+			})
+
 			Context("when HandleConnection returns", func() {
 				var fakeServerConnection *fake_net.FakeConn
 
@@ -408,6 +548,442 @@ var _ = Describe("Proxy", func() {
 						Expect(err).To(Equal(&ssh.OpenChannelError{Reason: ssh.Prohibited, Message: "not now"}))
 					})
 				})
+				Context("when the session is registered", func() {
+					It("lists the live session", func() {
+						Eventually(func() []proxy.SessionInfo { return sshProxy.Sessions().List("", "") }).Should(HaveLen(1))
+
+						info := sshProxy.Sessions().List("", "")[0]
+						Expect(info.User).To(Equal("diego:some-instance-guid"))
+						Expect(info.Realm).To(Equal("diego"))
+						Expect(info.AppGuid).To(Equal("a-guid"))
+						Expect(*info.Index).To(Equal(1))
+						Expect(info.TargetAddress).To(Equal(daemonAddress))
+						Expect(info.ID).To(Equal(hex.EncodeToString(client.SessionID())))
+					})
+
+					It("disconnects the client when the session is terminated", func() {
+						Eventually(func() []proxy.SessionInfo { return sshProxy.Sessions().List("", "") }).Should(HaveLen(1))
+
+						Expect(sshProxy.Sessions().Terminate(hex.EncodeToString(client.SessionID()))).To(BeTrue())
+
+						errCh := make(chan error, 1)
+						go func() { errCh <- client.Wait() }()
+						Eventually(errCh).Should(Receive())
+						Eventually(func() []proxy.SessionInfo { return sshProxy.Sessions().List("", "") }).Should(BeEmpty())
+					})
+				})
+
+				It("counts the active connection", func() {
+					metrics := func() string {
+						buffer := &bytes.Buffer{}
+						stats.Default.WriteTo(buffer, "ssh_proxy_active_connections")
+						return buffer.String()
+					}
+
+					Eventually(metrics).Should(MatchRegexp(`(?m)^ssh_proxy_active_connections [1-9]`))
+				})
+
+				Context("when the session limits of the target are reached", func() {
+					BeforeEach(func() {
+						targetConfigJson, err := json.Marshal(daemonTargetConfig)
+						Expect(err).NotTo(HaveOccurred())
+
+						proxyAuthenticator.AuthenticateReturns(&ssh.Permissions{
+							CriticalOptions: map[string]string{
+								"proxy-target-config": string(targetConfigJson),
+								"session-limits":      `{"principal":"diego","target":"some-guid/0","max_target_sessions":1}`,
+							},
+						}, nil)
+					})
+
+					It("rejects the channels of additional connections", func() {
+						second, err := ssh.Dial("tcp", proxyAddress, clientConfig)
+						Expect(err).NotTo(HaveOccurred())
+						defer second.Close()
+
+						_, err = second.NewSession()
+						Expect(err).To(MatchError(ContainSubstring("Too many concurrent sessions for this app instance")))
+						Eventually(logger).Should(gbytes.Say("session-limit-exceeded"))
+					})
+
+					It("allows new connections once the session ends", func() {
+						client.Close()
+
+						Eventually(func() error {
+							next, err := ssh.Dial("tcp", proxyAddress, clientConfig)
+							if err != nil {
+								return err
+							}
+							defer next.Close()
+
+							_, _, err = next.OpenChannel("test", nil)
+							return err
+						}).Should(MatchError(ContainSubstring("unknown channel type")))
+					})
+				})
+
+				Context("when an idle timeout is configured", func() {
+					BeforeEach(func() {
+						timeouts = proxy.Timeouts{IdleTimeout: 200 * time.Millisecond}
+					})
+
+					It("closes the connection once it has been idle", func() {
+						errCh := make(chan error, 1)
+						go func() { errCh <- client.Wait() }()
+
+						Eventually(errCh).Should(Receive())
+						Eventually(logger).Should(gbytes.Say(`session-timer.disconnecting.*idle timeout exceeded`))
+					})
+				})
+
+				Context("when a maximum session duration is configured", func() {
+					BeforeEach(func() {
+						timeouts = proxy.Timeouts{MaxDuration: 200 * time.Millisecond}
+					})
+
+					It("closes the connection once the duration is exceeded", func() {
+						errCh := make(chan error, 1)
+						go func() { errCh <- client.Wait() }()
+
+						Eventually(errCh).Should(Receive())
+						Eventually(logger).Should(gbytes.Say(`session-timer.disconnecting.*maximum session duration exceeded`))
+					})
+				})
+
+				Context("when the proxy enters maintenance", func() {
+					var errCh chan error
+
+					JustBeforeEach(func() {
+						errCh = make(chan error, 1)
+						client, errCh := client, errCh
+						go func() { errCh <- client.Wait() }()
+					})
+
+					It("lets sessions in progress continue when there is no drain", func() {
+						sshProxy.EnterMaintenance("back soon", 0)
+						Consistently(errCh, 300*time.Millisecond).ShouldNot(Receive())
+					})
+
+					It("closes sessions in progress once the drain has passed", func() {
+						sshProxy.EnterMaintenance("back soon", 200*time.Millisecond)
+
+						Eventually(errCh).Should(Receive())
+						Eventually(logger).Should(gbytes.Say(`session-timer.disconnecting.*going down for maintenance`))
+					})
+
+					It("does not close sessions when maintenance ends before the drain", func() {
+						sshProxy.EnterMaintenance("back soon", 200*time.Millisecond)
+						sshProxy.ExitMaintenance()
+
+						Consistently(errCh, 400*time.Millisecond).ShouldNot(Receive())
+					})
+
+					It("shows the message to new logins and refuses them without authenticating", func() {
+						sshProxy.EnterMaintenance("back soon", 0)
+
+						banner := make(chan string, 1)
+						maintenanceConfig := *clientConfig
+						maintenanceConfig.BannerCallback = func(message string) error {
+							banner <- message
+							return nil
+						}
+
+						_, err := ssh.Dial("tcp", proxyAddress, &maintenanceConfig)
+						Expect(err).To(MatchError(ContainSubstring("unable to authenticate")))
+						Expect(banner).To(Receive(Equal("back soon\r\n")))
+
+						Expect(proxyAuthenticator.AuthenticateCallCount()).To(Equal(1))
+						Eventually(logger).Should(gbytes.Say("refused-for-maintenance"))
+					})
+
+					Context("and an auditor is provided", func() {
+						var auditSink *fake_audit.FakeSink
+
+						BeforeEach(func() {
+							auditSink = &fake_audit.FakeSink{}
+							auditor = audit.NewAuditor(logger, auditSink)
+						})
+
+						It("audits the refused logins as failed authentications", func() {
+							sshProxy.EnterMaintenance("back soon", 0)
+
+							_, err := ssh.Dial("tcp", proxyAddress, clientConfig)
+							Expect(err).To(HaveOccurred())
+
+							var refusal audit.Event
+							for i := 0; i < auditSink.SendCallCount(); i++ {
+								if event := auditSink.SendArgsForCall(i); event.Type == audit.AuthFailed {
+									refusal = event
+								}
+							}
+							Expect(refusal.Error).To(Equal(proxy.InMaintenanceErr.Error()))
+							Expect(refusal.User).To(Equal(clientConfig.User))
+						})
+					})
+
+					It("accepts logins again when maintenance ends", func() {
+						sshProxy.EnterMaintenance("back soon", 0)
+						sshProxy.ExitMaintenance()
+
+						second, err := ssh.Dial("tcp", proxyAddress, clientConfig)
+						Expect(err).NotTo(HaveOccurred())
+						defer second.Close()
+
+						_, _, err = second.OpenChannel("test", nil)
+						Expect(err).To(MatchError(ContainSubstring("unknown channel type")))
+					})
+				})
+
+				Context("when the target has opted in to session logs", func() {
+					BeforeEach(func() {
+						targetConfigJson, err := json.Marshal(daemonTargetConfig)
+						Expect(err).NotTo(HaveOccurred())
+
+						logMessageJson, err := json.Marshal(proxy.LogMessage{
+							Guid:        "a-guid",
+							Message:     "a-message",
+							Index:       1,
+							SessionLogs: true,
+						})
+						Expect(err).NotTo(HaveOccurred())
+
+						proxyAuthenticator.AuthenticateReturns(&ssh.Permissions{
+							CriticalOptions: map[string]string{
+								"proxy-target-config": string(targetConfigJson),
+								"log-message":         string(logMessageJson),
+							},
+						}, nil)
+
+						daemonNewChannelHandlers["session"] = handlers.NewSessionChannelHandler(
+							handlers.NewCommandRunner(),
+							handlers.NewShellLocator(),
+							map[string]string{},
+							time.Second,
+							nil,
+						)
+					})
+
+					It("sends the commands executed and the end of the session to the app logs", func() {
+						session, err := client.NewSession()
+						Expect(err).NotTo(HaveOccurred())
+
+						err = session.Run("sleep 0.1; true")
+						Expect(err).NotTo(HaveOccurred())
+
+						client.Close()
+
+						Eventually(fakeLogSender.GetLogs).Should(HaveLen(3))
+						appLogs := fakeLogSender.GetLogs()
+						Expect(appLogs[0].Message).To(Equal("a-message"))
+						Expect(appLogs[1].Message).To(MatchRegexp(`^Remote command by 127\.0\.0\.1:\d+: sleep 0\.1; true$`))
+						Expect(appLogs[2].Message).To(MatchRegexp(`^Remote access by 127\.0\.0\.1:\d+ ended after \d+s$`))
+
+						for _, log := range appLogs {
+							Expect(log.AppId).To(Equal("a-guid"))
+							Expect(log.SourceType).To(Equal("SSH"))
+							Expect(log.SourceInstance).To(Equal("1"))
+						}
+					})
+				})
+
+				Context("when the user is identified", func() {
+					BeforeEach(func() {
+						targetConfigJson, err := json.Marshal(daemonTargetConfig)
+						Expect(err).NotTo(HaveOccurred())
+
+						proxyAuthenticator.AuthenticateReturns(&ssh.Permissions{
+							CriticalOptions: map[string]string{
+								"proxy-target-config": string(targetConfigJson),
+								"user-identity":       `{"user_id":"user-guid","user_name":"user@example.com","realm":"cf"}`,
+							},
+						}, nil)
+
+						daemonNewChannelHandlers["session"] = handlers.NewSessionChannelHandler(
+							handlers.NewCommandRunner(),
+							handlers.NewShellLocator(),
+							map[string]string{},
+							time.Second,
+							nil,
+						)
+					})
+
+					It("passes the user and the address of the client to the daemon", func() {
+						session, err := client.NewSession()
+						Expect(err).NotTo(HaveOccurred())
+
+						output, err := session.Output("/bin/echo -n $CF_SSH_USER_ID $CF_SSH_USER $CF_SSH_REALM $CF_SSH_CLIENT_ADDR")
+						Expect(err).NotTo(HaveOccurred())
+						Expect(string(output)).To(Equal("user-guid user@example.com cf " + client.LocalAddr().String()))
+					})
+
+					It("does not let the client override the identity", func() {
+						session, err := client.NewSession()
+						Expect(err).NotTo(HaveOccurred())
+
+						err = session.Setenv("CF_SSH_USER", "someone-else")
+						Expect(err).To(HaveOccurred())
+
+						output, err := session.Output("/bin/echo -n $CF_SSH_USER")
+						Expect(err).NotTo(HaveOccurred())
+						Expect(string(output)).To(Equal("user@example.com"))
+					})
+				})
+
+				Context("when an auditor is provided", func() {
+					var auditSink *fake_audit.FakeSink
+
+					BeforeEach(func() {
+						auditSink = &fake_audit.FakeSink{}
+						auditor = audit.NewAuditor(logger, auditSink)
+
+						daemonNewChannelHandlers["session"] = handlers.NewSessionChannelHandler(
+							handlers.NewCommandRunner(),
+							handlers.NewShellLocator(),
+							map[string]string{},
+							time.Second,
+							nil,
+						)
+					})
+
+					eventTypes := func() []string {
+						types := []string{}
+						for i := 0; i < auditSink.SendCallCount(); i++ {
+							types = append(types, auditSink.SendArgsForCall(i).Type)
+						}
+						return types
+					}
+
+					It("emits events for the session and the commands executed", func() {
+						session, err := client.NewSession()
+						Expect(err).NotTo(HaveOccurred())
+
+						output, err := session.Output("sleep 0.1; /bin/echo -n hello")
+						Expect(err).NotTo(HaveOccurred())
+						Expect(string(output)).To(Equal("hello"))
+
+						client.Close()
+
+						Eventually(eventTypes).Should(ContainElement(audit.SessionEnded))
+						Eventually(eventTypes).Should(ContainElement(audit.ChannelClosed))
+						Expect(eventTypes()).To(ContainElement(audit.SessionStarted))
+						Expect(eventTypes()).To(ContainElement(audit.ChannelOpened))
+
+						var exec, closed audit.Event
+						for i := 0; i < auditSink.SendCallCount(); i++ {
+							event := auditSink.SendArgsForCall(i)
+							switch event.Type {
+							case audit.Exec:
+								exec = event
+							case audit.ChannelClosed:
+								closed = event
+							}
+							Expect(event.User).To(Equal("diego:some-instance-guid"))
+							Expect(event.SessionID).NotTo(BeEmpty())
+							Expect(event.TargetAddress).To(Equal(daemonAddress))
+							Expect(event.LogGuid).To(Equal("a-guid"))
+						}
+
+						Expect(exec.Command).To(Equal("sleep 0.1; /bin/echo -n hello"))
+						Expect(closed.ChannelType).To(Equal("session"))
+						Expect(closed.BytesOut).To(BeEquivalentTo(5))
+					})
+
+					Context("when a cf user logs in", func() {
+						BeforeEach(func() {
+							targetConfigJson, err := json.Marshal(daemonTargetConfig)
+							Expect(err).NotTo(HaveOccurred())
+
+							proxyAuthenticator.AuthenticateReturns(&ssh.Permissions{
+								CriticalOptions: map[string]string{
+									"proxy-target-config": string(targetConfigJson),
+									"user-identity":       `{"user_id":"user-guid","user_name":"user@example.com","realm":"cf"}`,
+								},
+							}, nil)
+						})
+
+						It("tags the events with the authenticated user", func() {
+							session, err := client.NewSession()
+							Expect(err).NotTo(HaveOccurred())
+
+							_, err = session.Output("true")
+							Expect(err).NotTo(HaveOccurred())
+
+							client.Close()
+
+							Eventually(eventTypes).Should(ContainElement(audit.SessionEnded))
+							for i := 0; i < auditSink.SendCallCount(); i++ {
+								event := auditSink.SendArgsForCall(i)
+								Expect(event.UserID).To(Equal("user-guid"))
+								Expect(event.UserName).To(Equal("user@example.com"))
+								Expect(event.Realm).To(Equal("cf"))
+							}
+						})
+					})
+				})
+
+				Context("when a tracer is provided", func() {
+					var exporter *fake_tracing.FakeExporter
+
+					BeforeEach(func() {
+						exporter = &fake_tracing.FakeExporter{}
+						tracer = tracing.NewTracer(logger, "ssh-proxy", exporter)
+
+						daemonNewChannelHandlers["session"] = handlers.NewSessionChannelHandler(
+							handlers.NewCommandRunner(),
+							handlers.NewShellLocator(),
+							map[string]string{},
+							time.Second,
+							nil,
+						)
+					})
+
+					spans := func() map[string]*tracing.Span {
+						spans := map[string]*tracing.Span{}
+						for i := 0; i < exporter.ExportCallCount(); i++ {
+							span := exporter.ExportArgsForCall(i)
+							spans[span.Name] = span
+						}
+						return spans
+					}
+
+					It("traces the phases of the login", func() {
+						Eventually(spans).Should(HaveKey("ssh-login"))
+
+						login := spans()["ssh-login"]
+						Expect(login.Attributes).To(HaveKeyWithValue("user", "diego:some-instance-guid"))
+
+						Expect(spans()).To(HaveKey("handshake"))
+						Expect(spans()).To(HaveKey("dial-target"))
+						Expect(spans()).To(HaveKey("tcp-dial"))
+						Expect(spans()).To(HaveKey("target-handshake"))
+
+						for _, span := range spans() {
+							Expect(span.TraceID).To(Equal(login.TraceID))
+							Expect(span.Error).To(BeEmpty())
+						}
+
+						Expect(spans()["handshake"].ParentID).To(Equal(login.SpanID))
+						Expect(spans()["tcp-dial"].ParentID).To(Equal(spans()["dial-target"].SpanID))
+					})
+
+					It("passes the handshake span to the authenticator", func() {
+						Expect(proxyAuthenticator.AuthenticateCallCount()).To(Equal(1))
+
+						metadata, _ := proxyAuthenticator.AuthenticateArgsForCall(0)
+						Expect(tracing.SpanFromMetadata(metadata).Name).To(Equal("handshake"))
+					})
+
+					It("passes the trace id to the daemon", func() {
+						session, err := client.NewSession()
+						Expect(err).NotTo(HaveOccurred())
+
+						output, err := session.Output("/bin/echo -n $CF_SSH_TRACE_ID")
+						Expect(err).NotTo(HaveOccurred())
+
+						Eventually(spans).Should(HaveKey("ssh-login"))
+						Expect(string(output)).To(Equal(spans()["ssh-login"].TraceID))
+					})
+				})
 			})
 
 			Describe("target requests to client", func() {
@@ -437,83 +1013,531 @@ var _ = Describe("Proxy", func() {
 					target.SetListener(listener)
 					go target.Serve()
 
-					clientNetConn, err := net.Dial("tcp", targetAddress)
-					clientConn, clientChannels, clientRequests, err = ssh.NewClientConn(clientNetConn, "0.0.0.0", &ssh.ClientConfig{})
-					Expect(err).NotTo(HaveOccurred())
-				})
+					clientNetConn, err := net.Dial("tcp", targetAddress)
+					clientConn, clientChannels, clientRequests, err = ssh.NewClientConn(clientNetConn, "0.0.0.0", &ssh.ClientConfig{})
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				AfterEach(func() {
+					target.Shutdown()
+				})
+
+				Context("when the target sends a global request", func() {
+					BeforeEach(func() {
+						connectionHandler.HandleConnectionStub = func(conn net.Conn) {
+							defer GinkgoRecover()
+
+							serverConn, _, _, err := ssh.NewServerConn(conn, daemonSSHConfig)
+							Expect(err).NotTo(HaveOccurred())
+
+							accepted, response, err := serverConn.SendRequest("test", true, []byte("test-data"))
+							Expect(err).NotTo(HaveOccurred())
+							Expect(accepted).To(BeTrue())
+							Expect(response).To(Equal([]byte("response-data")))
+
+							serverConn.Close()
+						}
+					})
+
+					It("gets forwarded to the client", func() {
+						var req *ssh.Request
+						Eventually(clientRequests).Should(Receive(&req))
+
+						req.Reply(true, []byte("response-data"))
+					})
+				})
+
+				Context("when the target requests a new channel", func() {
+					BeforeEach(func() {
+						connectionHandler.HandleConnectionStub = func(conn net.Conn) {
+							defer GinkgoRecover()
+
+							serverConn, _, _, err := ssh.NewServerConn(conn, daemonSSHConfig)
+							Expect(err).NotTo(HaveOccurred())
+
+							channel, requests, err := serverConn.OpenChannel("test-channel", []byte("extra-data"))
+							Expect(err).NotTo(HaveOccurred())
+							Expect(channel).NotTo(BeNil())
+							Expect(requests).NotTo(BeClosed())
+
+							channel.Write([]byte("hello"))
+
+							channelResponse := make([]byte, 7)
+							channel.Read(channelResponse)
+							Expect(string(channelResponse)).To(Equal("goodbye"))
+
+							channel.Close()
+							serverConn.Close()
+						}
+					})
+
+					It("gets forwarded to the client", func() {
+						var newChannel ssh.NewChannel
+						Eventually(clientChannels).Should(Receive(&newChannel))
+
+						Expect(newChannel.ChannelType()).To(Equal("test-channel"))
+						Expect(newChannel.ExtraData()).To(Equal([]byte("extra-data")))
+
+						channel, requests, err := newChannel.Accept()
+						Expect(err).NotTo(HaveOccurred())
+						Expect(channel).NotTo(BeNil())
+						Expect(requests).NotTo(BeClosed())
+
+						channelRequest := make([]byte, 5)
+						channel.Read(channelRequest)
+						Expect(string(channelRequest)).To(Equal("hello"))
+
+						channel.Write([]byte("goodbye"))
+						channel.Close()
+					})
+				})
+			})
+		})
+
+		Context("when the login fans out to every instance", func() {
+			var (
+				client        *ssh.Client
+				fanOutTargets []proxy.FanOutTarget
+				logMessage    string
+				auditSink     *fake_audit.FakeSink
+			)
+
+			BeforeEach(func() {
+				daemonSSHConfig.NoClientAuth = true
+				daemonNewChannelHandlers["session"] = handlers.NewSessionChannelHandler(
+					handlers.NewCommandRunner(),
+					handlers.NewShellLocator(),
+					map[string]string{},
+					time.Second,
+					nil,
+				)
+
+				fanOutTargets = []proxy.FanOutTarget{
+					{Index: 0, TargetConfig: daemonTargetConfig},
+					{Index: 2, TargetConfig: daemonTargetConfig},
+				}
+				logMessage = `{"guid":"a-guid","message":"a-message"}`
+
+				auditSink = &fake_audit.FakeSink{}
+				auditor = audit.NewAuditor(logger, auditSink)
+			})
+
+			JustBeforeEach(func() {
+				fanOutJson, err := json.Marshal(fanOutTargets)
+				Expect(err).NotTo(HaveOccurred())
+
+				proxyAuthenticator.AuthenticateReturns(&ssh.Permissions{
+					CriticalOptions: map[string]string{
+						"proxy-fan-out-targets": string(fanOutJson),
+						"log-message":           logMessage,
+						"user-identity":         `{"realm":"diego"}`,
+					},
+				}, nil)
+
+				client, err = ssh.Dial("tcp", proxyAddress, &ssh.ClientConfig{
+					User: "diego:some-guid/*",
+					Auth: []ssh.AuthMethod{ssh.Password("diego-user:diego-password")},
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			AfterEach(func() {
+				client.Close()
+			})
+
+			It("runs the command on every instance and prefixes the output with the index", func() {
+				session, err := client.NewSession()
+				Expect(err).NotTo(HaveOccurred())
+
+				output, err := session.Output("printf 'one\ntwo'")
+				Expect(err).NotTo(HaveOccurred())
+
+				lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+				Expect(lines).To(ConsistOf("[0] one", "[0] two", "[2] one", "[2] two"))
+				Expect(strings.Index(string(output), "[0] one")).To(BeNumerically("<", strings.Index(string(output), "[0] two")))
+			})
+
+			It("passes the environment to every instance", func() {
+				session, err := client.NewSession()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(session.Setenv("GREETING", "hello")).To(Succeed())
+
+				output, err := session.Output("echo $GREETING")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(strings.Split(strings.TrimSpace(string(output)), "\n")).To(ConsistOf("[0] hello", "[2] hello"))
+			})
+
+			It("passes the user to every instance", func() {
+				session, err := client.NewSession()
+				Expect(err).NotTo(HaveOccurred())
+
+				output, err := session.Output("echo $CF_SSH_REALM")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(strings.Split(strings.TrimSpace(string(output)), "\n")).To(ConsistOf("[0] diego", "[2] diego"))
+			})
+
+			It("exits with the highest exit status of the instances", func() {
+				session, err := client.NewSession()
+				Expect(err).NotTo(HaveOccurred())
+
+				err = session.Run("exit 3")
+				Expect(err).To(BeAssignableToTypeOf(&ssh.ExitError{}))
+				Expect(err.(*ssh.ExitError).ExitStatus()).To(Equal(3))
+			})
+
+			It("sends a log message for every instance", func() {
+				Eventually(fakeLogSender.GetLogs).Should(HaveLen(2))
+				Expect(fakeLogSender.GetLogs()[0].SourceInstance).To(Equal("0"))
+				Expect(fakeLogSender.GetLogs()[1].SourceInstance).To(Equal("2"))
+			})
+
+			It("records the instances in the audit events", func() {
+				session, err := client.NewSession()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(session.Run("true")).To(Succeed())
+
+				for i := 0; i < auditSink.SendCallCount(); i++ {
+					event := auditSink.SendArgsForCall(i)
+					if event.Type == audit.SessionStarted || event.Type == audit.Exec {
+						Expect(event.Index).To(BeNil())
+						Expect(event.Indexes).To(Equal([]int{0, 2}))
+						Expect(event.LogGuid).To(Equal("a-guid"))
+					}
+				}
+				Expect(auditSink.SendCallCount()).To(BeNumerically(">", 1))
+			})
+
+			It("registers the session with the instances it reaches", func() {
+				Eventually(func() []proxy.SessionInfo { return sshProxy.Sessions().List("", "a-guid") }).Should(HaveLen(1))
+
+				info := sshProxy.Sessions().List("", "a-guid")[0]
+				Expect(info.Index).To(BeNil())
+				Expect(info.Indexes).To(Equal([]int{0, 2}))
+
+				Expect(sshProxy.Sessions().Terminate(info.ID)).To(BeTrue())
+
+				errCh := make(chan error, 1)
+				go func() { errCh <- client.Wait() }()
+				Eventually(errCh).Should(Receive())
+			})
+
+			It("stops the commands when the session is terminated", func() {
+				session, err := client.NewSession()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(session.Start("sleep 10")).To(Succeed())
+
+				Eventually(func() []proxy.SessionInfo { return sshProxy.Sessions().List("", "a-guid") }).Should(HaveLen(1))
+				sshProxy.Sessions().TerminateMatching("", "a-guid")
+
+				errCh := make(chan error, 1)
+				go func() { errCh <- session.Wait() }()
+				Eventually(errCh, 2*time.Second).Should(Receive())
+			})
+
+			Context("when an idle timeout is configured", func() {
+				BeforeEach(func() {
+					timeouts = proxy.Timeouts{IdleTimeout: 200 * time.Millisecond}
+				})
+
+				It("closes the connection once it has been idle", func() {
+					errCh := make(chan error, 1)
+					go func() { errCh <- client.Wait() }()
+
+					Eventually(errCh).Should(Receive())
+					Eventually(logger).Should(gbytes.Say(`session-timer.disconnecting.*idle timeout exceeded`))
+				})
+			})
+
+			Context("when the app has opted in to session logs", func() {
+				BeforeEach(func() {
+					logMessage = `{"guid":"a-guid","message":"a-message","session_logs":true}`
+				})
+
+				It("sends the commands executed and the end of the session to the logs of every instance", func() {
+					session, err := client.NewSession()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(session.Run("true")).To(Succeed())
+
+					client.Close()
+
+					Eventually(fakeLogSender.GetLogs).Should(HaveLen(6))
+
+					instances := map[string][]string{}
+					for _, log := range fakeLogSender.GetLogs() {
+						instances[log.SourceInstance] = append(instances[log.SourceInstance], log.Message)
+					}
+					Expect(instances).To(HaveLen(2))
+					for _, messages := range instances {
+						Expect(messages[0]).To(Equal("a-message"))
+						Expect(messages[1]).To(MatchRegexp(`^Remote command by 127\.0\.0\.1:\d+: true$`))
+						Expect(messages[2]).To(MatchRegexp(`^Remote access by 127\.0\.0\.1:\d+ ended after \d+s$`))
+					}
+				})
+			})
+
+			It("refuses interactive shells", func() {
+				session, err := client.NewSession()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(session.Shell()).NotTo(Succeed())
+			})
+
+			It("refuses channels other than sessions", func() {
+				_, _, err := client.OpenChannel("direct-tcpip", nil)
+				Expect(err).To(Equal(&ssh.OpenChannelError{Reason: ssh.Prohibited, Message: proxy.FanOutExecOnlyErr.Error()}))
+			})
+
+			Context("when an instance cannot be reached", func() {
+				BeforeEach(func() {
+					unreachable := daemonTargetConfig
+					unreachable.Address = "127.0.0.1:1"
+					fanOutTargets = append(fanOutTargets, proxy.FanOutTarget{Index: 1, TargetConfig: unreachable})
+				})
+
+				It("reports the failure for that instance and runs the command on the others", func() {
+					session, err := client.NewSession()
+					Expect(err).NotTo(HaveOccurred())
+
+					stdout := gbytes.NewBuffer()
+					stderr := gbytes.NewBuffer()
+					session.Stdout = stdout
+					session.Stderr = stderr
+
+					err = session.Run("echo hello")
+					Expect(err).To(BeAssignableToTypeOf(&ssh.ExitError{}))
+					Expect(err.(*ssh.ExitError).ExitStatus()).To(Equal(255))
+
+					Expect(stderr).To(gbytes.Say(`\[1\] Unable to connect to the app instance`))
+					Expect(strings.Split(strings.TrimSpace(string(stdout.Contents())), "\n")).To(ConsistOf("[0] hello", "[2] hello"))
+				})
+			})
+		})
+
+		Context("when the target is direct", func() {
+			var (
+				client       *ssh.Client
+				echoListener net.Listener
+			)
+
+			BeforeEach(func() {
+				var err error
+				echoListener, err = net.Listen("tcp", "127.0.0.1:0")
+				Expect(err).NotTo(HaveOccurred())
+
+				go func() {
+					for {
+						conn, err := echoListener.Accept()
+						if err != nil {
+							return
+						}
+						go func() {
+							io.Copy(conn, conn)
+							conn.Close()
+						}()
+					}
+				}()
+			})
+
+			JustBeforeEach(func() {
+				targetConfigJson, err := json.Marshal(proxy.TargetConfig{
+					Address: "127.0.0.1",
+					Direct:  true,
+					Ports: []proxy.DirectPort{
+						{ContainerPort: 8080, Address: echoListener.Addr().String()},
+						{ContainerPort: 9090, Address: "127.0.0.1:1"},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				proxyAuthenticator.AuthenticateReturns(&ssh.Permissions{
+					CriticalOptions: map[string]string{
+						"proxy-target-config": string(targetConfigJson),
+						"log-message":         `{"guid":"a-guid","message":"a-message","index":1}`,
+					},
+				}, nil)
+
+				client, err = ssh.Dial("tcp", proxyAddress, &ssh.ClientConfig{
+					User: "diego:some-guid/1",
+					Auth: []ssh.AuthMethod{ssh.Password("diego-user:diego-password")},
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			AfterEach(func() {
+				client.Close()
+				echoListener.Close()
+			})
+
+			It("forwards declared ports to their host side mappings", func() {
+				conn, err := client.Dial("tcp", "localhost:8080")
+				Expect(err).NotTo(HaveOccurred())
+				defer conn.Close()
+
+				_, err = conn.Write([]byte("hello"))
+				Expect(err).NotTo(HaveOccurred())
+
+				reply := make([]byte, 5)
+				_, err = io.ReadFull(conn, reply)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(reply)).To(Equal("hello"))
+			})
+
+			It("refuses ports that were not declared", func() {
+				_, err := client.Dial("tcp", "localhost:2222")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("Port 2222 is not exposed by the app"))
+			})
+
+			It("reports ports that cannot be reached", func() {
+				_, err := client.Dial("tcp", "localhost:9090")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(proxy.TargetUnreachableErr.Error()))
+			})
+
+			It("refuses sessions", func() {
+				_, err := client.NewSession()
+				Expect(err).To(Equal(&ssh.OpenChannelError{Reason: ssh.Prohibited, Message: proxy.PortForwardingOnlyErr.Error()}))
+			})
 
-				AfterEach(func() {
-					target.Shutdown()
-				})
+			It("sends the log message for the instance", func() {
+				Eventually(fakeLogSender.GetLogs).Should(HaveLen(1))
+				Expect(fakeLogSender.GetLogs()[0].SourceInstance).To(Equal("1"))
+			})
+		})
 
-				Context("when the target sends a global request", func() {
-					BeforeEach(func() {
-						connectionHandler.HandleConnectionStub = func(conn net.Conn) {
-							defer GinkgoRecover()
+		Context("when the target is behind a next hop", func() {
+			var (
+				client       *ssh.Client
+				hopKey       keys.KeyPair
+				trustedKey   ssh.PublicKey
+				hopAuditSink *fake_audit.FakeSink
+				hopListener  net.Listener
+				hopServer    *server.Server
+			)
 
-							serverConn, _, _, err := ssh.NewServerConn(conn, daemonSSHConfig)
-							Expect(err).NotTo(HaveOccurred())
+			BeforeEach(func() {
+				var err error
+				hopKey, err = keys.RSAKeyPairFactory.NewKeyPair(1024)
+				Expect(err).NotTo(HaveOccurred())
+				trustedKey = hopKey.PublicKey()
+
+				daemonNewChannelHandlers["session"] = handlers.NewSessionChannelHandler(
+					handlers.NewCommandRunner(),
+					handlers.NewShellLocator(),
+					map[string]string{},
+					time.Second,
+					nil,
+				)
 
-							accepted, response, err := serverConn.SendRequest("test", true, []byte("test-data"))
-							Expect(err).NotTo(HaveOccurred())
-							Expect(accepted).To(BeTrue())
-							Expect(response).To(Equal([]byte("response-data")))
+				hopListener, err = net.Listen("tcp", "127.0.0.1:0")
+				Expect(err).NotTo(HaveOccurred())
+			})
 
-							serverConn.Close()
-						}
-					})
+			JustBeforeEach(func() {
+				hopAuditSink = &fake_audit.FakeSink{}
+				hopAuditor := audit.NewAuditor(logger, hopAuditSink)
+				hopAuthenticator := authenticators.NewHopAuthenticator([]ssh.PublicKey{trustedKey})
 
-					It("gets forwarded to the client", func() {
-						var req *ssh.Request
-						Eventually(clientRequests).Should(Receive(&req))
+				hopSSHConfig := &ssh.ServerConfig{
+					PublicKeyCallback: hopAuditor.PublicKeyCallback(hopAuthenticator.Authenticate),
+				}
+				hopSSHConfig.AddHostKey(TestHostKey)
+
+				hopProxy := proxy.New(logger.Session("next-hop"), hopSSHConfig, nil, hopAuditor, nil, proxy.Timeouts{}, proxy.DialConfig{})
+				hopServer = server.NewServer(logger, "127.0.0.1:0", hopProxy)
+				hopServer.SetListener(hopListener)
+				go hopServer.Serve()
+
+				targetConfig := daemonTargetConfig
+				targetConfig.NextHop = &proxy.NextHop{
+					Address:         hopListener.Addr().String(),
+					HostFingerprint: helpers.MD5Fingerprint(TestHostKey.PublicKey()),
+					PrivateKey:      hopKey.PEMEncodedPrivateKey(),
+				}
+				targetConfigJson, err := json.Marshal(targetConfig)
+				Expect(err).NotTo(HaveOccurred())
 
-						req.Reply(true, []byte("response-data"))
-					})
+				proxyAuthenticator.AuthenticateReturns(&ssh.Permissions{
+					CriticalOptions: map[string]string{
+						"proxy-target-config": string(targetConfigJson),
+						"log-message":         `{"guid":"a-guid","message":"a-message","index":1}`,
+						"user-identity":       `{"user_id":"user-guid","realm":"cf"}`,
+					},
+				}, nil)
+
+				client, err = ssh.Dial("tcp", proxyAddress, &ssh.ClientConfig{
+					User: "cf:some-guid/1",
+					Auth: []ssh.AuthMethod{ssh.Password("bearer token")},
 				})
+				Expect(err).NotTo(HaveOccurred())
+			})
 
-				Context("when the target requests a new channel", func() {
-					BeforeEach(func() {
-						connectionHandler.HandleConnectionStub = func(conn net.Conn) {
-							defer GinkgoRecover()
+			AfterEach(func() {
+				client.Close()
+				hopServer.Shutdown()
+			})
 
-							serverConn, _, _, err := ssh.NewServerConn(conn, daemonSSHConfig)
-							Expect(err).NotTo(HaveOccurred())
+			hopEvent := func(eventType string) func() *audit.Event {
+				return func() *audit.Event {
+					for i := 0; i < hopAuditSink.SendCallCount(); i++ {
+						event := hopAuditSink.SendArgsForCall(i)
+						if event.Type == eventType {
+							return &event
+						}
+					}
+					return nil
+				}
+			}
 
-							channel, requests, err := serverConn.OpenChannel("test-channel", []byte("extra-data"))
-							Expect(err).NotTo(HaveOccurred())
-							Expect(channel).NotTo(BeNil())
-							Expect(requests).NotTo(BeClosed())
+			It("runs sessions on the target through the next hop", func() {
+				session, err := client.NewSession()
+				Expect(err).NotTo(HaveOccurred())
 
-							channel.Write([]byte("hello"))
+				output, err := session.Output("echo hello")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(output)).To(Equal("hello\n"))
+			})
 
-							channelResponse := make([]byte, 7)
-							channel.Read(channelResponse)
-							Expect(string(channelResponse)).To(Equal("goodbye"))
+			It("records the origin of the session at the next hop", func() {
+				Eventually(hopEvent(audit.SessionStarted)).ShouldNot(BeNil())
 
-							channel.Close()
-							serverConn.Close()
-						}
-					})
+				event := hopEvent(audit.SessionStarted)()
+				Expect(event.User).To(Equal("cf:some-guid/1"))
+				Expect(event.OriginAddr).To(Equal(client.LocalAddr().String()))
+				Expect(event.OriginSessionID).To(Equal(hex.EncodeToString(client.SessionID())))
+				Expect(event.TargetAddress).To(Equal(daemonAddress))
+				Expect(event.LogGuid).To(Equal("a-guid"))
+				Expect(*event.Index).To(Equal(1))
+			})
 
-					It("gets forwarded to the client", func() {
-						var newChannel ssh.NewChannel
-						Eventually(clientChannels).Should(Receive(&newChannel))
+			It("passes the user and the address of the client to the daemon", func() {
+				session, err := client.NewSession()
+				Expect(err).NotTo(HaveOccurred())
 
-						Expect(newChannel.ChannelType()).To(Equal("test-channel"))
-						Expect(newChannel.ExtraData()).To(Equal([]byte("extra-data")))
+				output, err := session.Output("/bin/echo -n $CF_SSH_USER_ID $CF_SSH_REALM $CF_SSH_CLIENT_ADDR")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(output)).To(Equal("user-guid cf " + client.LocalAddr().String()))
+			})
 
-						channel, requests, err := newChannel.Accept()
-						Expect(err).NotTo(HaveOccurred())
-						Expect(channel).NotTo(BeNil())
-						Expect(requests).NotTo(BeClosed())
+			It("only sends the log message from the first proxy", func() {
+				Eventually(hopEvent(audit.SessionStarted)).ShouldNot(BeNil())
+				Eventually(fakeLogSender.GetLogs).Should(HaveLen(1))
+				Consistently(fakeLogSender.GetLogs).Should(HaveLen(1))
+			})
 
-						channelRequest := make([]byte, 5)
-						channel.Read(channelRequest)
-						Expect(string(channelRequest)).To(Equal("hello"))
+			Context("when the next hop does not trust the hop key", func() {
+				BeforeEach(func() {
+					otherKey, err := keys.RSAKeyPairFactory.NewKeyPair(1024)
+					Expect(err).NotTo(HaveOccurred())
+					trustedKey = otherKey.PublicKey()
+				})
 
-						channel.Write([]byte("goodbye"))
-						channel.Close()
-					})
+				It("fails to reach the target", func() {
+					_, err := client.NewSession()
+					Expect(err).To(HaveOccurred())
+
+					Expect(hopEvent(audit.AuthFailed)()).NotTo(BeNil())
+					Expect(hopEvent(audit.SessionStarted)()).To(BeNil())
 				})
 			})
 		})
@@ -521,8 +1545,9 @@ var _ = Describe("Proxy", func() {
 
 	Describe("ProxyGlobalRequests", func() {
 		var (
-			sshConn *fake_ssh.FakeConn
-			reqChan chan *ssh.Request
+			sshConn      *fake_ssh.FakeConn
+			reqChan      chan *ssh.Request
+			interceptors []proxy.Interceptor
 
 			done chan struct{}
 		)
@@ -530,12 +1555,13 @@ var _ = Describe("Proxy", func() {
 		BeforeEach(func() {
 			sshConn = &fake_ssh.FakeConn{}
 			reqChan = make(chan *ssh.Request, 2)
+			interceptors = nil
 			done = make(chan struct{}, 1)
 		})
 
 		JustBeforeEach(func() {
 			go func(done chan<- struct{}) {
-				proxy.ProxyGlobalRequests(logger, sshConn, reqChan)
+				proxy.ProxyGlobalRequests(logger, sshConn, reqChan, interceptors...)
 				done <- struct{}{}
 			}(done)
 		})
@@ -567,6 +1593,81 @@ var _ = Describe("Proxy", func() {
 			})
 		})
 
+		Context("when an interceptor rejects a request", func() {
+			var interceptor *fake_proxy.FakeInterceptor
+
+			BeforeEach(func() {
+				interceptor = &fake_proxy.FakeInterceptor{}
+				interceptor.GlobalRequestStub = func(logger lager.Logger, req *ssh.Request) error {
+					if req.Type == "tcpip-forward" {
+						return errors.New("no remote forwarding")
+					}
+					return nil
+				}
+				interceptors = append(interceptors, interceptor)
+
+				reqChan <- &ssh.Request{Type: "tcpip-forward", WantReply: false}
+				reqChan <- &ssh.Request{Type: "test", WantReply: false}
+			})
+
+			AfterEach(func() {
+				close(reqChan)
+			})
+
+			It("does not forward the rejected request", func() {
+				Eventually(sshConn.SendRequestCallCount).Should(Equal(1))
+				Consistently(sshConn.SendRequestCallCount).Should(Equal(1))
+
+				reqType, _, _ := sshConn.SendRequestArgsForCall(0)
+				Expect(reqType).To(Equal("test"))
+				Expect(interceptor.GlobalRequestCallCount()).To(Equal(2))
+			})
+		})
+
+		Context("when the policy denies a global request", func() {
+			BeforeEach(func() {
+				policy := &proxy.Policy{AllowedChannelTypes: []string{"session"}}
+				interceptors = append(interceptors, proxy.NewPolicyInterceptor(policy))
+
+				reqChan <- &ssh.Request{Type: "tcpip-forward", WantReply: false}
+				reqChan <- &ssh.Request{Type: "test", WantReply: false}
+			})
+
+			AfterEach(func() {
+				close(reqChan)
+			})
+
+			It("does not forward the denied request", func() {
+				Eventually(sshConn.SendRequestCallCount).Should(Equal(1))
+				Consistently(sshConn.SendRequestCallCount).Should(Equal(1))
+
+				reqType, _, _ := sshConn.SendRequestArgsForCall(0)
+				Expect(reqType).To(Equal("test"))
+			})
+		})
+
+		Context("when the logger is not at debug level", func() {
+			var logBuffer *gbytes.Buffer
+
+			BeforeEach(func() {
+				logBuffer = gbytes.NewBuffer()
+				logger = lager.NewLogger("test")
+				logger.RegisterSink(lager.NewWriterSink(logBuffer, lager.INFO))
+
+				reqChan <- &ssh.Request{Type: "env", Payload: ssh.Marshal(struct{ Name, Value string }{"SECRET", "s3cr3t-value"})}
+			})
+
+			AfterEach(func() {
+				close(reqChan)
+			})
+
+			It("does not log request payloads", func() {
+				Eventually(sshConn.SendRequestCallCount).Should(Equal(1))
+				Eventually(logBuffer).Should(gbytes.Say(`"type":"env"`))
+				Expect(string(logBuffer.Contents())).NotTo(ContainSubstring("payload"))
+			})
+		})
+
 		Context("when SendRequest fails", func() {
 			BeforeEach(func() {
 				callCount := 0
@@ -615,10 +1716,13 @@ var _ = Describe("Proxy", func() {
 			newChan       *fake_ssh.FakeNewChannel
 			sourceChannel *fake_ssh.FakeChannel
 			sourceReqChan chan *ssh.Request
+			sourceStderr  *gbytes.Buffer
 
 			targetChannel *fake_ssh.FakeChannel
 			targetReqChan chan *ssh.Request
 
+			interceptors []proxy.Interceptor
+
 			done chan struct{}
 		)
 
@@ -629,16 +1733,21 @@ var _ = Describe("Proxy", func() {
 			newChan = &fake_ssh.FakeNewChannel{}
 			sourceChannel = &fake_ssh.FakeChannel{}
 			sourceReqChan = make(chan *ssh.Request, 2)
+			sourceStderr = gbytes.NewBuffer()
+			sourceChannel.StderrReturns(sourceStderr)
 
 			targetChannel = &fake_ssh.FakeChannel{}
 			targetReqChan = make(chan *ssh.Request, 2)
+			targetChannel.StderrReturns(&bytes.Buffer{})
+
+			interceptors = nil
 
 			done = make(chan struct{}, 1)
 		})
 
 		JustBeforeEach(func() {
 			go func(done chan<- struct{}) {
-				proxy.ProxyChannels(logger, targetConn, newChanChan)
+				proxy.ProxyChannels(logger, targetConn, newChanChan, interceptors...)
 				done <- struct{}{}
 			}(done)
 		})
@@ -694,6 +1803,55 @@ var _ = Describe("Proxy", func() {
 					})
 				})
 
+				Context("when a recording sink is provided and a pty session is started", func() {
+					var (
+						recording     *gbytes.Buffer
+						recordingSink *fake_recording.FakeSink
+					)
+
+					BeforeEach(func() {
+						recording = gbytes.NewBuffer()
+						recordingSink = &fake_recording.FakeSink{}
+						recordingSink.CreateReturns(recording, nil)
+						interceptors = append(interceptors, proxy.NewRecordingInterceptor(recordingSink))
+
+						newChan.ChannelTypeReturns("session")
+
+						ptyRequest := ssh.Marshal(struct {
+							Term                         string
+							Columns, Rows, Width, Height uint32
+							Modelist                     string
+						}{"xterm", 80, 24, 0, 0, ""})
+						sourceReqChan <- &ssh.Request{Type: "pty-req", Payload: ptyRequest}
+						sourceReqChan <- &ssh.Request{Type: "shell"}
+
+						written := false
+						targetChannel.ReadStub = func(dest []byte) (int, error) {
+							if !written && targetChannel.SendRequestCallCount() == 2 {
+								written = true
+								return copy(dest, []byte("prompt$ ")), nil
+							}
+							if written {
+								return 0, io.EOF
+							}
+							time.Sleep(10 * time.Millisecond)
+							return 0, nil
+						}
+					})
+
+					It("records the output of the session", func() {
+						Eventually(recording.Closed).Should(BeTrue())
+						Expect(recordingSink.CreateCallCount()).To(Equal(1))
+
+						header := recordingSink.CreateArgsForCall(0)
+						Expect(header.Width).To(BeEquivalentTo(80))
+						Expect(header.Height).To(BeEquivalentTo(24))
+						Expect(header.Env).To(HaveKeyWithValue("TERM", "xterm"))
+
+						Expect(recording).To(gbytes.Say(`"o","prompt\$ "`))
+					})
+				})
+
 				Context("when the target channel has data available", func() {
 					BeforeEach(func() {
 						targetChannel.ReadStub = func(dest []byte) (int, error) {
@@ -713,6 +1871,17 @@ var _ = Describe("Proxy", func() {
 					})
 				})
 
+				Context("when the target channel has stderr data available", func() {
+					BeforeEach(func() {
+						targetChannel.StderrReturns(bytes.NewBufferString("oops"))
+					})
+
+					It("copies the stderr of the target channel to the source channel", func() {
+						Eventually(sourceStderr).Should(gbytes.Say("oops"))
+						Eventually(sourceChannel.CloseWriteCallCount).Should(Equal(1))
+					})
+				})
+
 				Context("when the source channel closes", func() {
 					BeforeEach(func() {
 						sourceChannel.ReadReturns(0, io.EOF)
@@ -751,6 +1920,24 @@ var _ = Describe("Proxy", func() {
 					})
 				})
 
+				Context("when the policy denies a request type", func() {
+					BeforeEach(func() {
+						policy := &proxy.Policy{DeniedRequestTypes: []string{"exec"}}
+						interceptors = append(interceptors, proxy.NewPolicyInterceptor(policy))
+
+						sourceReqChan <- &ssh.Request{Type: "exec", WantReply: false, Payload: []byte("denied")}
+						sourceReqChan <- &ssh.Request{Type: "test", WantReply: false, Payload: []byte("allowed")}
+					})
+
+					It("does not forward the denied request to the target", func() {
+						Eventually(targetChannel.SendRequestCallCount).Should(Equal(1))
+						Consistently(targetChannel.SendRequestCallCount).Should(Equal(1))
+
+						reqType, _, _ := targetChannel.SendRequestArgsForCall(0)
+						Expect(reqType).To(Equal("test"))
+					})
+				})
+
 				Context("when out of band requests are received from the target channel", func() {
 					BeforeEach(func() {
 						request := &ssh.Request{Type: "test", WantReply: false, Payload: []byte("test-data")}
@@ -768,6 +1955,125 @@ var _ = Describe("Proxy", func() {
 				})
 			})
 
+			Context("when an interceptor is provided", func() {
+				var (
+					interceptor        *fake_proxy.FakeInterceptor
+					channelInterceptor *fake_proxy.FakeChannelInterceptor
+					input              *gbytes.Buffer
+				)
+
+				BeforeEach(func() {
+					sourceChannel.ReadStub = func(dest []byte) (int, error) {
+						if cap(dest) >= 3 {
+							copy(dest, []byte("abc"))
+							return 3, io.EOF
+						}
+						return 0, io.EOF
+					}
+
+					input = gbytes.NewBuffer()
+					channelInterceptor = &fake_proxy.FakeChannelInterceptor{}
+					channelInterceptor.RequestsStub = func(requests <-chan *ssh.Request) <-chan *ssh.Request {
+						return requests
+					}
+					channelInterceptor.InputStub = func(w io.Writer) io.Writer {
+						return io.MultiWriter(input, w)
+					}
+					channelInterceptor.OutputStub = func(w io.Writer) io.Writer {
+						return w
+					}
+
+					interceptor = &fake_proxy.FakeInterceptor{}
+					interceptor.OpenChannelReturns([]byte("rewritten"), nil)
+					interceptor.ChannelOpenedReturns(channelInterceptor)
+					interceptors = append(interceptors, interceptor)
+				})
+
+				It("opens the target channel with the extra data from the interceptor", func() {
+					Eventually(targetConn.OpenChannelCallCount).Should(Equal(1))
+
+					_, channelType, extraData := interceptor.OpenChannelArgsForCall(0)
+					Expect(channelType).To(Equal("test"))
+					Expect(extraData).To(Equal([]byte("extra-data")))
+
+					_, extraData = targetConn.OpenChannelArgsForCall(0)
+					Expect(extraData).To(Equal([]byte("rewritten")))
+				})
+
+				It("passes the channel data through the channel interceptor", func() {
+					Eventually(input).Should(gbytes.Say("abc"))
+					Eventually(targetChannel.WriteCallCount).ShouldNot(Equal(0))
+				})
+
+				It("closes the channel interceptor once the channel is done", func() {
+					Eventually(channelInterceptor.CloseCallCount).Should(Equal(1))
+				})
+
+				Context("when the interceptor rejects the channel", func() {
+					BeforeEach(func() {
+						interceptor.OpenChannelReturns(nil, errors.New("not today"))
+					})
+
+					It("rejects the source request as prohibited", func() {
+						Eventually(newChan.RejectCallCount).Should(Equal(1))
+						Expect(targetConn.OpenChannelCallCount()).To(Equal(0))
+
+						reason, message := newChan.RejectArgsForCall(0)
+						Expect(reason).To(Equal(ssh.Prohibited))
+						Expect(message).To(Equal("not today"))
+					})
+				})
+			})
+
+			Context("when a transfer quota is configured", func() {
+				var quota *bandwidth.Quota
+
+				BeforeEach(func() {
+					sourceChannel.ReadStub = func(dest []byte) (int, error) {
+						if cap(dest) >= 3 {
+							copy(dest, []byte("abc"))
+							return 3, io.EOF
+						}
+						return 0, io.EOF
+					}
+
+					quota = bandwidth.NewQuota(2)
+					interceptors = append(interceptors, proxy.NewBandwidthInterceptor(quota))
+				})
+
+				It("stops copying once the quota is used up", func() {
+					Eventually(targetChannel.CloseWriteCallCount).Should(Equal(1))
+					Expect(targetChannel.WriteCallCount()).To(Equal(1))
+					Expect(targetChannel.WriteArgsForCall(0)).To(Equal([]byte("ab")))
+				})
+
+				It("rejects new channels once the quota is used up", func() {
+					Eventually(quota.Exhausted).Should(BeTrue())
+
+					newChanChan <- newChan
+					Eventually(newChan.RejectCallCount).Should(Equal(1))
+
+					reason, message := newChan.RejectArgsForCall(0)
+					Expect(reason).To(Equal(ssh.ResourceShortage))
+					Expect(message).To(Equal("transfer quota exceeded"))
+				})
+			})
+
+			Context("when the policy denies the channel type", func() {
+				BeforeEach(func() {
+					policy := &proxy.Policy{AllowedChannelTypes: []string{"session"}}
+					interceptors = append(interceptors, proxy.NewPolicyInterceptor(policy))
+				})
+
+				It("rejects the source request without contacting the target", func() {
+					Eventually(newChan.RejectCallCount).Should(Equal(1))
+					Expect(targetConn.OpenChannelCallCount()).To(Equal(0))
+
+					reason, _ := newChan.RejectArgsForCall(0)
+					Expect(reason).To(Equal(ssh.Prohibited))
+				})
+			})
+
 			Context("when the target rejects the connection", func() {
 				BeforeEach(func() {
 					openError := &ssh.OpenChannelError{
@@ -924,6 +2230,8 @@ var _ = Describe("Proxy", func() {
 			newChannelChan   <-chan ssh.NewChannel
 			requestChannel   <-chan *ssh.Request
 			newClientConnErr error
+
+			dialConfig proxy.DialConfig
 		)
 
 		BeforeEach(func() {
@@ -938,6 +2246,7 @@ var _ = Describe("Proxy", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			sshdListener = listener
+			dialConfig = proxy.DialConfig{}
 		})
 
 		JustBeforeEach(func() {
@@ -946,7 +2255,7 @@ var _ = Describe("Proxy", func() {
 			sshdServer.SetListener(sshdListener)
 			go sshdServer.Serve()
 
-			clientConn, newChannelChan, requestChannel, newClientConnErr = proxy.NewClientConn(logger, permissions)
+			clientConn, newChannelChan, requestChannel, newClientConnErr = proxy.NewClientConn(logger, permissions, dialConfig)
 		})
 
 		AfterEach(func() {
@@ -1021,6 +2330,69 @@ var _ = Describe("Proxy", func() {
 			It("logs the failure", func() {
 				Eventually(logger).Should(gbytes.Say("dial-failed"))
 			})
+
+			It("reports that the target is unreachable", func() {
+				Expect(proxy.TargetErrorReason(newClientConnErr)).To(Equal(proxy.TargetUnreachableErr))
+			})
+
+			Context("when retries are configured", func() {
+				BeforeEach(func() {
+					dialConfig.Retries = 2
+					dialConfig.RetryBackoff = 10 * time.Millisecond
+				})
+
+				It("retries the dial", func() {
+					Expect(newClientConnErr).To(HaveOccurred())
+					Expect(logger).To(gbytes.Say(`retrying.*"attempt":1`))
+					Expect(logger).To(gbytes.Say(`retrying.*"attempt":2`))
+					Expect(logger).NotTo(gbytes.Say(`retrying.*"attempt":3`))
+				})
+			})
+		})
+
+		Context("when the target does not complete the handshake", func() {
+			var silentListener net.Listener
+
+			BeforeEach(func() {
+				var err error
+				silentListener, err = net.Listen("tcp", "127.0.0.1:0")
+				Expect(err).NotTo(HaveOccurred())
+
+				go func() {
+					for {
+						conn, err := silentListener.Accept()
+						if err != nil {
+							return
+						}
+						defer conn.Close()
+					}
+				}()
+
+				permissions.CriticalOptions["proxy-target-config"] = fmt.Sprintf(`{ "address": %q }`, silentListener.Addr().String())
+				dialConfig.HandshakeTimeout = 100 * time.Millisecond
+			})
+
+			AfterEach(func() {
+				silentListener.Close()
+			})
+
+			It("gives up when the handshake timeout expires", func() {
+				Expect(newClientConnErr).To(HaveOccurred())
+				Expect(proxy.TargetErrorReason(newClientConnErr)).To(Equal(proxy.TargetHandshakeTimeoutErr))
+				Expect(logger).To(gbytes.Say("handshake-failed"))
+			})
+		})
+
+		Context("when the target host key does not match", func() {
+			BeforeEach(func() {
+				permissions.CriticalOptions["proxy-target-config"] = fmt.Sprintf(`{ "address": %q, "host_fingerprint": "bogus" }`, sshdListener.Addr().String())
+				dialConfig.Retries = 2
+			})
+
+			It("fails without retrying", func() {
+				Expect(proxy.TargetErrorReason(newClientConnErr)).To(Equal(proxy.TargetHandshakeErr))
+				Expect(logger).NotTo(gbytes.Say("retrying"))
+			})
 		})
 
 		Context("when the config contains a user and password", func() {
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionInfo describes a live proxied connection.
+type SessionInfo struct {
+	ID            string    `json:"id"`
+	User          string    `json:"user"`
+	Principal     string    `json:"principal,omitempty"`
+	Realm         string    `json:"realm,omitempty"`
+	AppGuid       string    `json:"app_guid,omitempty"`
+	Index         *int      `json:"index,omitempty"`
+	Indexes       []int     `json:"indexes,omitempty"`
+	RemoteAddr    string    `json:"remote_addr"`
+	TargetAddress string    `json:"target_address"`
+	StartedAt     time.Time `json:"started_at"`
+	BytesIn       int64     `json:"bytes_in"`
+	BytesOut      int64     `json:"bytes_out"`
+	Channels      int64     `json:"channels"`
+}
+
+// SessionRegistry keeps track of the live connections of a proxy.
+type SessionRegistry struct {
+	lock     sync.Mutex
+	sessions map[string]*TrackedSession
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{
+		sessions: map[string]*TrackedSession{},
+	}
+}
+
+// Register tracks a session until it is unregistered. Terminating the
+// session calls disconnect.
+func (r *SessionRegistry) Register(info SessionInfo, disconnect func()) *TrackedSession {
+	session := &TrackedSession{
+		registry:   r,
+		info:       info,
+		disconnect: disconnect,
+	}
+
+	r.lock.Lock()
+	r.sessions[info.ID] = session
+	r.lock.Unlock()
+
+	return session
+}
+
+// List returns the sessions matching every non-empty filter, oldest first.
+func (r *SessionRegistry) List(user, appGuid string) []SessionInfo {
+	sessions := r.find(user, appGuid)
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, session.Info())
+	}
+
+	sort.Sort(byStartTime(infos))
+
+	return infos
+}
+
+func (r *SessionRegistry) Terminate(id string) bool {
+	r.lock.Lock()
+	session, ok := r.sessions[id]
+	r.lock.Unlock()
+
+	if ok {
+		session.disconnect()
+	}
+
+	return ok
+}
+
+// TerminateMatching terminates the sessions matching every non-empty filter
+// and returns how many there were.
+func (r *SessionRegistry) TerminateMatching(user, appGuid string) int {
+	sessions := r.find(user, appGuid)
+	for _, session := range sessions {
+		session.disconnect()
+	}
+	return len(sessions)
+}
+
+func (r *SessionRegistry) find(user, appGuid string) []*TrackedSession {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	sessions := []*TrackedSession{}
+	for _, session := range r.sessions {
+		if user != "" && session.info.User != user && session.info.Principal != user {
+			continue
+		}
+		if appGuid != "" && session.info.AppGuid != appGuid {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions
+}
+
+func newSessionInfo(serverConn *ssh.ServerConn, clientConn ssh.Conn, logMessage *LogMessage, fanOutTargets []FanOutTarget, limits *SessionLimits) SessionInfo {
+	info := SessionInfo{
+		ID:            hex.EncodeToString(serverConn.SessionID()),
+		User:          serverConn.User(),
+		AppGuid:       logMessage.Guid,
+		RemoteAddr:    serverConn.RemoteAddr().String(),
+		TargetAddress: clientConn.RemoteAddr().String(),
+		StartedAt:     time.Now(),
+	}
+
+	if fanOutTargets != nil {
+		info.Indexes = fanOutIndexes(fanOutTargets)
+	} else {
+		index := logMessage.Index
+		info.Index = &index
+	}
+
+	if parts := strings.SplitN(info.User, ":", 2); len(parts) == 2 {
+		info.Realm = parts[0]
+	}
+
+	if limits != nil {
+		info.Principal = limits.Principal
+	}
+
+	return info
+}
+
+// TrackedSession is an Interceptor that counts the channels and data of a
+// registered session.
+type TrackedSession struct {
+	registry   *SessionRegistry
+	info       SessionInfo
+	disconnect func()
+
+	bytesIn  int64
+	bytesOut int64
+	channels int64
+}
+
+func (s *TrackedSession) Info() SessionInfo {
+	info := s.info
+	info.BytesIn = atomic.LoadInt64(&s.bytesIn)
+	info.BytesOut = atomic.LoadInt64(&s.bytesOut)
+	info.Channels = atomic.LoadInt64(&s.channels)
+	return info
+}
+
+func (s *TrackedSession) Unregister() {
+	s.registry.lock.Lock()
+	delete(s.registry.sessions, s.info.ID)
+	s.registry.lock.Unlock()
+}
+
+func (s *TrackedSession) OpenChannel(logger lager.Logger, channelType string, extraData []byte) ([]byte, error) {
+	return extraData, nil
+}
+
+func (s *TrackedSession) ChannelOpened(logger lager.Logger, channelType string, extraData []byte) ChannelInterceptor {
+	atomic.AddInt64(&s.channels, 1)
+
+	return &channelObserver{
+		input:  &byteCounter{count: &s.bytesIn},
+		output: &byteCounter{count: &s.bytesOut},
+		close:  func() { atomic.AddInt64(&s.channels, -1) },
+	}
+}
+
+func (s *TrackedSession) GlobalRequest(logger lager.Logger, req *ssh.Request) error {
+	return nil
+}
+
+type byteCounter struct {
+	count *int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.count, int64(len(p)))
+	return len(p), nil
+}
+
+type byStartTime []SessionInfo
+
+func (s byStartTime) Len() int           { return len(s) }
+func (s byStartTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byStartTime) Less(i, j int) bool { return s[i].StartedAt.Before(s[j].StartedAt) }
@@ -0,0 +1,66 @@
+package proxy_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("SessionRegistry", func() {
+	var (
+		registry     *proxy.SessionRegistry
+		disconnected int
+	)
+
+	BeforeEach(func() {
+		registry = proxy.NewSessionRegistry()
+		disconnected = 0
+	})
+
+	It("counts the channels and data of a session", func() {
+		session := registry.Register(proxy.SessionInfo{ID: "id"}, func() {})
+
+		logger := lagertest.NewTestLogger("test")
+		channel := session.ChannelOpened(logger, "session", nil)
+		channel.Input(&discard{}).Write([]byte("abc"))
+		channel.Output(&discard{}).Write([]byte("hello"))
+
+		info := registry.List("", "")[0]
+		Expect(info.BytesIn).To(BeEquivalentTo(3))
+		Expect(info.BytesOut).To(BeEquivalentTo(5))
+		Expect(info.Channels).To(BeEquivalentTo(1))
+
+		channel.Close()
+		Expect(registry.List("", "")[0].Channels).To(BeZero())
+	})
+
+	It("lists sessions oldest first and forgets unregistered ones", func() {
+		now := time.Now()
+		registry.Register(proxy.SessionInfo{ID: "new", StartedAt: now}, func() {})
+		old := registry.Register(proxy.SessionInfo{ID: "old", StartedAt: now.Add(-time.Hour)}, func() {})
+
+		infos := registry.List("", "")
+		Expect(infos).To(HaveLen(2))
+		Expect(infos[0].ID).To(Equal("old"))
+
+		old.Unregister()
+		Expect(registry.List("", "")).To(HaveLen(1))
+		Expect(registry.Terminate("old")).To(BeFalse())
+	})
+
+	It("matches users by name or principal", func() {
+		registry.Register(proxy.SessionInfo{ID: "1", User: "cf:app/0", Principal: "cf:user-guid"}, func() { disconnected++ })
+		registry.Register(proxy.SessionInfo{ID: "2", User: "cf:app/1", Principal: "cf:other-user"}, func() { disconnected++ })
+
+		Expect(registry.List("cf:user-guid", "")).To(HaveLen(1))
+		Expect(registry.TerminateMatching("cf:app/1", "")).To(Equal(1))
+		Expect(disconnected).To(Equal(1))
+	})
+})
+
+type discard struct{}
+
+func (*discard) Write(p []byte) (int, error) { return len(p), nil }
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var PrincipalSessionLimitErr = errors.New("Too many concurrent sessions for this user")
+var TargetSessionLimitErr = errors.New("Too many concurrent sessions for this app instance")
+
+// SessionLimits identifies who a session belongs to and where it goes, and
+// how many concurrent sessions each of them may have. A limit of zero is
+// unlimited. A session that reaches several instances lists each of them in
+// Targets and counts against the limit of every one.
+type SessionLimits struct {
+	Principal            string   `json:"principal,omitempty"`
+	MaxPrincipalSessions int      `json:"max_principal_sessions,omitempty"`
+	Target               string   `json:"target,omitempty"`
+	Targets              []string `json:"targets,omitempty"`
+	MaxTargetSessions    int      `json:"max_target_sessions,omitempty"`
+}
+
+func (l *SessionLimits) targets() []string {
+	if l.Target == "" {
+		return l.Targets
+	}
+	return append([]string{l.Target}, l.Targets...)
+}
+
+func SessionLimitsFromPermissions(permissions *ssh.Permissions) (*SessionLimits, error) {
+	if permissions == nil || permissions.CriticalOptions["session-limits"] == "" {
+		return nil, nil
+	}
+
+	var limits SessionLimits
+	err := json.Unmarshal([]byte(permissions.CriticalOptions["session-limits"]), &limits)
+	if err != nil {
+		return nil, err
+	}
+
+	return &limits, nil
+}
+
+// SessionLimiter counts the sessions of each principal and target. A nil
+// SessionLimiter does not limit anything.
+type SessionLimiter struct {
+	lock       sync.Mutex
+	principals map[string]int
+	targets    map[string]int
+}
+
+func NewSessionLimiter() *SessionLimiter {
+	return &SessionLimiter{
+		principals: map[string]int{},
+		targets:    map[string]int{},
+	}
+}
+
+// Acquire reserves a session within the limits. The returned function must
+// be called once the session ends.
+func (l *SessionLimiter) Acquire(limits *SessionLimits) (func(), error) {
+	if l == nil || limits == nil {
+		return func() {}, nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if exceeded(l.principals, limits.Principal, limits.MaxPrincipalSessions) {
+		return nil, PrincipalSessionLimitErr
+	}
+
+	targets := limits.targets()
+	for _, target := range targets {
+		if exceeded(l.targets, target, limits.MaxTargetSessions) {
+			return nil, TargetSessionLimitErr
+		}
+	}
+
+	increment(l.principals, limits.Principal, 1)
+	for _, target := range targets {
+		increment(l.targets, target, 1)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+
+			increment(l.principals, limits.Principal, -1)
+			for _, target := range targets {
+				increment(l.targets, target, -1)
+			}
+		})
+	}, nil
+}
+
+func exceeded(counts map[string]int, key string, max int) bool {
+	return key != "" && max > 0 && counts[key] >= max
+}
+
+func increment(counts map[string]int, key string, delta int) {
+	if key == "" {
+		return
+	}
+
+	counts[key] += delta
+	if counts[key] <= 0 {
+		delete(counts, key)
+	}
+}
+
+// rejectChannels turns away every channel of a connection that may not be
+// proxied, telling the client why.
+func rejectChannels(channels <-chan ssh.NewChannel, requests <-chan *ssh.Request, err error) {
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		newChannel.Reject(ssh.ResourceShortage, err.Error())
+	}
+}
@@ -0,0 +1,91 @@
+package proxy_test
+
+import (
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SessionLimiter", func() {
+	var limiter *proxy.SessionLimiter
+
+	BeforeEach(func() {
+		limiter = proxy.NewSessionLimiter()
+	})
+
+	It("allows sessions without limits", func() {
+		for i := 0; i < 10; i++ {
+			_, err := limiter.Acquire(&proxy.SessionLimits{Principal: "user", Target: "guid/0"})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		_, err := limiter.Acquire(nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("limits the sessions of a principal", func() {
+		limits := &proxy.SessionLimits{Principal: "user", MaxPrincipalSessions: 2}
+
+		release, err := limiter.Acquire(limits)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = limiter.Acquire(limits)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = limiter.Acquire(limits)
+		Expect(err).To(Equal(proxy.PrincipalSessionLimitErr))
+
+		_, err = limiter.Acquire(&proxy.SessionLimits{Principal: "other-user", MaxPrincipalSessions: 2})
+		Expect(err).NotTo(HaveOccurred())
+
+		release()
+		release()
+
+		_, err = limiter.Acquire(limits)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = limiter.Acquire(limits)
+		Expect(err).To(Equal(proxy.PrincipalSessionLimitErr))
+	})
+
+	It("limits the sessions of a target", func() {
+		_, err := limiter.Acquire(&proxy.SessionLimits{Principal: "user", Target: "guid/0", MaxTargetSessions: 1})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = limiter.Acquire(&proxy.SessionLimits{Principal: "other-user", Target: "guid/0", MaxTargetSessions: 1})
+		Expect(err).To(Equal(proxy.TargetSessionLimitErr))
+
+		_, err = limiter.Acquire(&proxy.SessionLimits{Principal: "other-user", Target: "guid/1", MaxTargetSessions: 1})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("counts a session against the limit of each of its targets", func() {
+		_, err := limiter.Acquire(&proxy.SessionLimits{Target: "guid/2", MaxTargetSessions: 1})
+		Expect(err).NotTo(HaveOccurred())
+
+		release, err := limiter.Acquire(&proxy.SessionLimits{Targets: []string{"guid/0", "guid/1"}, MaxTargetSessions: 1})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = limiter.Acquire(&proxy.SessionLimits{Target: "guid/1", MaxTargetSessions: 1})
+		Expect(err).To(Equal(proxy.TargetSessionLimitErr))
+
+		_, err = limiter.Acquire(&proxy.SessionLimits{Targets: []string{"guid/1", "guid/2"}, MaxTargetSessions: 1})
+		Expect(err).To(Equal(proxy.TargetSessionLimitErr))
+
+		release()
+
+		_, err = limiter.Acquire(&proxy.SessionLimits{Target: "guid/1", MaxTargetSessions: 1})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("does not count a session rejected by the target limit against the principal", func() {
+		_, err := limiter.Acquire(&proxy.SessionLimits{Target: "guid/0", MaxTargetSessions: 1})
+		Expect(err).NotTo(HaveOccurred())
+
+		limits := &proxy.SessionLimits{Principal: "user", MaxPrincipalSessions: 1, Target: "guid/0", MaxTargetSessions: 1}
+		_, err = limiter.Acquire(limits)
+		Expect(err).To(Equal(proxy.TargetSessionLimitErr))
+
+		_, err = limiter.Acquire(&proxy.SessionLimits{Principal: "user", MaxPrincipalSessions: 1})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,279 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+type Timeouts struct {
+	// IdleTimeout closes sessions without channel data in either direction.
+	IdleTimeout time.Duration
+
+	// MaxDuration closes sessions that have been open for longer.
+	MaxDuration time.Duration
+
+	// Warning is how long before the session is closed that pty sessions are
+	// told about it.
+	Warning time.Duration
+}
+
+// SessionTimer is an Interceptor that tracks channel activity and disconnects
+// the session once it has been idle or open for too long, or once a drain
+// deadline has passed.
+type SessionTimer struct {
+	logger     lager.Logger
+	timeouts   Timeouts
+	disconnect func()
+	changed    chan struct{}
+
+	lock          sync.Mutex
+	started       time.Time
+	lastActivity  time.Time
+	drainDeadline time.Time
+	drainReason   string
+	terminals     map[*terminal]struct{}
+}
+
+func NewSessionTimer(logger lager.Logger, timeouts Timeouts, disconnect func()) *SessionTimer {
+	now := time.Now()
+
+	return &SessionTimer{
+		logger:       logger.Session("session-timer"),
+		timeouts:     timeouts,
+		disconnect:   disconnect,
+		changed:      make(chan struct{}, 1),
+		started:      now,
+		lastActivity: now,
+		terminals:    map[*terminal]struct{}{},
+	}
+}
+
+// Run warns and disconnects the session when a timeout expires. It returns
+// when the session has been disconnected or done is closed.
+func (t *SessionTimer) Run(done <-chan struct{}) {
+	var warnedFor, announcedDrain time.Time
+
+	for {
+		deadline, reason := t.deadline()
+		if deadline.IsZero() {
+			select {
+			case <-t.changed:
+				continue
+			case <-done:
+				return
+			}
+		}
+
+		warnAt := deadline.Add(-t.timeouts.Warning)
+		now := time.Now()
+
+		if drain, drainReason := t.drain(); !drain.IsZero() && !announcedDrain.Equal(drain) && now.Before(drain) {
+			announcedDrain = drain
+			t.notify(fmt.Sprintf("\r\nThis session will be closed in %s: %s.\r\n", roundDuration(drain.Sub(now)), drainReason))
+			if drain.Equal(deadline) && !now.Before(warnAt) {
+				warnedFor = deadline
+			}
+		}
+
+		if !now.Before(deadline) {
+			t.logger.Info("disconnecting", lager.Data{"reason": reason})
+			t.notify(fmt.Sprintf("\r\nClosing session: %s.\r\n", reason))
+			t.disconnect()
+			return
+		}
+
+		next := deadline
+		if now.Before(warnAt) {
+			next = warnAt
+		} else if t.timeouts.Warning > 0 && !warnedFor.Equal(deadline) {
+			warnedFor = deadline
+			t.notify(fmt.Sprintf("\r\nThis session will be closed in %s: %s.\r\n", roundDuration(deadline.Sub(now)), reason))
+		}
+
+		select {
+		case <-time.After(next.Sub(now)):
+		case <-t.changed:
+		case <-done:
+			return
+		}
+	}
+}
+
+// Drain closes the session at the deadline, warning pty sessions straight
+// away and again before it passes. A zero deadline cancels the drain.
+func (t *SessionTimer) Drain(deadline time.Time, reason string) {
+	t.lock.Lock()
+	t.drainDeadline = deadline
+	t.drainReason = reason
+	t.lock.Unlock()
+
+	select {
+	case t.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (t *SessionTimer) drain() (time.Time, string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.drainDeadline, t.drainReason
+}
+
+func (t *SessionTimer) deadline() (time.Time, string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var deadline time.Time
+	var reason string
+
+	if t.timeouts.IdleTimeout > 0 {
+		deadline = t.lastActivity.Add(t.timeouts.IdleTimeout)
+		reason = "idle timeout exceeded"
+	}
+
+	if t.timeouts.MaxDuration > 0 {
+		maxDeadline := t.started.Add(t.timeouts.MaxDuration)
+		if deadline.IsZero() || maxDeadline.Before(deadline) {
+			deadline = maxDeadline
+			reason = "maximum session duration exceeded"
+		}
+	}
+
+	if !t.drainDeadline.IsZero() && (deadline.IsZero() || t.drainDeadline.Before(deadline)) {
+		deadline = t.drainDeadline
+		reason = t.drainReason
+	}
+
+	return deadline, reason
+}
+
+func (t *SessionTimer) touch() {
+	t.lock.Lock()
+	t.lastActivity = time.Now()
+	t.lock.Unlock()
+}
+
+func (t *SessionTimer) notify(message string) {
+	t.lock.Lock()
+	terminals := []*terminal{}
+	for term := range t.terminals {
+		terminals = append(terminals, term)
+	}
+	t.lock.Unlock()
+
+	for _, term := range terminals {
+		term.write(message)
+	}
+}
+
+func (t *SessionTimer) OpenChannel(logger lager.Logger, channelType string, extraData []byte) ([]byte, error) {
+	t.touch()
+	return extraData, nil
+}
+
+func (t *SessionTimer) ChannelOpened(logger lager.Logger, channelType string, extraData []byte) ChannelInterceptor {
+	return &timedChannel{timer: t}
+}
+
+func (t *SessionTimer) GlobalRequest(logger lager.Logger, req *ssh.Request) error {
+	return nil
+}
+
+type timedChannel struct {
+	timer *SessionTimer
+
+	lock     sync.Mutex
+	pty      bool
+	terminal *terminal
+}
+
+func (c *timedChannel) Requests(requests <-chan *ssh.Request) <-chan *ssh.Request {
+	observed := make(chan *ssh.Request)
+
+	go func() {
+		defer close(observed)
+		for req := range requests {
+			c.timer.touch()
+			if req.Type == "pty-req" {
+				c.lock.Lock()
+				c.pty = true
+				if c.terminal != nil {
+					c.timer.lock.Lock()
+					c.timer.terminals[c.terminal] = struct{}{}
+					c.timer.lock.Unlock()
+				}
+				c.lock.Unlock()
+			}
+			observed <- req
+		}
+	}()
+
+	return observed
+}
+
+func (c *timedChannel) Input(w io.Writer) io.Writer {
+	return &activityWriter{timer: c.timer, writer: w}
+}
+
+func (c *timedChannel) Output(w io.Writer) io.Writer {
+	c.lock.Lock()
+	c.terminal = &terminal{writer: w}
+	if c.pty {
+		c.timer.lock.Lock()
+		c.timer.terminals[c.terminal] = struct{}{}
+		c.timer.lock.Unlock()
+	}
+	c.lock.Unlock()
+
+	return &activityWriter{timer: c.timer, writer: c.terminal}
+}
+
+func (c *timedChannel) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.terminal != nil {
+		c.timer.lock.Lock()
+		delete(c.timer.terminals, c.terminal)
+		c.timer.lock.Unlock()
+	}
+}
+
+type activityWriter struct {
+	timer  *SessionTimer
+	writer io.Writer
+}
+
+func (w *activityWriter) Write(p []byte) (int, error) {
+	w.timer.touch()
+	return w.writer.Write(p)
+}
+
+// terminal serializes warnings with the output of a pty session.
+type terminal struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+func (t *terminal) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.writer.Write(p)
+}
+
+func (t *terminal) write(message string) {
+	t.Write([]byte(message))
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d
+	}
+	return (d + time.Second/2) / time.Second * time.Second
+}
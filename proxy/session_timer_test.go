@@ -0,0 +1,116 @@
+package proxy_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/pivotal-golang/lager/lagertest"
+	"golang.org/x/crypto/ssh"
+)
+
+var _ = Describe("SessionTimer", func() {
+	var (
+		timeouts     proxy.Timeouts
+		timer        *proxy.SessionTimer
+		disconnected chan struct{}
+		done         chan struct{}
+		output       *gbytes.Buffer
+		requests     chan *ssh.Request
+	)
+
+	BeforeEach(func() {
+		timeouts = proxy.Timeouts{}
+		disconnected = make(chan struct{})
+		done = make(chan struct{})
+		output = gbytes.NewBuffer()
+		requests = make(chan *ssh.Request, 1)
+	})
+
+	JustBeforeEach(func() {
+		timer = proxy.NewSessionTimer(lagertest.NewTestLogger("test"), timeouts, func() {
+			close(disconnected)
+		})
+
+		channel := timer.ChannelOpened(lagertest.NewTestLogger("test"), "session", nil)
+		channel.Output(output)
+		observed := channel.Requests(requests)
+
+		requests <- &ssh.Request{Type: "pty-req"}
+		Eventually(observed).Should(Receive())
+
+		go timer.Run(done)
+	})
+
+	AfterEach(func() {
+		close(done)
+	})
+
+	Context("when the session is idle", func() {
+		BeforeEach(func() {
+			timeouts.IdleTimeout = 300 * time.Millisecond
+			timeouts.Warning = 200 * time.Millisecond
+		})
+
+		It("warns the terminal and then disconnects", func() {
+			Eventually(output).Should(gbytes.Say(`This session will be closed in .*: idle timeout exceeded`))
+			Eventually(disconnected).Should(BeClosed())
+			Expect(output).To(gbytes.Say(`Closing session: idle timeout exceeded`))
+		})
+	})
+
+	Context("when the session is drained", func() {
+		It("warns the terminal straight away and disconnects at the deadline", func() {
+			timer.Drain(time.Now().Add(300*time.Millisecond), "maintenance")
+
+			Eventually(output).Should(gbytes.Say(`This session will be closed in .*: maintenance`))
+			Eventually(disconnected).Should(BeClosed())
+			Expect(output).To(gbytes.Say(`Closing session: maintenance`))
+		})
+
+		It("does not disconnect when the drain is cancelled", func() {
+			timer.Drain(time.Now().Add(200*time.Millisecond), "maintenance")
+			timer.Drain(time.Time{}, "")
+
+			Consistently(disconnected, 400*time.Millisecond).ShouldNot(BeClosed())
+		})
+	})
+
+	Context("when no timeouts are configured", func() {
+		It("does not disconnect", func() {
+			Consistently(disconnected, 300*time.Millisecond).ShouldNot(BeClosed())
+		})
+	})
+
+	Context("when channel data keeps flowing", func() {
+		BeforeEach(func() {
+			timeouts.IdleTimeout = 200 * time.Millisecond
+		})
+
+		It("does not disconnect", func() {
+			channel := timer.ChannelOpened(lagertest.NewTestLogger("test"), "direct-tcpip", nil)
+			input := channel.Input(gbytes.NewBuffer())
+
+			for i := 0; i < 6; i++ {
+				time.Sleep(50 * time.Millisecond)
+				input.Write([]byte("data"))
+			}
+
+			Expect(disconnected).NotTo(BeClosed())
+		})
+	})
+
+	Context("when the maximum duration is exceeded", func() {
+		BeforeEach(func() {
+			timeouts.IdleTimeout = time.Hour
+			timeouts.MaxDuration = 200 * time.Millisecond
+		})
+
+		It("disconnects", func() {
+			Eventually(disconnected).Should(BeClosed())
+			Expect(output).To(gbytes.Say(`Closing session: maximum session duration exceeded`))
+		})
+	})
+})
@@ -0,0 +1,33 @@
+package proxy
+
+import "golang.org/x/crypto/ssh"
+
+// SetConnectionSettings replaces the session timeouts and target dial
+// configuration used by new connections. Connections in progress keep the
+// settings they started with.
+func (p *Proxy) SetConnectionSettings(timeouts Timeouts, dialConfig DialConfig) {
+	p.configLock.Lock()
+	defer p.configLock.Unlock()
+
+	p.timeouts = timeouts
+	p.dialConfig = dialConfig
+}
+
+// Reconfigure replaces the server configuration and the connection settings
+// at once so that no new connection sees a mix of old and new settings.
+func (p *Proxy) Reconfigure(serverConfig *ssh.ServerConfig, hostKeys []ssh.Signer, timeouts Timeouts, dialConfig DialConfig) {
+	p.configLock.Lock()
+	defer p.configLock.Unlock()
+
+	p.serverConfig = serverConfig
+	p.hostKeys = hostKeys
+	p.timeouts = timeouts
+	p.dialConfig = dialConfig
+}
+
+func (p *Proxy) currentSettings() (*ssh.ServerConfig, []ssh.Signer, Timeouts, DialConfig) {
+	p.configLock.RLock()
+	defer p.configLock.RUnlock()
+
+	return p.serverConfig, p.hostKeys, p.timeouts, p.dialConfig
+}
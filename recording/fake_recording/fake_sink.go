@@ -0,0 +1,56 @@
+// This file was generated by counterfeiter
+package fake_recording
+
+import (
+	"io"
+	"sync"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/recording"
+)
+
+type FakeSink struct {
+	CreateStub        func(header recording.Header) (io.WriteCloser, error)
+	createMutex       sync.RWMutex
+	createArgsForCall []struct {
+		header recording.Header
+	}
+	createReturns struct {
+		result1 io.WriteCloser
+		result2 error
+	}
+}
+
+func (fake *FakeSink) Create(header recording.Header) (io.WriteCloser, error) {
+	fake.createMutex.Lock()
+	fake.createArgsForCall = append(fake.createArgsForCall, struct {
+		header recording.Header
+	}{header})
+	fake.createMutex.Unlock()
+	if fake.CreateStub != nil {
+		return fake.CreateStub(header)
+	} else {
+		return fake.createReturns.result1, fake.createReturns.result2
+	}
+}
+
+func (fake *FakeSink) CreateCallCount() int {
+	fake.createMutex.RLock()
+	defer fake.createMutex.RUnlock()
+	return len(fake.createArgsForCall)
+}
+
+func (fake *FakeSink) CreateArgsForCall(i int) recording.Header {
+	fake.createMutex.RLock()
+	defer fake.createMutex.RUnlock()
+	return fake.createArgsForCall[i].header
+}
+
+func (fake *FakeSink) CreateReturns(result1 io.WriteCloser, result2 error) {
+	fake.CreateStub = nil
+	fake.createReturns = struct {
+		result1 io.WriteCloser
+		result2 error
+	}{result1, result2}
+}
+
+var _ recording.Sink = new(FakeSink)
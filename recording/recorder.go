@@ -0,0 +1,169 @@
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	OutputEvent = "o"
+	InputEvent  = "i"
+	ResizeEvent = "r"
+)
+
+// Header is the first line of an asciicast v2 recording.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     uint32            `json:"width"`
+	Height    uint32            `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder writes the timed input, output, and window changes of a terminal
+// session to a sink as an asciicast v2 stream. A nil recorder discards
+// everything.
+type Recorder struct {
+	mutex  sync.Mutex
+	writer io.WriteCloser
+	start  time.Time
+	closed bool
+
+	pending map[string][]byte
+}
+
+func NewRecorder(sink Sink, header Header) (*Recorder, error) {
+	start := time.Now()
+
+	header.Version = 2
+	if header.Timestamp == 0 {
+		header.Timestamp = start.Unix()
+	}
+
+	writer, err := sink.Create(header)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(header)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	_, err = writer.Write(append(payload, '\n'))
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	return &Recorder{
+		writer:  writer,
+		start:   start,
+		pending: map[string][]byte{},
+	}, nil
+}
+
+func (r *Recorder) Output() io.Writer {
+	if r == nil {
+		return ioutil.Discard
+	}
+	return &eventWriter{recorder: r, eventType: OutputEvent}
+}
+
+func (r *Recorder) Input() io.Writer {
+	if r == nil {
+		return ioutil.Discard
+	}
+	return &eventWriter{recorder: r, eventType: InputEvent}
+}
+
+func (r *Recorder) Resize(columns, rows uint32) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.writeEvent(ResizeEvent, fmt.Sprintf("%dx%d", columns, rows))
+}
+
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	for eventType, data := range r.pending {
+		if len(data) > 0 {
+			r.writeEvent(eventType, string(data))
+		}
+	}
+
+	return r.writer.Close()
+}
+
+func (r *Recorder) record(eventType string, p []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	data := append(r.pending[eventType], p...)
+
+	// hold back a trailing partial rune until the rest of it arrives
+	complete := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				complete = i
+			}
+			break
+		}
+	}
+
+	r.pending[eventType] = append([]byte{}, data[complete:]...)
+	if complete == 0 {
+		return nil
+	}
+
+	return r.writeEvent(eventType, string(data[:complete]))
+}
+
+func (r *Recorder) writeEvent(eventType string, data string) error {
+	if r.closed {
+		return nil
+	}
+
+	elapsed := time.Since(r.start).Seconds()
+	payload, err := json.Marshal([]interface{}{elapsed, eventType, data})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.writer.Write(append(payload, '\n'))
+	return err
+}
+
+type eventWriter struct {
+	recorder  *Recorder
+	eventType string
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	w.recorder.record(w.eventType, p)
+	return len(p), nil
+}
@@ -0,0 +1,154 @@
+package recording_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/recording"
+	"github.com/cloudfoundry-incubator/diego-ssh/recording/fake_recording"
+	"github.com/onsi/gomega/gbytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Recorder", func() {
+	var (
+		sink     *fake_recording.FakeSink
+		buffer   *gbytes.Buffer
+		recorder *recording.Recorder
+	)
+
+	BeforeEach(func() {
+		buffer = gbytes.NewBuffer()
+		sink = &fake_recording.FakeSink{}
+		sink.CreateReturns(buffer, nil)
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		recorder, err = recording.NewRecorder(sink, recording.Header{
+			Width:   80,
+			Height:  24,
+			Command: "/bin/bash",
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	lines := func() []string {
+		result := []string{}
+		scanner := bufio.NewScanner(gbytes.BufferWithBytes(buffer.Contents()))
+		for scanner.Scan() {
+			result = append(result, scanner.Text())
+		}
+		return result
+	}
+
+	It("writes an asciicast v2 header", func() {
+		Expect(sink.CreateCallCount()).To(Equal(1))
+		Expect(sink.CreateArgsForCall(0).Version).To(Equal(2))
+		Expect(sink.CreateArgsForCall(0).Timestamp).NotTo(BeZero())
+
+		var header recording.Header
+		Expect(json.Unmarshal([]byte(lines()[0]), &header)).To(Succeed())
+		Expect(header.Version).To(Equal(2))
+		Expect(header.Width).To(BeEquivalentTo(80))
+		Expect(header.Height).To(BeEquivalentTo(24))
+		Expect(header.Command).To(Equal("/bin/bash"))
+	})
+
+	It("records timed output, input, and resize events", func() {
+		recorder.Output().Write([]byte("$ "))
+		recorder.Input().Write([]byte("ls\r"))
+		recorder.Resize(132, 50)
+		Expect(recorder.Close()).To(Succeed())
+
+		events := lines()[1:]
+		Expect(events).To(HaveLen(3))
+
+		var event recording.Event
+		Expect(json.Unmarshal([]byte(events[0]), &event)).To(Succeed())
+		Expect(event.Type).To(Equal("o"))
+		Expect(event.Data).To(Equal("$ "))
+
+		Expect(json.Unmarshal([]byte(events[1]), &event)).To(Succeed())
+		Expect(event.Type).To(Equal("i"))
+		Expect(event.Data).To(Equal("ls\r"))
+
+		Expect(json.Unmarshal([]byte(events[2]), &event)).To(Succeed())
+		Expect(event.Type).To(Equal("r"))
+		Expect(event.Data).To(Equal("132x50"))
+		Expect(event.Time).To(BeNumerically(">=", 0))
+	})
+
+	It("does not split multi-byte characters across events", func() {
+		snowman := []byte("☃")
+		recorder.Output().Write(snowman[:1])
+		recorder.Output().Write(snowman[1:])
+		Expect(recorder.Close()).To(Succeed())
+
+		events := lines()[1:]
+		Expect(events).To(HaveLen(1))
+
+		var event recording.Event
+		Expect(json.Unmarshal([]byte(events[0]), &event)).To(Succeed())
+		Expect(event.Data).To(Equal("☃"))
+	})
+
+	It("closes the sink writer once", func() {
+		Expect(recorder.Close()).To(Succeed())
+		Expect(recorder.Close()).To(Succeed())
+		Expect(buffer.Closed()).To(BeTrue())
+	})
+
+	Context("when the sink fails", func() {
+		It("returns the error", func() {
+			sink.CreateReturns(nil, errors.New("boom"))
+			_, err := recording.NewRecorder(sink, recording.Header{})
+			Expect(err).To(MatchError("boom"))
+		})
+	})
+
+	Context("when the recorder is nil", func() {
+		It("discards everything", func() {
+			var nilRecorder *recording.Recorder
+			_, err := nilRecorder.Output().Write([]byte("data"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nilRecorder.Resize(1, 1)).To(Succeed())
+			Expect(nilRecorder.Close()).To(Succeed())
+		})
+	})
+})
+
+var _ = Describe("FileSink", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "recordings")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("creates a private cast file in the directory", func() {
+		sink := recording.NewFileSink(dir)
+		recorder, err := recording.NewRecorder(sink, recording.Header{Width: 80, Height: 24})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Close()).To(Succeed())
+
+		matches, err := filepath.Glob(filepath.Join(dir, "*.cast"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matches).To(HaveLen(1))
+
+		info, err := os.Stat(matches[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+})
@@ -0,0 +1,112 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var InvalidRecordingErr = errors.New("Invalid recording")
+
+type Event struct {
+	Time float64
+	Type string
+	Data string
+}
+
+func (e *Event) UnmarshalJSON(payload []byte) error {
+	var fields []interface{}
+	err := json.Unmarshal(payload, &fields)
+	if err != nil {
+		return err
+	}
+
+	if len(fields) != 3 {
+		return InvalidRecordingErr
+	}
+
+	var ok bool
+	if e.Time, ok = fields[0].(float64); !ok {
+		return InvalidRecordingErr
+	}
+	if e.Type, ok = fields[1].(string); !ok {
+		return InvalidRecordingErr
+	}
+	if e.Data, ok = fields[2].(string); !ok {
+		return InvalidRecordingErr
+	}
+
+	return nil
+}
+
+type ReplayOptions struct {
+	Speed   float64
+	MaxIdle time.Duration
+	Sleep   func(time.Duration)
+}
+
+// Replay writes the output events of an asciicast v2 recording to w,
+// reproducing the recorded timing. Idle periods longer than MaxIdle are
+// shortened to MaxIdle.
+func Replay(w io.Writer, r io.Reader, options ReplayOptions) (Header, error) {
+	if options.Speed <= 0 {
+		options.Speed = 1
+	}
+	if options.Sleep == nil {
+		options.Sleep = time.Sleep
+	}
+
+	reader := bufio.NewReader(r)
+
+	var header Header
+	line, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return header, err
+	}
+
+	err = json.Unmarshal(line, &header)
+	if err != nil || header.Version != 2 {
+		return header, InvalidRecordingErr
+	}
+
+	var last float64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return header, err
+		}
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			var event Event
+			jsonErr := json.Unmarshal(line, &event)
+			if jsonErr != nil {
+				return header, fmt.Errorf("%s: %s", InvalidRecordingErr, jsonErr)
+			}
+
+			if event.Type == OutputEvent {
+				delay := time.Duration((event.Time - last) / options.Speed * float64(time.Second))
+				if options.MaxIdle > 0 && delay > options.MaxIdle {
+					delay = options.MaxIdle
+				}
+				last = event.Time
+
+				if delay > 0 {
+					options.Sleep(delay)
+				}
+
+				_, writeErr := io.WriteString(w, event.Data)
+				if writeErr != nil {
+					return header, writeErr
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return header, nil
+		}
+	}
+}
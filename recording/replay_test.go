@@ -0,0 +1,75 @@
+package recording_test
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/recording"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replay", func() {
+	var (
+		output  *bytes.Buffer
+		delays  []time.Duration
+		options recording.ReplayOptions
+	)
+
+	BeforeEach(func() {
+		output = &bytes.Buffer{}
+		delays = []time.Duration{}
+		options = recording.ReplayOptions{
+			Sleep: func(d time.Duration) { delays = append(delays, d) },
+		}
+	})
+
+	cast := strings.Join([]string{
+		`{"version":2,"width":80,"height":24,"timestamp":1}`,
+		`[0.5,"o","$ "]`,
+		`[1.0,"i","ls\r"]`,
+		`[1.5,"o","file\r\n"]`,
+		`[11.5,"r","100x40"]`,
+		`[21.5,"o","$ "]`,
+		``,
+	}, "\n")
+
+	It("writes the output events with the recorded timing", func() {
+		header, err := recording.Replay(output, strings.NewReader(cast), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header.Width).To(BeEquivalentTo(80))
+
+		Expect(output.String()).To(Equal("$ file\r\n$ "))
+		Expect(delays).To(Equal([]time.Duration{500 * time.Millisecond, time.Second, 20 * time.Second}))
+	})
+
+	It("adjusts the timing by the speed", func() {
+		options.Speed = 2
+		_, err := recording.Replay(output, strings.NewReader(cast), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(delays).To(Equal([]time.Duration{250 * time.Millisecond, 500 * time.Millisecond, 10 * time.Second}))
+	})
+
+	It("caps idle time", func() {
+		options.MaxIdle = time.Second
+		_, err := recording.Replay(output, strings.NewReader(cast), options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(delays).To(Equal([]time.Duration{500 * time.Millisecond, time.Second, time.Second}))
+	})
+
+	Context("when the recording is not asciicast v2", func() {
+		It("returns an error", func() {
+			_, err := recording.Replay(output, strings.NewReader(`{"version":1}`), options)
+			Expect(err).To(Equal(recording.InvalidRecordingErr))
+		})
+	})
+
+	Context("when an event is malformed", func() {
+		It("returns an error", func() {
+			_, err := recording.Replay(output, strings.NewReader(`{"version":2}`+"\n"+`[1,"o"]`), options)
+			Expect(err).To(MatchError(ContainSubstring("Invalid recording")))
+		})
+	})
+})
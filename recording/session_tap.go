@@ -0,0 +1,158 @@
+package recording
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionTap records a proxied session channel. Recording starts when a shell
+// or command is requested after a pty has been allocated; sessions without a
+// pty are not recorded. A nil tap records nothing.
+type SessionTap struct {
+	logger lager.Logger
+	sink   Sink
+
+	mutex    sync.Mutex
+	pty      bool
+	header   Header
+	recorder *Recorder
+}
+
+func NewSessionTap(logger lager.Logger, sink Sink) *SessionTap {
+	return &SessionTap{
+		logger: logger.Session("session-tap"),
+		sink:   sink,
+	}
+}
+
+// Requests observes the requests sent to the session before passing them on.
+func (t *SessionTap) Requests(requests <-chan *ssh.Request) <-chan *ssh.Request {
+	if t == nil {
+		return requests
+	}
+
+	observed := make(chan *ssh.Request)
+
+	go func() {
+		defer close(observed)
+		for req := range requests {
+			t.observe(req)
+			observed <- req
+		}
+	}()
+
+	return observed
+}
+
+func (t *SessionTap) Input() io.Writer {
+	if t == nil {
+		return ioutil.Discard
+	}
+	return &tapWriter{tap: t, input: true}
+}
+
+func (t *SessionTap) Output() io.Writer {
+	if t == nil {
+		return ioutil.Discard
+	}
+	return &tapWriter{tap: t}
+}
+
+func (t *SessionTap) Close() error {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.recorder.Close()
+}
+
+func (t *SessionTap) observe(req *ssh.Request) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	switch req.Type {
+	case "pty-req":
+		var ptyRequest struct {
+			Term     string
+			Columns  uint32
+			Rows     uint32
+			Width    uint32
+			Height   uint32
+			Modelist string
+		}
+		if ssh.Unmarshal(req.Payload, &ptyRequest) != nil {
+			return
+		}
+
+		t.pty = true
+		t.header.Width = ptyRequest.Columns
+		t.header.Height = ptyRequest.Rows
+		t.header.Env = map[string]string{"TERM": ptyRequest.Term}
+
+	case "window-change":
+		var windowChange struct {
+			Columns  uint32
+			Rows     uint32
+			WidthPx  uint32
+			HeightPx uint32
+		}
+		if ssh.Unmarshal(req.Payload, &windowChange) != nil {
+			return
+		}
+
+		t.header.Width = windowChange.Columns
+		t.header.Height = windowChange.Rows
+		t.recorder.Resize(windowChange.Columns, windowChange.Rows)
+
+	case "exec":
+		var exec struct {
+			Command string
+		}
+		if ssh.Unmarshal(req.Payload, &exec) != nil {
+			return
+		}
+
+		t.header.Command = exec.Command
+		t.start()
+
+	case "shell":
+		t.start()
+	}
+}
+
+func (t *SessionTap) start() {
+	if !t.pty || t.recorder != nil {
+		return
+	}
+
+	recorder, err := NewRecorder(t.sink, t.header)
+	if err != nil {
+		t.logger.Error("failed-to-create-recorder", err)
+		return
+	}
+
+	t.recorder = recorder
+}
+
+type tapWriter struct {
+	tap   *SessionTap
+	input bool
+}
+
+func (w *tapWriter) Write(p []byte) (int, error) {
+	w.tap.mutex.Lock()
+	recorder := w.tap.recorder
+	w.tap.mutex.Unlock()
+
+	if w.input {
+		return recorder.Input().Write(p)
+	}
+	return recorder.Output().Write(p)
+}
@@ -0,0 +1,91 @@
+package recording_test
+
+import (
+	"github.com/cloudfoundry-incubator/diego-ssh/recording"
+	"github.com/cloudfoundry-incubator/diego-ssh/recording/fake_recording"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/pivotal-golang/lager/lagertest"
+	"golang.org/x/crypto/ssh"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SessionTap", func() {
+	var (
+		sink     *fake_recording.FakeSink
+		buffer   *gbytes.Buffer
+		tap      *recording.SessionTap
+		requests chan *ssh.Request
+		observed <-chan *ssh.Request
+	)
+
+	ptyRequest := func(columns, rows uint32) *ssh.Request {
+		return &ssh.Request{Type: "pty-req", Payload: ssh.Marshal(struct {
+			Term                         string
+			Columns, Rows, Width, Height uint32
+			Modelist                     string
+		}{"xterm", columns, rows, 0, 0, ""})}
+	}
+
+	send := func(req *ssh.Request) {
+		requests <- req
+		Eventually(observed).Should(Receive(Equal(req)))
+	}
+
+	BeforeEach(func() {
+		buffer = gbytes.NewBuffer()
+		sink = &fake_recording.FakeSink{}
+		sink.CreateReturns(buffer, nil)
+
+		tap = recording.NewSessionTap(lagertest.NewTestLogger("test"), sink)
+		requests = make(chan *ssh.Request)
+		observed = tap.Requests(requests)
+	})
+
+	AfterEach(func() {
+		close(requests)
+	})
+
+	Context("when a pty is requested before the shell", func() {
+		BeforeEach(func() {
+			send(ptyRequest(80, 24))
+			send(&ssh.Request{Type: "exec", Payload: ssh.Marshal(struct{ Command string }{"top"})})
+		})
+
+		It("starts a recording with the terminal details", func() {
+			Expect(sink.CreateCallCount()).To(Equal(1))
+			header := sink.CreateArgsForCall(0)
+			Expect(header.Width).To(BeEquivalentTo(80))
+			Expect(header.Height).To(BeEquivalentTo(24))
+			Expect(header.Command).To(Equal("top"))
+			Expect(header.Env).To(HaveKeyWithValue("TERM", "xterm"))
+		})
+
+		It("records input, output, and window changes", func() {
+			tap.Input().Write([]byte("q"))
+			tap.Output().Write([]byte("bye"))
+			send(&ssh.Request{Type: "window-change", Payload: ssh.Marshal(struct {
+				Columns, Rows, WidthPx, HeightPx uint32
+			}{100, 40, 0, 0})})
+			Expect(tap.Close()).To(Succeed())
+
+			Expect(buffer).To(gbytes.Say(`"i","q"`))
+			Expect(buffer).To(gbytes.Say(`"o","bye"`))
+			Expect(buffer).To(gbytes.Say(`"r","100x40"`))
+			Expect(buffer.Closed()).To(BeTrue())
+		})
+	})
+
+	Context("when no pty is requested", func() {
+		BeforeEach(func() {
+			send(&ssh.Request{Type: "shell"})
+		})
+
+		It("does not record the session", func() {
+			tap.Output().Write([]byte("output"))
+			Expect(tap.Close()).To(Succeed())
+			Expect(sink.CreateCallCount()).To(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,36 @@
+package recording
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//go:generate counterfeiter -o fake_recording/fake_sink.go . Sink
+type Sink interface {
+	Create(header Header) (io.WriteCloser, error)
+}
+
+type FileSink struct {
+	dir string
+}
+
+func NewFileSink(dir string) *FileSink {
+	return &FileSink{dir: dir}
+}
+
+func (s *FileSink) Create(header Header) (io.WriteCloser, error) {
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%s-%s.cast", time.Unix(header.Timestamp, 0).UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix))
+
+	return os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+}
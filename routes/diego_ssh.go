@@ -8,4 +8,8 @@ type SSHRoute struct {
 	User            string `json:"user,omitempty"`
 	Password        string `json:"password,omitempty"`
 	PrivateKey      string `json:"private_key,omitempty"`
+
+	// Direct routes are for containers without an SSH daemon. Users may only
+	// forward ports to the ports declared by the LRP.
+	Direct bool `json:"direct,omitempty"`
 }
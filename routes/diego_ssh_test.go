@@ -66,6 +66,14 @@ var _ = Describe("Diego SSH Route", func() {
 				Expect(payload).To(MatchJSON(expectedJson))
 			})
 		})
+		Context("when the route is direct", func() {
+			It("marshals the structure correctly", func() {
+				payload, err := json.Marshal(routes.SSHRoute{Direct: true})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(payload).To(MatchJSON(`{"container_port": 0, "direct": true}`))
+			})
+		})
 	})
 
 	Describe("Round Trip Marshalling", func() {
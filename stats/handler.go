@@ -0,0 +1,12 @@
+package stats
+
+import "net/http"
+
+// NewHandler serves the metrics of the registry whose names start with
+// prefix in the Prometheus text format.
+func NewHandler(registry *Registry, prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		registry.WriteTo(w, prefix)
+	})
+}
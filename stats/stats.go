@@ -0,0 +1,240 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+)
+
+// Default is the registry the metrics of this repository are registered
+// with.
+var Default = NewRegistry()
+
+// Registry holds metric families and writes them in the Prometheus text
+// exposition format.
+type Registry struct {
+	lock     sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// family is a named metric and the values of each of its label sets.
+type family struct {
+	kind          string
+	name          string
+	dropsondeName string
+	help          string
+	labelNames    []string
+
+	lock   sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	count       uint64
+}
+
+func (r *Registry) register(kind, name, dropsondeName, help string, labelNames []string) *family {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		return f
+	}
+
+	f := &family{
+		kind:          kind,
+		name:          name,
+		dropsondeName: dropsondeName,
+		help:          help,
+		labelNames:    labelNames,
+		series:        map[string]*series{},
+	}
+	if len(labelNames) == 0 {
+		f.series[""] = &series{}
+	}
+
+	r.families[name] = f
+	return f
+}
+
+// update applies fn to the series of the label values and returns the
+// dropsonde name of the series.
+func (f *family) update(labelValues []string, fn func(s *series)) string {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("%s: expected %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	f.lock.Lock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		f.series[key] = s
+	}
+	fn(s)
+	f.lock.Unlock()
+
+	return strings.Join(append([]string{f.dropsondeName}, labelValues...), ".")
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	family *family
+}
+
+func (r *Registry) NewCounter(name, dropsondeName, help string, labelNames ...string) *Counter {
+	return &Counter{family: r.register("counter", name, dropsondeName, help, labelNames)}
+}
+
+func NewCounter(name, dropsondeName, help string, labelNames ...string) *Counter {
+	return Default.NewCounter(name, dropsondeName, help, labelNames...)
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(delta uint64, labelValues ...string) {
+	if delta == 0 {
+		return
+	}
+
+	name := c.family.update(labelValues, func(s *series) { s.value += float64(delta) })
+	metrics.AddToCounter(name, delta)
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	family *family
+	unit   string
+}
+
+func (r *Registry) NewGauge(name, dropsondeName, unit, help string, labelNames ...string) *Gauge {
+	return &Gauge{family: r.register("gauge", name, dropsondeName, help, labelNames), unit: unit}
+}
+
+func NewGauge(name, dropsondeName, unit, help string, labelNames ...string) *Gauge {
+	return Default.NewGauge(name, dropsondeName, unit, help, labelNames...)
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	var value float64
+	name := g.family.update(labelValues, func(s *series) {
+		s.value += delta
+		value = s.value
+	})
+	metrics.SendValue(name, value, g.unit)
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	name := g.family.update(labelValues, func(s *series) { s.value = value })
+	metrics.SendValue(name, value, g.unit)
+}
+
+// Summary tracks the count and total of observed durations.
+type Summary struct {
+	family *family
+}
+
+func (r *Registry) NewSummary(name, dropsondeName, help string, labelNames ...string) *Summary {
+	return &Summary{family: r.register("summary", name, dropsondeName, help, labelNames)}
+}
+
+func NewSummary(name, dropsondeName, help string, labelNames ...string) *Summary {
+	return Default.NewSummary(name, dropsondeName, help, labelNames...)
+}
+
+func (s *Summary) Observe(duration time.Duration, labelValues ...string) {
+	name := s.family.update(labelValues, func(s *series) {
+		s.value += duration.Seconds()
+		s.count++
+	})
+	metrics.SendValue(name, float64(duration)/float64(time.Millisecond), "ms")
+}
+
+// WriteTo writes the families whose names start with prefix.
+func (r *Registry) WriteTo(w io.Writer, prefix string) error {
+	r.lock.Lock()
+	families := []*family{}
+	for name, f := range r.families {
+		if strings.HasPrefix(name, prefix) {
+			families = append(families, f)
+		}
+	}
+	r.lock.Unlock()
+
+	sort.Sort(byName(families))
+
+	for _, f := range families {
+		if err := f.write(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *family) write(w io.Writer) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		s := f.series[key]
+		labels := f.labels(s.labelValues)
+
+		if f.kind == "summary" {
+			_, err = fmt.Fprintf(w, "%s_sum%s %v\n%s_count%s %d\n", f.name, labels, s.value, f.name, labels, s.count)
+		} else {
+			_, err = fmt.Fprintf(w, "%s%s %v\n", f.name, labels, s.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (f *family) labels(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, f.labelNames[i], labelEscaper.Replace(value))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+type byName []*family
+
+func (f byName) Len() int           { return len(f) }
+func (f byName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f byName) Less(i, j int) bool { return f[i].name < f[j].name }
@@ -0,0 +1,91 @@
+package stats_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/stats"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var registry *stats.Registry
+
+	BeforeEach(func() {
+		registry = stats.NewRegistry()
+	})
+
+	exposition := func(prefix string) string {
+		buffer := &bytes.Buffer{}
+		Expect(registry.WriteTo(buffer, prefix)).To(Succeed())
+		return buffer.String()
+	}
+
+	It("writes counters by label", func() {
+		counter := registry.NewCounter("test_attempts_total", "Attempts", "Attempts by result", "realm", "result")
+		counter.Inc("cf", "success")
+		counter.Inc("cf", "success")
+		counter.Add(3, "diego", "failure")
+
+		Expect(exposition("")).To(Equal(`# HELP test_attempts_total Attempts by result
+# TYPE test_attempts_total counter
+test_attempts_total{realm="cf",result="success"} 2
+test_attempts_total{realm="diego",result="failure"} 3
+`))
+	})
+
+	It("writes gauges without labels before they are used", func() {
+		gauge := registry.NewGauge("test_connections", "Connections", "Metric", "Open connections")
+		Expect(exposition("")).To(ContainSubstring("test_connections 0\n"))
+
+		gauge.Add(2)
+		gauge.Add(-1)
+		Expect(exposition("")).To(ContainSubstring("test_connections 1\n"))
+
+		gauge.Set(7)
+		Expect(exposition("")).To(ContainSubstring("test_connections 7\n"))
+	})
+
+	It("writes the sum and count of summaries", func() {
+		summary := registry.NewSummary("test_duration_seconds", "Latency", "Request duration", "upstream")
+		summary.Observe(500*time.Millisecond, "cc")
+		summary.Observe(time.Second, "cc")
+
+		output := exposition("")
+		Expect(output).To(ContainSubstring("# TYPE test_duration_seconds summary\n"))
+		Expect(output).To(ContainSubstring(`test_duration_seconds_sum{upstream="cc"} 1.5` + "\n"))
+		Expect(output).To(ContainSubstring(`test_duration_seconds_count{upstream="cc"} 2` + "\n"))
+	})
+
+	It("escapes label values", func() {
+		counter := registry.NewCounter("test_total", "Test", "Test", "type")
+		counter.Inc("a\"b\\c")
+
+		Expect(exposition("")).To(ContainSubstring(`test_total{type="a\"b\\c"} 1`))
+	})
+
+	It("only writes the metrics with the prefix", func() {
+		registry.NewCounter("sshd_total", "Test", "Test")
+		registry.NewCounter("ssh_proxy_total", "Test", "Test")
+
+		Expect(exposition("sshd_")).To(ContainSubstring("sshd_total"))
+		Expect(exposition("sshd_")).NotTo(ContainSubstring("ssh_proxy_total"))
+	})
+
+	It("serves the metrics over http", func() {
+		registry.NewCounter("test_total", "Test", "Test").Inc()
+
+		recorder := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/metrics", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		stats.NewHandler(registry, "").ServeHTTP(recorder, req)
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("text/plain; version=0.0.4"))
+		Expect(recorder.Body.String()).To(ContainSubstring("test_total 1\n"))
+	})
+})
@@ -0,0 +1,145 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/pivotal-golang/lager"
+)
+
+//go:generate counterfeiter -o fake_tracing/fake_exporter.go . Exporter
+type Exporter interface {
+	Export(span *Span) error
+}
+
+// LogExporter logs every span.
+type LogExporter struct {
+	logger lager.Logger
+}
+
+func NewLogExporter(logger lager.Logger) *LogExporter {
+	return &LogExporter{logger: logger.Session("trace")}
+}
+
+func (e *LogExporter) Export(span *Span) error {
+	e.logger.Info("span", lager.Data{
+		"trace-id":   span.TraceID,
+		"span-id":    span.SpanID,
+		"parent-id":  span.ParentID,
+		"name":       span.Name,
+		"duration":   span.Duration,
+		"attributes": span.Attributes,
+		"error":      span.Error,
+	})
+	return nil
+}
+
+// WriterExporter writes each span as a line of JSON.
+type WriterExporter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func NewWriterExporter(writer io.Writer) *WriterExporter {
+	return &WriterExporter{writer: writer}
+}
+
+func NewFileExporter(path string) (*WriterExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWriterExporter(file), nil
+}
+
+func (e *WriterExporter) Export(span *Span) error {
+	payload, err := json.Marshal(span)
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	_, err = e.writer.Write(append(payload, '\n'))
+	return err
+}
+
+// ZipkinExporter posts spans to the v2 API of a Zipkin collector. Spans are
+// posted in the background so a slow collector does not delay logins.
+type ZipkinExporter struct {
+	logger lager.Logger
+	client *http.Client
+	url    string
+}
+
+func NewZipkinExporter(logger lager.Logger, client *http.Client, url string) *ZipkinExporter {
+	return &ZipkinExporter{
+		logger: logger.Session("zipkin-exporter"),
+		client: client,
+		url:    url,
+	}
+}
+
+type zipkinSpan struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Timestamp     int64             `json:"timestamp"`
+	Duration      int64             `json:"duration"`
+	LocalEndpoint map[string]string `json:"localEndpoint"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+func (e *ZipkinExporter) Export(span *Span) error {
+	tags := map[string]string{}
+	for key, value := range span.Attributes {
+		tags[key] = value
+	}
+	if span.Error != "" {
+		tags["error"] = span.Error
+	}
+
+	payload, err := json.Marshal([]zipkinSpan{{
+		TraceID:       span.TraceID,
+		ID:            span.SpanID,
+		ParentID:      span.ParentID,
+		Name:          span.Name,
+		Timestamp:     span.Start.UnixNano() / 1000,
+		Duration:      int64(span.Duration * 1e6),
+		LocalEndpoint: map[string]string{"serviceName": span.Service},
+		Tags:          tags,
+	}})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := e.post(payload); err != nil {
+			e.logger.Error("post-failed", err, lager.Data{"trace-id": span.TraceID})
+		}
+	}()
+
+	return nil
+}
+
+func (e *ZipkinExporter) post(payload []byte) error {
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("zipkin collector responded with %s", resp.Status)
+	}
+
+	return nil
+}
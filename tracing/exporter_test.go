@@ -0,0 +1,142 @@
+package tracing_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing/fake_tracing"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Exporters", func() {
+	var (
+		logger *lagertest.TestLogger
+		span   *tracing.Span
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+
+		tracer := tracing.NewTracer(logger, "ssh-proxy", &fake_tracing.FakeExporter{})
+		span = tracer.StartTrace("ssh-login").Child("dial-target")
+		span.SetAttribute("address", "10.0.0.1:2222")
+		span.Finish(nil)
+	})
+
+	Describe("LogExporter", func() {
+		It("logs the span", func() {
+			Expect(tracing.NewLogExporter(logger).Export(span)).To(Succeed())
+
+			Expect(logger).To(gbytes.Say("trace.span"))
+			Expect(logger).To(gbytes.Say(span.TraceID))
+		})
+	})
+
+	Describe("WriterExporter", func() {
+		It("writes spans as JSON lines", func() {
+			buffer := gbytes.NewBuffer()
+			exporter := tracing.NewWriterExporter(buffer)
+
+			Expect(exporter.Export(span)).To(Succeed())
+			Expect(exporter.Export(span)).To(Succeed())
+
+			lines := strings.Split(strings.TrimSpace(string(buffer.Contents())), "\n")
+			Expect(lines).To(HaveLen(2))
+
+			var exported map[string]interface{}
+			Expect(json.Unmarshal([]byte(lines[0]), &exported)).To(Succeed())
+			Expect(exported).To(HaveKeyWithValue("trace_id", span.TraceID))
+			Expect(exported).To(HaveKeyWithValue("parent_id", span.ParentID))
+			Expect(exported).To(HaveKeyWithValue("name", "dial-target"))
+		})
+	})
+
+	Describe("FileExporter", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "tracing")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("appends spans to the file", func() {
+			path := filepath.Join(dir, "trace.log")
+
+			exporter, err := tracing.NewFileExporter(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exporter.Export(span)).To(Succeed())
+
+			contents, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(ContainSubstring(span.SpanID))
+		})
+
+		It("fails when the file cannot be opened", func() {
+			_, err := tracing.NewFileExporter(filepath.Join(dir, "missing", "trace.log"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ZipkinExporter", func() {
+		var collector *ghttp.Server
+
+		BeforeEach(func() {
+			collector = ghttp.NewServer()
+		})
+
+		AfterEach(func() {
+			collector.Close()
+		})
+
+		It("posts the span to the collector", func() {
+			posted := make(chan []map[string]interface{}, 1)
+			collector.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/api/v2/spans"),
+				ghttp.VerifyContentType("application/json"),
+				func(w http.ResponseWriter, req *http.Request) {
+					defer GinkgoRecover()
+					var spans []map[string]interface{}
+					Expect(json.NewDecoder(req.Body).Decode(&spans)).To(Succeed())
+					posted <- spans
+				},
+				ghttp.RespondWith(http.StatusAccepted, nil),
+			))
+
+			exporter := tracing.NewZipkinExporter(logger, http.DefaultClient, collector.URL()+"/api/v2/spans")
+			Expect(exporter.Export(span)).To(Succeed())
+
+			var spans []map[string]interface{}
+			Eventually(posted).Should(Receive(&spans))
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0]).To(HaveKeyWithValue("traceId", span.TraceID))
+			Expect(spans[0]).To(HaveKeyWithValue("id", span.SpanID))
+			Expect(spans[0]).To(HaveKeyWithValue("parentId", span.ParentID))
+			Expect(spans[0]).To(HaveKeyWithValue("localEndpoint", HaveKeyWithValue("serviceName", "ssh-proxy")))
+			Expect(spans[0]).To(HaveKeyWithValue("tags", HaveKeyWithValue("address", "10.0.0.1:2222")))
+		})
+
+		It("logs when the collector rejects the span", func() {
+			collector.AppendHandlers(ghttp.RespondWith(http.StatusInternalServerError, nil))
+
+			exporter := tracing.NewZipkinExporter(logger, http.DefaultClient, collector.URL())
+			Expect(exporter.Export(span)).To(Succeed())
+
+			Eventually(logger).Should(gbytes.Say("zipkin-exporter.post-failed"))
+		})
+	})
+})
@@ -0,0 +1,53 @@
+// This file was generated by counterfeiter
+package fake_tracing
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
+)
+
+type FakeExporter struct {
+	ExportStub        func(span *tracing.Span) error
+	exportMutex       sync.RWMutex
+	exportArgsForCall []struct {
+		span *tracing.Span
+	}
+	exportReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeExporter) Export(span *tracing.Span) error {
+	fake.exportMutex.Lock()
+	fake.exportArgsForCall = append(fake.exportArgsForCall, struct {
+		span *tracing.Span
+	}{span})
+	fake.exportMutex.Unlock()
+	if fake.ExportStub != nil {
+		return fake.ExportStub(span)
+	} else {
+		return fake.exportReturns.result1
+	}
+}
+
+func (fake *FakeExporter) ExportCallCount() int {
+	fake.exportMutex.RLock()
+	defer fake.exportMutex.RUnlock()
+	return len(fake.exportArgsForCall)
+}
+
+func (fake *FakeExporter) ExportArgsForCall(i int) *tracing.Span {
+	fake.exportMutex.RLock()
+	defer fake.exportMutex.RUnlock()
+	return fake.exportArgsForCall[i].span
+}
+
+func (fake *FakeExporter) ExportReturns(result1 error) {
+	fake.ExportStub = nil
+	fake.exportReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ tracing.Exporter = new(FakeExporter)
@@ -0,0 +1,28 @@
+package tracing
+
+import "golang.org/x/crypto/ssh"
+
+// TRACE_ID_ENV is the environment variable the proxy uses to pass the trace
+// id of a login to the daemon.
+const TRACE_ID_ENV = "CF_SSH_TRACE_ID"
+
+type tracedMetadata struct {
+	ssh.ConnMetadata
+	span *Span
+}
+
+// WithSpan attaches a span to the metadata handed to authenticators.
+func WithSpan(metadata ssh.ConnMetadata, span *Span) ssh.ConnMetadata {
+	if span == nil {
+		return metadata
+	}
+	return &tracedMetadata{ConnMetadata: metadata, span: span}
+}
+
+// SpanFromMetadata returns the span attached to the metadata, if any.
+func SpanFromMetadata(metadata ssh.ConnMetadata) *Span {
+	if traced, ok := metadata.(*tracedMetadata); ok {
+		return traced.span
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/lager"
+)
+
+// Tracer starts traces and hands finished spans to its exporters. A nil
+// Tracer does not trace anything.
+type Tracer struct {
+	logger      lager.Logger
+	serviceName string
+	exporters   []Exporter
+}
+
+func NewTracer(logger lager.Logger, serviceName string, exporters ...Exporter) *Tracer {
+	return &Tracer{
+		logger:      logger.Session("tracer"),
+		serviceName: serviceName,
+		exporters:   exporters,
+	}
+}
+
+// StartTrace starts the root span of a new trace.
+func (t *Tracer) StartTrace(name string) *Span {
+	if t == nil {
+		return nil
+	}
+
+	return &Span{
+		tracer:  t,
+		TraceID: randomID(16),
+		SpanID:  randomID(8),
+		Name:    name,
+		Service: t.serviceName,
+		Start:   time.Now(),
+	}
+}
+
+func (t *Tracer) export(span *Span) {
+	for _, exporter := range t.exporters {
+		if err := exporter.Export(span); err != nil {
+			t.logger.Error("export-failed", err, lager.Data{"trace-id": span.TraceID})
+		}
+	}
+}
+
+// Span times a single phase of a trace. A nil Span is a no-op so callers do
+// not have to check whether tracing is enabled.
+type Span struct {
+	tracer *Tracer
+
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Service    string            `json:"service"`
+	Start      time.Time         `json:"start"`
+	Duration   float64           `json:"duration"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+
+	lock     sync.Mutex
+	finished bool
+}
+
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+
+	return &Span{
+		tracer:   s.tracer,
+		TraceID:  s.TraceID,
+		SpanID:   randomID(8),
+		ParentID: s.SpanID,
+		Name:     name,
+		Service:  s.Service,
+		Start:    time.Now(),
+	}
+}
+
+// ID returns the trace id of the span or an empty string for a nil span.
+func (s *Span) ID() string {
+	if s == nil {
+		return ""
+	}
+	return s.TraceID
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.Attributes == nil {
+		s.Attributes = map[string]string{}
+	}
+	s.Attributes[key] = value
+}
+
+// Finish records the duration and outcome of the span and exports it. Only
+// the first call has any effect.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	if s.finished {
+		s.lock.Unlock()
+		return
+	}
+	s.finished = true
+	s.Duration = time.Since(s.Start).Seconds()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.lock.Unlock()
+
+	s.tracer.export(s)
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
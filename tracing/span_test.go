@@ -0,0 +1,78 @@
+package tracing_test
+
+import (
+	"errors"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing"
+	"github.com/cloudfoundry-incubator/diego-ssh/tracing/fake_tracing"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracer", func() {
+	var (
+		exporter *fake_tracing.FakeExporter
+		tracer   *tracing.Tracer
+	)
+
+	BeforeEach(func() {
+		exporter = &fake_tracing.FakeExporter{}
+		tracer = tracing.NewTracer(lagertest.NewTestLogger("test"), "ssh-proxy", exporter)
+	})
+
+	It("exports spans when they finish", func() {
+		root := tracer.StartTrace("ssh-login")
+		child := root.Child("handshake")
+		child.SetAttribute("user", "diego:some-guid/0")
+
+		child.Finish(errors.New("boom"))
+		root.Finish(nil)
+
+		Expect(exporter.ExportCallCount()).To(Equal(2))
+
+		exported := exporter.ExportArgsForCall(0)
+		Expect(exported.Name).To(Equal("handshake"))
+		Expect(exported.Service).To(Equal("ssh-proxy"))
+		Expect(exported.TraceID).To(HaveLen(32))
+		Expect(exported.TraceID).To(Equal(root.TraceID))
+		Expect(exported.ParentID).To(Equal(root.SpanID))
+		Expect(exported.Attributes).To(HaveKeyWithValue("user", "diego:some-guid/0"))
+		Expect(exported.Error).To(Equal("boom"))
+
+		exported = exporter.ExportArgsForCall(1)
+		Expect(exported.Name).To(Equal("ssh-login"))
+		Expect(exported.ParentID).To(BeEmpty())
+		Expect(exported.Error).To(BeEmpty())
+	})
+
+	It("exports a span only once", func() {
+		span := tracer.StartTrace("ssh-login")
+		span.Finish(nil)
+		span.Finish(errors.New("boom"))
+
+		Expect(exporter.ExportCallCount()).To(Equal(1))
+		Expect(exporter.ExportArgsForCall(0).Error).To(BeEmpty())
+	})
+
+	It("starts a new trace for every root span", func() {
+		Expect(tracer.StartTrace("a").TraceID).NotTo(Equal(tracer.StartTrace("b").TraceID))
+	})
+
+	Context("when the tracer is nil", func() {
+		It("does not trace", func() {
+			var nilTracer *tracing.Tracer
+
+			span := nilTracer.StartTrace("ssh-login")
+			Expect(span).To(BeNil())
+
+			child := span.Child("handshake")
+			child.SetAttribute("key", "value")
+			child.Finish(nil)
+
+			Expect(child).To(BeNil())
+			Expect(span.ID()).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,85 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type serverError struct {
+	status string
+}
+
+func (s serverError) Error() string {
+	return fmt.Sprintf("upstream responded with %s", s.status)
+}
+
+type roundTripper struct {
+	upstream *Upstream
+	base     http.RoundTripper
+}
+
+// NewRoundTripper guards HTTP requests with the upstream. GET and HEAD
+// requests are retried on transport errors and 5xx responses; other methods
+// are attempted once.
+func NewRoundTripper(upstream *Upstream, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &roundTripper{
+		upstream: upstream,
+		base:     base,
+	}
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := 1
+	if req.Method == "GET" || req.Method == "HEAD" {
+		attempts = rt.upstream.config.Retries + 1
+	}
+
+	var resp *http.Response
+	err := rt.upstream.do(attempts, func() error {
+		if resp != nil {
+			resp.Body.Close()
+			resp = nil
+		}
+
+		var err error
+		resp, err = rt.base.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return serverError{status: resp.Status}
+		}
+
+		return nil
+	})
+
+	if _, ok := err.(serverError); ok {
+		return resp, nil
+	}
+
+	if err != nil {
+		// The breaker can open after a server error and fail the retry, which
+		// leaves the response of the failed attempt behind.
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (rt *roundTripper) CancelRequest(req *http.Request) {
+	type canceler interface {
+		CancelRequest(*http.Request)
+	}
+
+	if c, ok := rt.base.(canceler); ok {
+		c.CancelRequest(req)
+	}
+}
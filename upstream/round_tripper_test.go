@@ -0,0 +1,152 @@
+package upstream_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/upstream"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RoundTripper", func() {
+	var (
+		server *ghttp.Server
+		client *http.Client
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+
+		up := upstream.New(lagertest.NewTestLogger("test"), "Test", upstream.Config{
+			Retries:          2,
+			RetryBackoff:     time.Millisecond,
+			FailureThreshold: 5,
+			ResetTimeout:     time.Minute,
+		})
+		client = &http.Client{Transport: upstream.NewRoundTripper(up, nil)}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when a GET receives a server error", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusBadGateway, "bad"),
+				ghttp.RespondWith(http.StatusOK, "good"),
+			)
+		})
+
+		It("retries the request", func() {
+			resp, err := client.Get(server.URL())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("good"))
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+	})
+
+	Context("when every attempt receives a server error", func() {
+		BeforeEach(func() {
+			server.AllowUnhandledRequests = true
+			server.UnhandledRequestStatusCode = http.StatusServiceUnavailable
+		})
+
+		It("returns the last response", func() {
+			resp, err := client.Get(server.URL())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(server.ReceivedRequests()).To(HaveLen(3))
+		})
+	})
+
+	Context("when a client error is received", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, ""))
+		})
+
+		It("does not retry", func() {
+			resp, err := client.Get(server.URL())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Context("when the request is not idempotent", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusInternalServerError, ""))
+		})
+
+		It("does not retry", func() {
+			resp, err := client.Post(server.URL(), "text/plain", strings.NewReader("body"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Context("when the circuit opens after a server error", func() {
+		var body *closeRecorder
+
+		BeforeEach(func() {
+			body = &closeRecorder{Reader: strings.NewReader("bad")}
+
+			up := upstream.New(lagertest.NewTestLogger("test"), "Test", upstream.Config{
+				Retries:          2,
+				RetryBackoff:     time.Millisecond,
+				FailureThreshold: 1,
+				ResetTimeout:     time.Minute,
+			})
+			client = &http.Client{Transport: upstream.NewRoundTripper(up, roundTripperFunc(func(*http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusInternalServerError, Status: "500 Internal Server Error", Body: body}, nil
+			}))}
+		})
+
+		It("closes the response of the failed attempt", func() {
+			_, err := client.Get(server.URL())
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*url.Error).Err).To(Equal(upstream.UnavailableErr))
+			Expect(body.closed).To(BeTrue())
+		})
+	})
+
+	Context("when the circuit is open", func() {
+		It("fails with an unavailable error", func() {
+			_, err := client.Get("http://127.0.0.1:1")
+			Expect(err).To(BeAssignableToTypeOf(&url.Error{}))
+
+			_, err = client.Get("http://127.0.0.1:1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*url.Error).Err).To(Equal(upstream.UnavailableErr))
+		})
+	})
+})
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
@@ -0,0 +1,198 @@
+package upstream
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/stats"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/pivotal-golang/lager"
+)
+
+var UnavailableErr = errors.New("Platform API unavailable")
+
+var requestDuration = stats.NewSummary("ssh_proxy_upstream_request_duration_seconds", "UpstreamLatency", "Duration of requests to upstream APIs", "upstream")
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type Config struct {
+	Retries          int
+	RetryBackoff     time.Duration
+	FailureThreshold int
+	ResetTimeout     time.Duration
+}
+
+type permanentError struct {
+	err error
+}
+
+func (p permanentError) Error() string {
+	return p.err.Error()
+}
+
+// Permanent marks an error as a definitive answer from the upstream. Permanent
+// errors are not retried and do not count against the circuit breaker.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// Upstream guards calls to a platform API with retries and a circuit breaker.
+// The breaker state is emitted as the <name>CircuitBreakerState value metric.
+type Upstream struct {
+	logger lager.Logger
+	name   string
+	config Config
+
+	mutex    sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func New(logger lager.Logger, name string, config Config) *Upstream {
+	u := &Upstream{
+		logger: logger.Session("upstream", lager.Data{"name": name}),
+		name:   name,
+		config: config,
+	}
+	u.emitState()
+
+	return u
+}
+
+func (u *Upstream) Name() string {
+	return u.name
+}
+
+func (u *Upstream) State() State {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.state
+}
+
+// Do calls op until it succeeds, returns a permanent error, or the configured
+// retries are exhausted. When the breaker is open, Do fails with
+// UnavailableErr without calling op.
+func (u *Upstream) Do(op func() error) error {
+	return u.do(u.config.Retries+1, op)
+}
+
+func (u *Upstream) do(attempts int, op func() error) error {
+	backoff := u.config.RetryBackoff
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if !u.allow() {
+			u.logger.Info("rejected", lager.Data{"state": u.State().String()})
+			return UnavailableErr
+		}
+
+		started := time.Now()
+		err = op()
+		requestDuration.Observe(time.Since(started), u.name)
+
+		if permanent, ok := err.(permanentError); ok {
+			u.succeeded()
+			return permanent.err
+		}
+
+		if err == nil {
+			u.succeeded()
+			return nil
+		}
+
+		u.failed(err)
+
+		if attempt < attempts {
+			u.logger.Info("retrying", lager.Data{"attempt": attempt, "backoff": backoff.String()})
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	return err
+}
+
+func (u *Upstream) allow() bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	switch u.state {
+	case Open:
+		if time.Since(u.openedAt) < u.config.ResetTimeout {
+			return false
+		}
+		u.setState(HalfOpen)
+		u.probing = true
+		return true
+	case HalfOpen:
+		if u.probing {
+			return false
+		}
+		u.probing = true
+		return true
+	}
+
+	return true
+}
+
+func (u *Upstream) succeeded() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.failures = 0
+	u.probing = false
+	if u.state != Closed {
+		u.setState(Closed)
+	}
+}
+
+func (u *Upstream) failed(err error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.logger.Error("call-failed", err)
+
+	u.failures++
+	u.probing = false
+	if u.state == HalfOpen || (u.config.FailureThreshold > 0 && u.failures >= u.config.FailureThreshold) {
+		u.openedAt = time.Now()
+		if u.state != Open {
+			u.setState(Open)
+		}
+	}
+}
+
+func (u *Upstream) setState(state State) {
+	u.logger.Info("state-changed", lager.Data{"from": u.state.String(), "to": state.String()})
+	u.state = state
+	u.emitState()
+}
+
+func (u *Upstream) emitState() {
+	metrics.SendValue(u.name+"CircuitBreakerState", float64(u.state), "State")
+}
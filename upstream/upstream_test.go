@@ -0,0 +1,112 @@
+package upstream_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudfoundry-incubator/diego-ssh/upstream"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Upstream", func() {
+	var (
+		config   upstream.Config
+		up       *upstream.Upstream
+		calls    int
+		failWith error
+		op       func() error
+	)
+
+	BeforeEach(func() {
+		config = upstream.Config{
+			Retries:          2,
+			RetryBackoff:     time.Millisecond,
+			FailureThreshold: 3,
+			ResetTimeout:     50 * time.Millisecond,
+		}
+
+		calls = 0
+		failWith = nil
+		op = func() error {
+			calls++
+			return failWith
+		}
+	})
+
+	JustBeforeEach(func() {
+		up = upstream.New(lagertest.NewTestLogger("test"), "Test", config)
+	})
+
+	It("starts closed", func() {
+		Expect(up.State()).To(Equal(upstream.Closed))
+		Expect(up.Name()).To(Equal("Test"))
+	})
+
+	It("calls the operation once when it succeeds", func() {
+		Expect(up.Do(op)).To(Succeed())
+		Expect(calls).To(Equal(1))
+	})
+
+	Context("when the operation fails", func() {
+		BeforeEach(func() {
+			failWith = errors.New("boom")
+		})
+
+		It("retries with the configured number of retries", func() {
+			Expect(up.Do(op)).To(Equal(failWith))
+			Expect(calls).To(Equal(3))
+		})
+
+		It("opens the circuit after the failure threshold is reached", func() {
+			up.Do(op)
+			Expect(up.State()).To(Equal(upstream.Open))
+		})
+
+		It("fails fast while the circuit is open", func() {
+			up.Do(op)
+			Expect(up.Do(op)).To(Equal(upstream.UnavailableErr))
+			Expect(calls).To(Equal(3))
+		})
+
+		Context("and the reset timeout has passed", func() {
+			JustBeforeEach(func() {
+				up.Do(op)
+				time.Sleep(2 * config.ResetTimeout)
+			})
+
+			It("closes the circuit when the probe succeeds", func() {
+				failWith = nil
+				Expect(up.Do(op)).To(Succeed())
+				Expect(up.State()).To(Equal(upstream.Closed))
+				Expect(calls).To(Equal(4))
+			})
+
+			It("reopens the circuit when the probe fails", func() {
+				Expect(up.Do(op)).To(Equal(upstream.UnavailableErr))
+				Expect(up.State()).To(Equal(upstream.Open))
+				Expect(calls).To(Equal(4))
+			})
+		})
+	})
+
+	Context("when the operation fails permanently", func() {
+		var permanentErr error
+
+		BeforeEach(func() {
+			permanentErr = errors.New("not found")
+			op = func() error {
+				calls++
+				return upstream.Permanent(permanentErr)
+			}
+		})
+
+		It("returns the underlying error without retrying", func() {
+			Expect(up.Do(op)).To(Equal(permanentErr))
+			Expect(calls).To(Equal(1))
+			Expect(up.State()).To(Equal(upstream.Closed))
+		})
+	})
+})